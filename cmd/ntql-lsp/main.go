@@ -0,0 +1,45 @@
+// Command ntql-lsp is a Language Server Protocol server for NTQL queries. It
+// speaks JSON-RPC over stdin and stdout.
+//
+// Usage:
+//
+//	ntql-lsp [-schema schema.yaml] [-tags school,work]
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/DeonteVanterpool/ntql"
+	"github.com/DeonteVanterpool/ntql/lsp"
+)
+
+func main() {
+	schemaPath := flag.String("schema", "", "schema.yaml to check queries against (default: the embedded schema)")
+	tags := flag.String("tags", "", "comma-separated tags to offer as completions")
+	flag.Parse()
+
+	// stdout carries the protocol, so logs go to stderr.
+	log.SetOutput(os.Stderr)
+	log.SetPrefix("ntql-lsp: ")
+
+	schema := ntql.DefaultSchema()
+	if *schemaPath != "" {
+		var err error
+		schema, err = ntql.NewSchemaFromFile(*schemaPath)
+		if err != nil {
+			log.Fatalf("loading schema: %v", err)
+		}
+	}
+
+	var tagList []string
+	if *tags != "" {
+		tagList = strings.Split(*tags, ",")
+	}
+
+	if err := lsp.NewServer(schema, tagList).Serve(os.Stdin, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,111 @@
+// Command ntqlfmt formats NTQL queries. Each file holds one query, which may
+// span several lines; it is rewritten as a single canonical line followed by
+// a newline. Without file arguments it formats standard input.
+//
+// Usage:
+//
+//	ntqlfmt [-schema schema.yaml] [-l] [-w] [file ...]
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/DeonteVanterpool/ntql"
+)
+
+func main() {
+	schemaPath := flag.String("schema", "", "schema.yaml to parse queries with (default: the embedded schema)")
+	list := flag.Bool("l", false, "list files whose formatting differs from ntqlfmt's")
+	write := flag.Bool("w", false, "write the result to the file instead of standard output")
+	flag.Parse()
+
+	schema := ntql.DefaultSchema()
+	if *schemaPath != "" {
+		var err error
+		schema, err = ntql.NewSchemaFromFile(*schemaPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ntqlfmt: loading schema: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	if flag.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "ntqlfmt: cannot use -w with standard input")
+			os.Exit(2)
+		}
+		src, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ntqlfmt: %v\n", err)
+			os.Exit(1)
+		}
+		if !format(schema, "<standard input>", src, *list, false) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	failed := false
+	for _, path := range flag.Args() {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ntqlfmt: %v\n", err)
+			failed = true
+			continue
+		}
+		if !format(schema, path, src, *list, *write) {
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// format formats the query in src, read from path, and lists, writes or
+// prints the result. It reports errors on stderr and returns false if there
+// were any.
+func format(schema *ntql.Schema, path string, src []byte, list, write bool) bool {
+	err := formatQuery(schema, path, src, list, write)
+	if err == nil {
+		return true
+	}
+	if d, ok := ntql.DiagnosticOf(err); ok {
+		fmt.Fprintf(os.Stderr, "%s:\n%s\n", path, d.Render(string(src)))
+	} else {
+		fmt.Fprintf(os.Stderr, "ntqlfmt: %s: %v\n", path, err)
+	}
+	return false
+}
+
+func formatQuery(schema *ntql.Schema, path string, src []byte, list, write bool) error {
+	formatted, err := ntql.FormatQuery(schema, string(src))
+	if err != nil {
+		return err
+	}
+	out := []byte(formatted + "\n")
+	if bytes.Equal(src, out) {
+		if !list && !write {
+			_, err = os.Stdout.Write(out)
+		}
+		return err
+	}
+	if list {
+		fmt.Println(path)
+	}
+	if write {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(path, out, info.Mode().Perm())
+	}
+	if !list {
+		_, err = os.Stdout.Write(out)
+	}
+	return err
+}
@@ -0,0 +1,186 @@
+package ntql
+
+import (
+	"context"
+	"fmt"
+)
+
+// OutputMode selects the SQL that Compile generates.
+type OutputMode int
+
+const (
+	// OutputSelect generates a complete SELECT statement that joins every
+	// table the query uses, as BuildSQLJoinQuery does. It is the default.
+	OutputSelect OutputMode = iota
+	// OutputWhere generates a condition for a WHERE clause on the root table,
+	// as ToSQLWithOptions does. Subjects are written as their columns, and
+	// subjects in other tables, other than tag, are rejected.
+	OutputWhere
+	// OutputNone only parses and checks the query.
+	OutputNone
+)
+
+// CompileOptions controls how Compile parses a query and generates SQL for it.
+type CompileOptions struct {
+	// Schema resolves subjects, verbs and joins. The default schema is used
+	// when it is nil.
+	Schema *Schema
+	// Dialect selects the database engine to generate SQL for. GenericDialect
+	// is used when it is nil.
+	Dialect Dialect
+	// Parameterized replaces every value with a placeholder and returns the
+	// values in CompileResult.Args.
+	Parameterized bool
+	// Output selects the SQL to generate.
+	Output OutputMode
+	// Distinct and BaseTable are passed on to the join builder when Output is
+	// OutputSelect.
+	Distinct  bool
+	BaseTable string
+	// Clock resolves relative dates such as today or startOfWeek.
+	Clock Clock
+
+	// MaxLength is the longest query, in bytes, that is parsed. MaxDepth is
+	// the deepest nesting of AND, OR and NOT allowed, and MaxConditions the
+	// most conditions allowed. A limit of zero is not checked.
+	MaxLength     int
+	MaxDepth      int
+	MaxConditions int
+}
+
+// CompileResult is the output of Compile.
+type CompileResult struct {
+	// Expr is the parsed query. It contains QueryError nodes in place of the
+	// parts that could not be parsed, and is nil if the query has no tokens.
+	Expr QueryExpr
+	// SQL and Args hold the generated SQL. They are empty when the query has
+	// diagnostics or Output is OutputNone.
+	SQL  string
+	Args []any
+	// Subjects lists the subjects the query refers to by their schema names,
+	// in order of first appearance.
+	Subjects []string
+	// Joins lists the schema joins used by an OutputSelect query.
+	Joins []SchemaJoin
+	// Diagnostics lists every problem found in the query, ordered by position.
+	Diagnostics []Diagnostic
+}
+
+// Compile lexes, parses and checks query, then generates SQL for it as
+// selected by opts. Parsing does not stop at the first error, so the result
+// lists every diagnostic; the first one is also returned as the error. The
+// result is returned alongside any error, so the parts that were compiled can
+// still be inspected.
+func Compile(ctx context.Context, query string, opts CompileOptions) (*CompileResult, error) {
+	result := &CompileResult{}
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	schema := opts.Schema
+	if schema == nil {
+		schema = DefaultSchema()
+	}
+
+	if opts.MaxLength > 0 && len(query) > opts.MaxLength {
+		result.Diagnostics = []Diagnostic{{
+			Code:    CodeLimitExceeded,
+			Message: fmt.Sprintf("Query is %d bytes long, the limit is %d", len(query), opts.MaxLength),
+			Start:   opts.MaxLength,
+			End:     len(query),
+		}}
+		return result, result.Diagnostics[0]
+	}
+
+	result.Expr, result.Diagnostics = ParseWithRecovery(schema, query)
+	if result.Expr == nil && len(result.Diagnostics) == 0 {
+		result.Diagnostics = []Diagnostic{{Code: CodeUnexpectedEnd, Message: "Empty query"}}
+	}
+	if result.Expr != nil {
+		stats := inspectQuery(result.Expr)
+		result.Subjects = stats.subjects
+		if opts.MaxDepth > 0 && stats.depth > opts.MaxDepth {
+			result.Diagnostics = append(result.Diagnostics, Diagnostic{
+				Code:    CodeLimitExceeded,
+				Message: fmt.Sprintf("Query is nested %d levels deep, the limit is %d", stats.depth, opts.MaxDepth),
+				End:     len(query),
+			})
+		}
+		if opts.MaxConditions > 0 && stats.conditions > opts.MaxConditions {
+			result.Diagnostics = append(result.Diagnostics, Diagnostic{
+				Code:    CodeLimitExceeded,
+				Message: fmt.Sprintf("Query has %d conditions, the limit is %d", stats.conditions, opts.MaxConditions),
+				End:     len(query),
+			})
+		}
+	}
+	if len(result.Diagnostics) > 0 {
+		return result, result.Diagnostics[0]
+	}
+
+	if err := ctx.Err(); err != nil {
+		return result, err
+	}
+	switch opts.Output {
+	case OutputWhere:
+		sql, args, err := writeSQL(result.Expr, SQLOptions{
+			Dialect:       opts.Dialect,
+			Parameterized: opts.Parameterized,
+			Clock:         opts.Clock,
+			Schema:        schema,
+		})
+		if err != nil {
+			return result, err
+		}
+		result.SQL, result.Args = sql, args
+	case OutputSelect:
+		joinOpts := JoinQueryOptions{
+			Distinct:  opts.Distinct,
+			Schema:    schema,
+			Dialect:   opts.Dialect,
+			BaseTable: opts.BaseTable,
+			Clock:     opts.Clock,
+		}
+		w := newSQLWriter(SQLOptions{Dialect: opts.Dialect, Parameterized: opts.Parameterized, Clock: opts.Clock, Schema: schema})
+		sql, joins, err := buildSQLJoinQuery(result.Expr, joinOpts, w)
+		if err != nil {
+			return result, err
+		}
+		result.SQL, result.Args, result.Joins = sql, w.args, joins
+	case OutputNone:
+	default:
+		return result, fmt.Errorf("unknown output mode: %d", opts.Output)
+	}
+	return result, nil
+}
+
+// queryStats summarises the shape of a query expression.
+type queryStats struct {
+	subjects   []string
+	depth      int
+	conditions int
+}
+
+func inspectQuery(expr QueryExpr) queryStats {
+	var stats queryStats
+	seen := map[string]struct{}{}
+	depth := -1
+	Walk(expr, VisitorFuncs{
+		EnterFunc: func(expr QueryExpr) error {
+			depth++
+			stats.depth = max(stats.depth, depth)
+			if node, ok := expr.(*QueryCondition); ok {
+				stats.conditions++
+				if _, ok := seen[node.Field]; !ok {
+					seen[node.Field] = struct{}{}
+					stats.subjects = append(stats.subjects, node.Field)
+				}
+			}
+			return nil
+		},
+		LeaveFunc: func(QueryExpr) error {
+			depth--
+			return nil
+		},
+	})
+	return stats
+}
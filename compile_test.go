@@ -0,0 +1,132 @@
+package ntql
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompileWhere(t *testing.T) {
+	result, err := Compile(context.Background(), `title.contains("report") AND priority.eq(3)`, CompileOptions{Parameterized: true, Output: OutputWhere})
+	if err != nil {
+		t.Fatalf("Compile() failed: %v", err)
+	}
+	if result.SQL != `(title LIKE ? ESCAPE '\' AND priority = ?)` {
+		t.Fatalf("unexpected SQL: %s", result.SQL)
+	}
+	if !reflect.DeepEqual(result.Args, []any{"%report%", int64(3)}) {
+		t.Fatalf("unexpected args: %v", result.Args)
+	}
+	if !reflect.DeepEqual(result.Subjects, []string{"title", "priority"}) {
+		t.Fatalf("unexpected subjects: %v", result.Subjects)
+	}
+	if result.Expr == nil || len(result.Diagnostics) != 0 || result.Joins != nil {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+}
+
+func TestCompileSelect(t *testing.T) {
+	schema := loadJoinTestSchema(t)
+	result, err := Compile(context.Background(), `owner.eq("Alice") OR (project.eq("Web") AND assignee.eq("Bob"))`, CompileOptions{
+		Schema:  schema,
+		Dialect: PostgresDialect{},
+		Output:  OutputSelect,
+	})
+	if err != nil {
+		t.Fatalf("Compile() failed: %v", err)
+	}
+	assertStringContainsAll(t, result.SQL,
+		`FROM "tasks" t0`,
+		`LEFT JOIN "task_assignments"`,
+		`LEFT JOIN "projects"`,
+	)
+	if !reflect.DeepEqual(result.Subjects, []string{"assignee", "project"}) {
+		t.Fatalf("unexpected subjects: %v", result.Subjects)
+	}
+	if len(result.Joins) != 2 || result.Joins[0].ToTable != "task_assignments" || result.Joins[1].ToTable != "projects" {
+		t.Fatalf("unexpected joins: %+v", result.Joins)
+	}
+}
+
+func TestCompileSubjectColumns(t *testing.T) {
+	clock := Clock{Now: func() time.Time { return time.Date(2024, 3, 6, 12, 0, 0, 0, time.UTC) }}
+
+	// The default output joins the tables of every subject.
+	result, err := Compile(context.Background(), `due.before(today) AND project.equals("x")`, CompileOptions{Clock: clock})
+	if err != nil {
+		t.Fatalf("Compile() failed: %v", err)
+	}
+	assertStringContainsAll(t, result.SQL,
+		"t0.due_date < '2024-03-06'",
+		"LEFT JOIN projects t1 ON t0.project_id = t1.id",
+		"t1.name = 'x'",
+	)
+
+	// A WHERE clause writes subjects as their columns, but cannot reach other
+	// tables.
+	result, err = Compile(context.Background(), `due.before(today)`, CompileOptions{Clock: clock, Output: OutputWhere})
+	if err != nil || result.SQL != "due_date < '2024-03-06'" {
+		t.Fatalf("unexpected WHERE clause for a date subject: %s, %v", result.SQL, err)
+	}
+	if _, err := Compile(context.Background(), `project.equals("x")`, CompileOptions{Output: OutputWhere}); err == nil || !strings.Contains(err.Error(), "subject project is in table projects") {
+		t.Fatalf("expected a joined subject to be rejected in a WHERE clause, got %v", err)
+	}
+}
+
+func TestCompileReportsAllDiagnostics(t *testing.T) {
+	query := `titel.contains("a") AND title.contans("b")`
+	result, err := Compile(context.Background(), query, CompileOptions{})
+	if err == nil {
+		t.Fatalf("expected an error for %s", query)
+	}
+	if len(result.Diagnostics) != 2 {
+		t.Fatalf("expected 2 diagnostics, got %v", result.Diagnostics)
+	}
+	if d, ok := DiagnosticOf(err); !ok || d.Code != CodeUnknownSubject {
+		t.Fatalf("expected the first diagnostic as the error, got %v", err)
+	}
+	if result.Expr == nil || result.SQL != "" {
+		t.Fatalf("expected a partial expression and no SQL, got %+v", result)
+	}
+}
+
+func TestCompileLimits(t *testing.T) {
+	query := `title.eq("a") OR title.eq("b") OR !title.eq("c")`
+	cases := []struct {
+		opts CompileOptions
+		ok   bool
+	}{
+		{CompileOptions{MaxLength: len(query)}, true},
+		{CompileOptions{MaxLength: 10}, false},
+		{CompileOptions{MaxDepth: 2}, true},
+		{CompileOptions{MaxDepth: 1}, false},
+		{CompileOptions{MaxConditions: 3}, true},
+		{CompileOptions{MaxConditions: 2}, false},
+	}
+	for _, tc := range cases {
+		tc.opts.Output = OutputNone
+		_, err := Compile(context.Background(), query, tc.opts)
+		if tc.ok && err != nil {
+			t.Fatalf("expected %+v to pass, got %v", tc.opts, err)
+		}
+		if !tc.ok {
+			if d, ok := DiagnosticOf(err); !ok || d.Code != CodeLimitExceeded {
+				t.Fatalf("expected %+v to exceed a limit, got %v", tc.opts, err)
+			}
+		}
+	}
+}
+
+func TestCompileEmptyAndCancelled(t *testing.T) {
+	if _, err := Compile(context.Background(), "  ", CompileOptions{}); err == nil {
+		t.Fatalf("expected an error for an empty query")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Compile(ctx, `title.eq("a")`, CompileOptions{}); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
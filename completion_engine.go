@@ -1,7 +1,10 @@
 package ntql
 
 import (
+	"context"
 	"fmt"
+	"slices"
+	"strings"
 
 	trie "github.com/Vivino/go-autocomplete-trie"
 )
@@ -13,8 +16,12 @@ type MegaTrie struct {
 }
 
 func NewMegaTrie() *MegaTrie {
+	return newMegaTrie(DefaultSchema())
+}
+
+func newMegaTrie(schema *Schema) *MegaTrie {
 	subjectTrie := trie.New()
-	for _, subject := range validSubjects {
+	for _, subject := range schema.subjects {
 		for _, alias := range subject.Aliases {
 			subjectTrie.Insert(alias)
 		}
@@ -25,26 +32,34 @@ func NewMegaTrie() *MegaTrie {
 	}
 }
 
-// CompletionEngine is a trie-based autocompletion engine for NTQL
+// CompletionEngine is a trie-based autocompletion engine for NTQL.
+//
+// An engine is not modified after it is built; every call keeps its state
+// locally. It is safe for concurrent use, so a single engine can be shared
+// between goroutines, such as the handlers of an HTTP server. Value providers
+// shared this way must be safe for concurrent use as well.
 type CompletionEngine struct {
 	subjectTrie   *trie.Trie
 	connectorTrie *trie.Trie
 	tagTrie       *trie.Trie
 	tags          []string
 	subjects      []string
-	verbs         []string
 	connectors    []string
+	// verbTries and verbs hold the verbs and aliases of each subject, keyed
+	// by the lower-cased subject name.
+	verbTries map[string]*trie.Trie
+	verbs     map[string][]string
+
+	schema *Schema
 
-	lexer *Lexer
+	providers  map[string]ValueProvider
+	maxValues  int
+	valueCache *valueCache
 }
 
+// GetValidSubjects returns the subject names and aliases of the default schema.
 func GetValidSubjects() []string {
-	subjects := make([]string, 0)
-	for _, subject := range validSubjects {
-		subjects = append(subjects, subject.Name)
-		subjects = append(subjects, subject.Aliases...)
-	}
-	return subjects
+	return DefaultSchema().SubjectNames()
 }
 
 func GetValidConnectors() []string {
@@ -55,15 +70,51 @@ func GetValidConnectors() []string {
 	return connectors
 }
 
+// NewCompletionEngine creates a completion engine for the default schema.
 func NewCompletionEngine(tags []string) *CompletionEngine {
+	return NewCompletionEngineWithSchema(DefaultSchema(), tags)
+}
+
+// NewCompletionEngineWithSchema creates a completion engine for schema.
+func NewCompletionEngineWithSchema(schema *Schema, tags []string) *CompletionEngine {
+	return NewCompletionEngineWithOptions(schema, CompletionOptions{Tags: tags})
+}
+
+// NewCompletionEngineWithOptions creates a completion engine for schema that
+// suggests values from the providers in opts. Provider keys that do not name a
+// subject of the schema are ignored.
+func NewCompletionEngineWithOptions(schema *Schema, opts CompletionOptions) *CompletionEngine {
+	providers := map[string]ValueProvider{}
+	for name, provider := range opts.ValueProviders {
+		if subject, err := schema.Subject(name); err == nil {
+			providers[toLowerCase(subject.Name)] = provider
+		}
+	}
+	var cache *valueCache
+	if opts.CacheTTL > 0 {
+		cache = newValueCache(opts.CacheTTL)
+	}
+	verbTries := map[string]*trie.Trie{}
+	verbs := map[string][]string{}
+	for _, subject := range schema.subjects {
+		key := toLowerCase(subject.Name)
+		verbTries[key], verbs[key] = newVerbTrie(subject)
+	}
 	return &CompletionEngine{
-		subjectTrie:   NewMegaTrie().subjectTrie,
+		subjectTrie:   newMegaTrie(schema).subjectTrie,
 		connectorTrie: NewConnectorTrie(),
-		tagTrie:       NewTagTrie(tags),
+		tagTrie:       NewTagTrie(opts.Tags),
 
-		tags:       tags,
-		subjects:   GetValidSubjects(),
+		tags:       slices.Clone(opts.Tags),
+		subjects:   schema.SubjectNames(),
 		connectors: GetValidConnectors(),
+		verbTries:  verbTries,
+		verbs:      verbs,
+		schema:     schema,
+
+		providers:  providers,
+		maxValues:  opts.MaxValues,
+		valueCache: cache,
 	}
 }
 
@@ -79,44 +130,107 @@ func NewConnectorTrie() *trie.Trie {
 func NewTagTrie(tags []string) *trie.Trie {
 	tagTrie := trie.New()
 	for _, tag := range tags {
-		tagTrie.Insert(tag, tag)
+		tagTrie.Insert(tag)
 	}
 
 	return tagTrie
 }
 
 func (e *CompletionEngine) Suggest(s string) ([]string, error) {
+	return e.SuggestContext(context.Background(), s)
+}
+
+// SuggestContext is like Suggest, but passes ctx to the value providers and
+// stops when it is cancelled.
+func (e *CompletionEngine) SuggestContext(ctx context.Context, s string) ([]string, error) {
+	c, err := e.locate(s)
+	if err != nil {
+		return nil, err
+	}
+	return e.suggest(ctx, c)
+}
+
+// completionContext describes what is being completed at the end of a query.
+type completionContext struct {
+	kind CompletionKind
+	// subject is the subject of the function call being completed, if any.
+	subject *Subject
+	// prefix is the part of the word typed so far.
+	prefix string
+	// none is set where nothing can be suggested, such as right after a
+	// subject.
+	none bool
+}
+
+// suggest returns the names and values that start with the context's prefix,
+// in schema order.
+func (e *CompletionEngine) suggest(ctx context.Context, c completionContext) ([]string, error) {
+	if c.none {
+		return []string{}, nil
+	}
+	switch c.kind {
+	case CompletionVerb:
+		return e.suggestFromSubject(*c.subject, c.prefix)
+	case CompletionConnector:
+		return e.suggestConnector(c.prefix)
+	case CompletionValue:
+		return e.suggestObjects(ctx, *c.subject, c.prefix)
+	}
+	return e.SuggestSubject(c.prefix)
+}
+
+// locate lexes s and works out what is being completed at its end.
+func (e *CompletionEngine) locate(s string) (completionContext, error) {
 	if len(s) == 0 {
-		return e.SuggestSubject("")
+		return completionContext{kind: CompletionSubject}, nil
 	}
 
-	e.lexer = NewLexer(s)
+	lexer := NewLexerWithSchema(e.schema, s)
 
 	var lastToken Token
 	var lastSubject *Subject = nil
+	// partial is the last word when it is not a token yet.
+	var partial string
 	for {
-		err := e.lexer.ScanToken()
+		err := lexer.ScanToken()
 		exit := false
 		if err != nil {
 			switch err := err.(type) {
 			case ErrEndOfInput:
 				exit = true
 			case ErrInvalidSubject:
-				return e.SuggestSubject(string(err.Lexeme))
+				if lexer.atEnd() {
+					return completionContext{kind: CompletionSubject, prefix: string(err.Lexeme)}, nil
+				}
+				// Mistakes earlier in the query do not stop completion at the
+				// cursor.
+				lexer.recoverFrom(err)
 			case ErrInvalidToken:
-				return []string{}, nil
+				// The last word may be the start of a valid token, such as
+				// "A" for AND, which is completed from the previous token.
+				if lexer.atEnd() {
+					exit = true
+					partial = string(err.Lexeme)
+				} else {
+					lexer.recoverFrom(err)
+				}
+			case ErrInvalidLexeme:
+				lexer.recoverFrom(err)
 			default:
-				return nil, fmt.Errorf("Unexpected Error: %v", err.Error())
+				return completionContext{}, fmt.Errorf("Unexpected Error: %v", err.Error())
 			}
 		}
-		lastToken, err = e.lexer.lastToken()
+		lastToken, err = lexer.lastToken()
 		if err != nil {
-			return e.SuggestSubject("")
+			return completionContext{kind: CompletionSubject}, nil
 		}
 		if lastToken.Kind == TokenSubject {
-			lastSubject, err = getSubject(string(lastToken.Literal))
+			lastSubject, err = e.schema.Subject(string(lastToken.Literal))
 			if err != nil { // invalid subject
-				return e.SuggestSubject(string(lastToken.Literal))
+				if lexer.atEnd() {
+					return completionContext{kind: CompletionSubject, prefix: lastToken.Literal}, nil
+				}
+				lastSubject = &Subject{Name: lastToken.Literal, ValidTypes: allDTypes}
 			}
 		}
 		if exit {
@@ -126,58 +240,61 @@ func (e *CompletionEngine) Suggest(s string) ([]string, error) {
 
 	if lastCharSpace(s) {
 		switch lastToken.Kind {
-		case TokenSubject, TokenVerb, TokenBang, TokenLParen:
-			return []string{}, nil
-		case TokenTag, TokenBool, TokenString, TokenInt, TokenDate, TokenDateTime, TokenRParen:
-			return e.suggestConnector("")
+		case TokenSubject, TokenVerb, TokenBang, TokenLParen, TokenInvalid:
+			return completionContext{kind: CompletionValue, subject: lastSubject, none: true}, nil
+		case TokenTag, TokenBool, TokenString, TokenInt, TokenFloat, TokenDate, TokenDateTime, TokenRelativeDate, TokenRParen:
+			return completionContext{kind: CompletionConnector, subject: lastSubject}, nil
 		case TokenOr, TokenAnd:
-			if e.lexer.insideMethodCall() {
-				return e.suggestObjects(*lastSubject, "")
+			if lexer.insideMethodCall() {
+				return completionContext{kind: CompletionValue, subject: lastSubject}, nil
 			} else {
-				return e.SuggestSubject("")
+				return completionContext{kind: CompletionSubject}, nil
 			}
 		case TokenDot:
-			return e.suggestFromSubject(*lastSubject, "")
+			return completionContext{kind: CompletionVerb, subject: lastSubject}, nil
 		default:
 			panic("Unimplemented token type in switch statemen")
 		}
 	} else {
 		switch lastToken.Kind {
 		case TokenSubject:
-			return e.SuggestSubject(lastToken.Literal)
+			return completionContext{kind: CompletionSubject, prefix: lastToken.Literal}, nil
 		case TokenVerb:
-			return e.suggestFromSubject(*lastSubject, lastToken.Literal)
-		case TokenTag, TokenBool, TokenString, TokenInt, TokenDate, TokenDateTime:
-			return e.suggestObjects(*lastSubject, lastToken.Literal)
+			return completionContext{kind: CompletionVerb, subject: lastSubject, prefix: lastToken.Literal}, nil
+		case TokenTag, TokenBool, TokenString, TokenInt, TokenFloat, TokenDate, TokenDateTime, TokenRelativeDate:
+			return completionContext{kind: CompletionValue, subject: lastSubject, prefix: lastToken.Literal}, nil
 		case TokenOr, TokenAnd, TokenRParen:
-			str, _ := e.lexer.Scanner.LastLexeme() // last lexeme doesn't get turned into a token yet
-			return e.suggestConnector(string(str))
+			str, _ := lexer.Scanner.LastLexeme() // last lexeme doesn't get turned into a token yet
+			return completionContext{kind: CompletionConnector, subject: lastSubject, prefix: string(str)}, nil
 		case TokenDot:
-			return e.suggestFromSubject(*lastSubject, lastToken.Literal)
+			return completionContext{kind: CompletionVerb, subject: lastSubject}, nil
 		case TokenBang, TokenLParen:
-			if e.lexer.insideMethodCall() {
-				return e.suggestObjects(*lastSubject, "")
+			if lexer.insideMethodCall() {
+				return completionContext{kind: CompletionValue, subject: lastSubject, prefix: partial}, nil
 			} else {
-				return e.SuggestSubject("")
+				return completionContext{kind: CompletionSubject, prefix: partial}, nil
 			}
 		}
 	}
 
-	return nil, nil
+	return completionContext{kind: CompletionValue, subject: lastSubject, none: true}, nil
 }
 
 func lastCharSpace(s string) bool {
-	return s[len(s)-1] == ' '
+	return isWhitespace(s[len(s)-1])
 }
 
 func (e *CompletionEngine) suggestConnector(s string) ([]string, error) {
 	if s == "" {
-		return e.connectors, nil
+		return slices.Clone(e.connectors), nil
 	}
 	return e.connectorTrie.SearchAll(s), nil
 }
 
-func (e *CompletionEngine) suggestObjects(subject Subject, input string) ([]string, error) {
+func (e *CompletionEngine) suggestObjects(ctx context.Context, subject Subject, input string) ([]string, error) {
+	if values, ok, err := e.providedValues(ctx, subject, input); ok {
+		return values, err
+	}
 	suggestions := make([]string, 0)
 	for _, subj := range subject.ValidTypes {
 		switch subj {
@@ -187,39 +304,48 @@ func (e *CompletionEngine) suggestObjects(subject Subject, input string) ([]stri
 				continue
 			}
 			suggestions = append(suggestions, e.tagTrie.SearchAll(input)...)
-		case DTypeString, DTypeInt:
+		case DTypeString, DTypeInt, DTypeFloat:
 		case DTypeDate:
-			suggestions = append(suggestions, "today", "yesterday", "tomorrow")
+			suggestions = append(suggestions, withPrefix(input, relativeDateSuggestions...)...)
 		case DTypeDateTime:
-			suggestions = append(suggestions, "now")
+			suggestions = append(suggestions, withPrefix(input, relativeDateTimeSuggestions...)...)
 		}
 	}
 	return suggestions, nil
 }
 
-func getSubject(s string) (*Subject, error) {
-	for _, subject := range validSubjects {
-		if toLowerCase(subject.Name) == toLowerCase(s) {
-			return &subject, nil
-		}
-		for _, alias := range subject.Aliases {
-			if toLowerCase(s) == toLowerCase(alias) {
-				return &subject, nil
-			}
+// relativeDateSuggestions and relativeDateTimeSuggestions are the relative
+// values suggested for date and date-time subjects.
+var (
+	relativeDateSuggestions     = []string{"today", "yesterday", "tomorrow", "startOfWeek", "endOfWeek", "startOfMonth", "endOfMonth"}
+	relativeDateTimeSuggestions = []string{"now"}
+)
+
+// withPrefix returns the words that start with prefix, ignoring case.
+func withPrefix(prefix string, words ...string) []string {
+	matches := make([]string, 0, len(words))
+	for _, word := range words {
+		if strings.HasPrefix(strings.ToLower(word), strings.ToLower(prefix)) {
+			matches = append(matches, word)
 		}
 	}
+	return matches
+}
 
-	return nil, ErrInvalidToken{}
+func getSubject(s string) (*Subject, error) {
+	return DefaultSchema().Subject(s)
 }
 
 func (e *CompletionEngine) SuggestSubject(s string) ([]string, error) {
 	if s == "" {
-		return e.subjects, nil
+		return slices.Clone(e.subjects), nil
 	}
 	return e.subjectTrie.SearchAll(s), nil
 }
 
-func (e *CompletionEngine) buildVerbTrie(subject Subject) *trie.Trie {
+// newVerbTrie returns a trie of the subject's verbs and aliases, and the same
+// names in schema order.
+func newVerbTrie(subject Subject) (*trie.Trie, []string) {
 	verbTrie := trie.New()
 	verbs := make([]string, 0)
 	for _, verb := range subject.ValidVerbs {
@@ -230,17 +356,19 @@ func (e *CompletionEngine) buildVerbTrie(subject Subject) *trie.Trie {
 			verbs = append(verbs, alias)
 		}
 	}
-
-	e.verbs = verbs
-	return verbTrie
+	return verbTrie, verbs
 }
 
 func (e *CompletionEngine) suggestFromSubject(subject Subject, verb string) ([]string, error) {
-
+	key := toLowerCase(subject.Name)
+	verbTrie, ok := e.verbTries[key]
+	if !ok {
+		// Subjects that are not in the schema have no verbs.
+		return []string{}, nil
+	}
 	if verb == "" {
-		return e.verbs, nil
+		return slices.Clone(e.verbs[key]), nil
 	}
-	verbTrie := e.buildVerbTrie(subject)
 	return verbTrie.SearchAll(verb), nil
 }
 
@@ -1,7 +1,11 @@
 package ntql
 
 import (
+	"context"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestCompletion1(t *testing.T) {
@@ -96,3 +100,69 @@ func TestCompletion5(t *testing.T) {
 		}
 	}
 }
+
+func TestCompletionVerbsAfterDot(t *testing.T) {
+	engine := NewCompletionEngine(nil)
+	// Verbs must come from the subject being completed, not from an earlier
+	// call.
+	if _, err := engine.Suggest("title.con"); err != nil {
+		t.Fatalf("Suggest() failed: %v", err)
+	}
+	suggestions, err := engine.Suggest("due.")
+	if err != nil {
+		t.Fatalf("Suggest() failed: %v", err)
+	}
+	expected := []string{"before", "after", "equals"}
+	if !reflect.DeepEqual(suggestions, expected) {
+		t.Fatalf("expected %v, got %v", expected, suggestions)
+	}
+}
+
+func TestCompletionConcurrentUse(t *testing.T) {
+	engine := NewCompletionEngineWithOptions(DefaultSchema(), CompletionOptions{
+		Tags: []string{"school", "work"},
+		ValueProviders: map[string]ValueProvider{
+			"status": StaticValues("open", "closed"),
+		},
+		CacheTTL: time.Minute,
+	})
+	cases := []struct {
+		query    string
+		expected []string
+	}{
+		{"title.", []string{"startswith", "endswith", "contains", "equals", "eq"}},
+		{"due.", []string{"before", "after", "equals"}},
+		{"tag.eq(", []string{"school", "work"}},
+		{"status.equals(", []string{`"open"`, `"closed"`}},
+		{"tag.eq(school) ", []string{"AND", "OR"}},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				tc := cases[(i+j)%len(cases)]
+				suggestions, err := engine.SuggestContext(context.Background(), tc.query)
+				if err != nil {
+					t.Errorf("Suggest(%s) failed: %v", tc.query, err)
+					return
+				}
+				if !reflect.DeepEqual(suggestions, tc.expected) {
+					t.Errorf("Suggest(%s) returned %v, expected %v", tc.query, suggestions, tc.expected)
+					return
+				}
+				// Callers may modify the returned slice.
+				if len(suggestions) > 0 {
+					suggestions[0] = "modified"
+				}
+				if _, err := engine.SuggestAt(tc.query, len(tc.query)); err != nil {
+					t.Errorf("SuggestAt(%s) failed: %v", tc.query, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,210 @@
+package ntql
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// CompletionKind is the kind of query element a CompletionItem inserts.
+type CompletionKind int
+
+const (
+	CompletionSubject CompletionKind = iota
+	CompletionVerb
+	CompletionConnector
+	CompletionValue
+	// CompletionSnippet inserts a template, such as an empty string literal,
+	// rather than a complete value.
+	CompletionSnippet
+)
+
+func (k CompletionKind) String() string {
+	switch k {
+	case CompletionSubject:
+		return "subject"
+	case CompletionVerb:
+		return "verb"
+	case CompletionConnector:
+		return "connector"
+	case CompletionValue:
+		return "value"
+	case CompletionSnippet:
+		return "snippet"
+	}
+	return "unknown"
+}
+
+// CompletionItem is a suggestion for the text at a cursor. Accepting it
+// replaces the bytes from Start up to End of the query with InsertText.
+type CompletionItem struct {
+	// Label is the text shown in a completion list.
+	Label string
+	// InsertText is the text that replaces the range.
+	InsertText string
+	// Start and End are the byte offsets of the range to replace. The range
+	// covers the whole word around the cursor, so accepting a suggestion in
+	// the middle of a word replaces the rest of the word too.
+	Start int
+	End   int
+	Kind  CompletionKind
+	// Detail describes the item using the schema, such as the type and column
+	// of a subject or the signature of a verb.
+	Detail string
+	// Score is how well the item matches the word typed so far; higher is
+	// better. An exact match scores 100 and a typo 30 or less, with bonuses
+	// for canonical names and recently used items. Snippets score zero.
+	Score float64
+}
+
+// SuggestAt returns completions for the word at cursor, a byte offset into
+// query. Only the text before the cursor decides what is suggested, so the
+// query may continue after the cursor.
+func (e *CompletionEngine) SuggestAt(query string, cursor int) ([]CompletionItem, error) {
+	return e.SuggestAtContext(context.Background(), query, cursor)
+}
+
+// SuggestAtContext is like SuggestAt, but passes ctx to the value providers
+// and stops when it is cancelled.
+func (e *CompletionEngine) SuggestAtContext(ctx context.Context, query string, cursor int) ([]CompletionItem, error) {
+	return e.SuggestAtWithOptions(ctx, query, cursor, SuggestOptions{})
+}
+
+// SuggestOptions tunes the ranking of SuggestAtWithOptions.
+type SuggestOptions struct {
+	// Recent lists labels the user picked recently, most recent first. They
+	// are ranked above other matches of the same quality.
+	Recent []string
+	// Limit is the most items returned, or zero for no limit.
+	Limit int
+}
+
+// SuggestAtWithOptions is like SuggestAtContext, but ranks the suggestions
+// using opts. Items are sorted by Score, best first.
+func (e *CompletionEngine) SuggestAtWithOptions(ctx context.Context, query string, cursor int, opts SuggestOptions) ([]CompletionItem, error) {
+	if cursor < 0 || cursor > len(query) {
+		return nil, errors.New("cursor offset out of range")
+	}
+	c, err := e.locate(query[:cursor])
+	if err != nil {
+		return nil, err
+	}
+	if c.none {
+		return []CompletionItem{}, nil
+	}
+	candidates, err := e.candidates(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	ranked := rankCandidates(c.prefix, candidates, opts.Recent)
+	if _, ok := e.providers[toLowerCase(subjectName(c.subject))]; ok && c.kind == CompletionValue && e.maxValues > 0 && len(ranked) > e.maxValues {
+		ranked = ranked[:e.maxValues]
+	}
+
+	start, end := cursor, cursor
+	for start > 0 && isCompletionWordChar(query[start-1]) {
+		start--
+	}
+	for end < len(query) && isCompletionWordChar(query[end]) {
+		end++
+	}
+
+	items := make([]CompletionItem, 0, len(ranked)+1)
+	for _, r := range ranked {
+		items = append(items, CompletionItem{
+			Label:      r.label,
+			InsertText: r.label,
+			Start:      start,
+			End:        end,
+			Kind:       c.kind,
+			Detail:     e.completionDetail(c.kind, c.subject, r.label),
+			Score:      r.score,
+		})
+	}
+	if c.kind == CompletionValue && c.subject != nil && start == cursor && c.subject.acceptsType(DTypeString) {
+		items = append(items, CompletionItem{
+			Label:      `""`,
+			InsertText: `""`,
+			Start:      start,
+			End:        end,
+			Kind:       CompletionSnippet,
+			Detail:     "string",
+		})
+	}
+	if opts.Limit > 0 && len(items) > opts.Limit {
+		items = items[:opts.Limit]
+	}
+	return items, nil
+}
+
+func subjectName(subject *Subject) string {
+	if subject == nil {
+		return ""
+	}
+	return subject.Name
+}
+
+// isCompletionWordChar reports whether c can be part of the word that a
+// completion replaces.
+func isCompletionWordChar(c byte) bool {
+	return isDigit(c) || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || strings.IndexByte(`_-+:"`, c) >= 0
+}
+
+func (s *Subject) acceptsType(dtype DType) bool {
+	for _, t := range s.ValidTypes {
+		if t == dtype {
+			return true
+		}
+	}
+	return false
+}
+
+// completionDetail describes a suggested label: a subject's type and column,
+// a verb's signature, or a value's type.
+func (e *CompletionEngine) completionDetail(kind CompletionKind, subject *Subject, label string) string {
+	switch kind {
+	case CompletionSubject:
+		s, err := e.schema.Subject(label)
+		if err != nil {
+			return ""
+		}
+		detail := s.Name + ": " + joinDTypes(s.ValidTypes)
+		if s.Table != "" && s.Column != "" {
+			detail += " (" + s.Table + "." + s.Column + ")"
+		}
+		return detail
+	case CompletionVerb:
+		if subject == nil {
+			return ""
+		}
+		if verb, ok := subject.LookupVerb(label); ok {
+			return subject.Name + "." + verb.Name + "(" + joinDTypes(subject.ValidTypes) + ")"
+		}
+	case CompletionConnector:
+		switch label {
+		case TokenAnd.String():
+			return "both conditions must match"
+		case TokenOr.String():
+			return "either condition must match"
+		}
+	case CompletionValue:
+		if isRelativeDate(label) {
+			if strings.EqualFold(label, "now") {
+				return "relative date and time"
+			}
+			return "relative date"
+		}
+		if subject != nil && subject.acceptsType(DTypeTag) {
+			return "tag"
+		}
+	}
+	return ""
+}
+
+func joinDTypes(dtypes []DType) string {
+	names := make([]string, len(dtypes))
+	for i, dtype := range dtypes {
+		names[i] = dtype.String()
+	}
+	return strings.Join(names, " | ")
+}
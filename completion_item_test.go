@@ -0,0 +1,81 @@
+package ntql
+
+import (
+	"testing"
+)
+
+func TestSuggestAtMiddleOfQuery(t *testing.T) {
+	engine := NewCompletionEngine([]string{"school", "work"})
+	query := `title.contains("a") AND due.before(today)`
+	// The cursor is after "title.con"; the rest of the verb is replaced.
+	items, err := engine.SuggestAt(query, len("title.con"))
+	if err != nil {
+		t.Fatalf("SuggestAt() failed: %v", err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("expected 1 item, got %+v", items)
+	}
+	item := items[0]
+	if item.Label != "contains" || item.Kind != CompletionVerb || item.Start != 6 || item.End != 14 {
+		t.Fatalf("unexpected item: %+v", item)
+	}
+	if item.Detail != "title.contains(string)" {
+		t.Fatalf("unexpected detail: %s", item.Detail)
+	}
+}
+
+func TestSuggestAtKinds(t *testing.T) {
+	engine := NewCompletionEngine([]string{"school", "work"})
+	cases := []struct {
+		query  string
+		kind   CompletionKind
+		label  string
+		detail string
+	}{
+		{`dead`, CompletionSubject, "deadline", "due: date | dateTime (tasks.due_date)"},
+		{`due.`, CompletionVerb, "before", "due.before(date | dateTime)"},
+		{`tag.eq(sc`, CompletionValue, "school", "tag"},
+		{`due.before(to`, CompletionValue, "today", "relative date"},
+		{`tag.eq(school) `, CompletionConnector, "AND", "both conditions must match"},
+		{`title.contains(`, CompletionSnippet, `""`, "string"},
+	}
+	for _, tc := range cases {
+		items, err := engine.SuggestAt(tc.query, len(tc.query))
+		if err != nil {
+			t.Fatalf("SuggestAt(%s) failed: %v", tc.query, err)
+		}
+		found := false
+		for _, item := range items {
+			if item.Label == tc.label {
+				found = true
+				if item.Kind != tc.kind || item.Detail != tc.detail {
+					t.Errorf("unexpected item for %s: %+v", tc.query, item)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("expected %s in suggestions for %s, got %+v", tc.label, tc.query, items)
+		}
+	}
+}
+
+func TestSuggestAtReplacementRange(t *testing.T) {
+	engine := NewCompletionEngine(nil)
+	query := `title.eq("a") AND sta`
+	items, err := engine.SuggestAt(query, len(query)-1)
+	if err != nil {
+		t.Fatalf("SuggestAt() failed: %v", err)
+	}
+	if len(items) == 0 {
+		t.Fatalf("expected suggestions")
+	}
+	for _, item := range items {
+		if query[item.Start:item.End] != "sta" {
+			t.Fatalf("expected %s to replace \"sta\", got %q", item.Label, query[item.Start:item.End])
+		}
+	}
+
+	if _, err := engine.SuggestAt(query, len(query)+1); err == nil {
+		t.Fatalf("expected an error for a cursor past the end")
+	}
+}
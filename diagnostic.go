@@ -0,0 +1,181 @@
+package ntql
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// DiagnosticCode identifies the kind of problem a Diagnostic reports. Codes
+// keep their values across releases, so they can be matched on or used to
+// look up help text.
+type DiagnosticCode int
+
+const (
+	// CodeUnknown is used for errors that carry no more specific code.
+	CodeUnknown DiagnosticCode = 0
+	// CodeInvalidCharacter reports a character that cannot start a lexeme.
+	CodeInvalidCharacter DiagnosticCode = 1
+	// CodeUnexpectedToken reports a token that is not valid at its position.
+	CodeUnexpectedToken DiagnosticCode = 2
+	// CodeUnknownSubject reports a subject that is not in the schema.
+	CodeUnknownSubject DiagnosticCode = 3
+	// CodeUnknownVerb reports a verb that the subject does not support.
+	CodeUnknownVerb DiagnosticCode = 4
+	// CodeUnexpectedEnd reports a query that ends before it is complete.
+	CodeUnexpectedEnd DiagnosticCode = 5
+	// CodeUnterminatedString reports a string without a closing quote.
+	CodeUnterminatedString DiagnosticCode = 6
+	// CodeLimitExceeded reports a query that is longer, deeper or has more
+	// conditions than the limits passed to Compile allow.
+	CodeLimitExceeded DiagnosticCode = 7
+	// CodeTypeMismatch reports a value of a type that its subject does not
+	// accept.
+	CodeTypeMismatch DiagnosticCode = 8
+)
+
+// String returns the code in the form NTQL001.
+func (c DiagnosticCode) String() string {
+	return fmt.Sprintf("NTQL%03d", int(c))
+}
+
+// Diagnostic describes a problem in a query and the span of the query it
+// applies to. Start and End are byte offsets into the query; End is exclusive
+// and equal to Start for problems at a single position, such as the end of
+// the input.
+type Diagnostic struct {
+	Code     DiagnosticCode
+	Message  string
+	Start    int
+	End      int
+	Expected []TokenType
+	// Suggestions are replacements for the span that may have been meant,
+	// best first, such as title for titel. The message lists them too.
+	Suggestions []string
+}
+
+func (d Diagnostic) Error() string {
+	return fmt.Sprintf("%s at position %d: %s", d.Code, d.Start, d.Message)
+}
+
+// Render returns the line of query containing the diagnostic, underlined with
+// carets below the offending span, followed by the code and message:
+//
+//	title.contans(report)
+//	      ^^^^^^^
+//	NTQL004: Invalid verb: contans (did you mean contains?)
+func (d Diagnostic) Render(query string) string {
+	start := clampOffset(d.Start, query)
+	end := clampOffset(d.End, query)
+	if end < start {
+		end = start
+	}
+
+	lineStart := strings.LastIndexByte(query[:start], '\n') + 1
+	lineEnd := len(query)
+	if i := strings.IndexByte(query[start:], '\n'); i >= 0 {
+		lineEnd = start + i
+	}
+	if end > lineEnd {
+		end = lineEnd
+	}
+
+	line := query[lineStart:lineEnd]
+	var b strings.Builder
+	b.WriteString(line)
+	b.WriteByte('\n')
+	// Pad with the same whitespace as the line so tabs line up.
+	for _, r := range query[lineStart:start] {
+		if r == '\t' {
+			b.WriteByte('\t')
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+	b.WriteString(strings.Repeat("^", max(1, utf8.RuneCountInString(query[start:end]))))
+	b.WriteByte('\n')
+	b.WriteString(d.Code.String() + ": " + d.Message)
+	return b.String()
+}
+
+func clampOffset(offset int, query string) int {
+	if offset < 0 {
+		return 0
+	}
+	if offset > len(query) {
+		return len(query)
+	}
+	return offset
+}
+
+// diagnoser is implemented by errors that can describe themselves as a
+// Diagnostic.
+type diagnoser interface {
+	Diagnostic() Diagnostic
+}
+
+// DiagnosticOf returns the Diagnostic for an error returned while lexing or
+// parsing a query. It reports false for errors that carry no position.
+func DiagnosticOf(err error) (Diagnostic, bool) {
+	var d Diagnostic
+	if errors.As(err, &d) {
+		return d, true
+	}
+	var de diagnoser
+	if errors.As(err, &de) {
+		return de.Diagnostic(), true
+	}
+	return Diagnostic{}, false
+}
+
+func (e ErrInvalidLexeme) Diagnostic() Diagnostic {
+	return Diagnostic{
+		Code:    CodeInvalidCharacter,
+		Message: fmt.Sprintf("Invalid character %q", e.char()),
+		Start:   e.Position,
+		End:     e.End,
+	}
+}
+
+func (e ErrInvalidSubject) Diagnostic() Diagnostic {
+	return Diagnostic{
+		Code:        CodeUnknownSubject,
+		Message:     e.Error(),
+		Start:       e.Position,
+		End:         e.End,
+		Expected:    []TokenType{TokenSubject},
+		Suggestions: e.Suggestions,
+	}
+}
+
+func (e ErrInvalidToken) Diagnostic() Diagnostic {
+	d := Diagnostic{
+		Code:        CodeUnexpectedToken,
+		Message:     fmt.Sprintf("Unexpected %q, expected %s%s", e.Lexeme, joinTokenTypes(e.Expected), didYouMean(e.Suggestions)),
+		Start:       e.Position,
+		End:         e.End,
+		Expected:    e.Expected,
+		Suggestions: e.Suggestions,
+	}
+	if e.Mismatch != nil {
+		d.Code = CodeTypeMismatch
+		d.Message = e.Error()
+	}
+	if strings.HasPrefix(string(e.Lexeme), `"`) && !stringRegexp.MatchString(string(e.Lexeme)) {
+		d.Code = CodeUnterminatedString
+		d.Message = "Unterminated string"
+	}
+	return d
+}
+
+func (e *ParserError) Diagnostic() Diagnostic {
+	return Diagnostic{
+		Code:        e.Code,
+		Message:     e.Message,
+		Start:       e.Token.Position,
+		End:         e.Token.End,
+		Expected:    e.Expected,
+		Suggestions: e.Suggestions,
+	}
+}
@@ -0,0 +1,145 @@
+package ntql
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// parseQuery lexes and parses query against the default schema.
+func parseQuery(query string) (QueryExpr, error) {
+	tokens, err := NewLexer(query).Lex()
+	if err != nil {
+		return nil, err
+	}
+	return NewParser(tokens).Parse()
+}
+
+func TestTokenSpans(t *testing.T) {
+	query := `title.contains("a b")  AND  priority.gte(10)`
+	tokens, err := NewLexer(query).Lex()
+	if err != nil {
+		t.Fatalf("Lex() failed: %v", err)
+	}
+	expected := []string{"title", ".", "contains", "(", `"a b"`, ")", "AND", "priority", ".", "gte", "(", "10", ")"}
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected %d tokens, got %d", len(expected), len(tokens))
+	}
+	for i, tok := range tokens {
+		if got := query[tok.Position:tok.End]; got != expected[i] {
+			t.Errorf("expected token %d to span %q, got %q", i, expected[i], got)
+		}
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	cases := []struct {
+		query string
+		code  DiagnosticCode
+		span  string
+	}{
+		{`title.contains("a") & due.before(today)`, CodeInvalidCharacter, "&"},
+		{`titel.contains(a)`, CodeUnknownSubject, "titel"},
+		{`title.contans("a")`, CodeUnknownVerb, "contans"},
+		{`title.contains("a"`, CodeUnexpectedEnd, ""},
+		{`title.contains("abc`, CodeUnterminatedString, `"abc`},
+		{`priority.gte(1.5)`, CodeTypeMismatch, "1.5"},
+		{`priority.gte(1 2)`, CodeUnexpectedToken, "2"},
+		{`title.contains("a") due.before(today)`, CodeUnexpectedToken, "due"},
+	}
+	for _, tc := range cases {
+		_, err := parseQuery(tc.query)
+		if err == nil {
+			t.Fatalf("expected an error for %s", tc.query)
+		}
+		d, ok := DiagnosticOf(err)
+		if !ok {
+			t.Fatalf("expected a diagnostic for %s, got %v", tc.query, err)
+		}
+		if d.Code != tc.code {
+			t.Errorf("expected code %s for %s, got %s (%s)", tc.code, tc.query, d.Code, d.Message)
+		}
+		if got := tc.query[d.Start:d.End]; got != tc.span {
+			t.Errorf("expected span %q for %s, got %q", tc.span, tc.query, got)
+		}
+	}
+}
+
+func TestDiagnosticRender(t *testing.T) {
+	query := `title.contans("report")`
+	_, err := parseQuery(query)
+	d, ok := DiagnosticOf(err)
+	if !ok {
+		t.Fatalf("expected a diagnostic, got %v", err)
+	}
+	expected := "title.contans(\"report\")\n      ^^^^^^^\nNTQL004: Invalid verb: contans (did you mean contains?)"
+	if got := d.Render(query); got != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, got)
+	}
+
+	// At the end of the input, a single caret is drawn past the last character.
+	d = Diagnostic{Code: CodeUnexpectedEnd, Message: "Expected value", Start: 6, End: 6}
+	expected = "é.eq(\n     ^\nNTQL005: Expected value"
+	if got := d.Render("é.eq("); got != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, got)
+	}
+}
+
+func TestParserDoesNotPanicAtBoundaries(t *testing.T) {
+	for _, tokens := range [][]Token{
+		nil,
+		{{Kind: TokenDot, Literal: "."}},
+		{{Kind: TokenSubject, Literal: "title"}, {Kind: TokenDot, Literal: "."}, {Kind: TokenVerb, Literal: "eq"}, {Kind: TokenLParen, Literal: "("}},
+	} {
+		if _, err := NewParser(tokens).Parse(); err == nil {
+			t.Fatalf("expected an error for %v", tokens)
+		}
+	}
+}
+
+func TestDiagnosticSuggestions(t *testing.T) {
+	cases := []struct {
+		query       string
+		code        DiagnosticCode
+		suggestions []string
+		message     string
+	}{
+		{`titel.eq("a")`, CodeUnknownSubject, []string{"title"}, "Invalid subject: titel (did you mean title?)"},
+		{`deadlin.before(today)`, CodeUnknownSubject, []string{"deadline"}, "Invalid subject: deadlin (did you mean deadline?)"},
+		{`title.equls("a")`, CodeUnknownVerb, []string{"equals"}, "Invalid verb: equls (did you mean equals?)"},
+		{`due.before(tomorow)`, CodeUnexpectedToken, []string{"tomorrow"}, `Unexpected "tomorow", expected (, !, Date, RelativeDate, DateTime (did you mean tomorrow?)`},
+		{`createdBy.eq(2024-01-01)`, CodeTypeMismatch, []string{"createdAt"}, "Type mismatch: createdBy takes string, not date 2024-01-01 (did you mean createdAt?)"},
+		{`priority.eq("high")`, CodeTypeMismatch, nil, `Type mismatch: priority takes int, not string "high"`},
+		{`xyz.eq(1)`, CodeUnknownSubject, nil, "Invalid subject: xyz"},
+	}
+	for _, tc := range cases {
+		_, err := parseQuery(tc.query)
+		d, ok := DiagnosticOf(err)
+		if !ok {
+			t.Fatalf("expected a diagnostic for %s, got %v", tc.query, err)
+		}
+		if d.Code != tc.code || d.Message != tc.message {
+			t.Errorf("unexpected diagnostic for %s: %s %s", tc.query, d.Code, d.Message)
+		}
+		if !slices.Equal(d.Suggestions, tc.suggestions) {
+			t.Errorf("expected suggestions %q for %s, got %q", tc.suggestions, tc.query, d.Suggestions)
+		}
+	}
+
+	// The suggestions are also on the error values.
+	_, err := NewLexer(`titel.eq("a")`).Lex()
+	var subjectErr ErrInvalidSubject
+	if !errors.As(err, &subjectErr) || !slices.Equal(subjectErr.Suggestions, []string{"title"}) {
+		t.Fatalf("expected ErrInvalidSubject suggesting title, got %#v", err)
+	}
+	_, err = parseQuery(`title.contians("a")`)
+	var parserErr *ParserError
+	if !errors.As(err, &parserErr) || !slices.Equal(parserErr.Suggestions, []string{"contains"}) {
+		t.Fatalf("expected a ParserError suggesting contains, got %#v", err)
+	}
+	_, err = parseQuery(`priority.eq(1.5)`)
+	var tokenErr ErrInvalidToken
+	if !errors.As(err, &tokenErr) || tokenErr.Mismatch == nil || tokenErr.Mismatch.Got != DTypeFloat {
+		t.Fatalf("expected a float type mismatch, got %#v", err)
+	}
+}
@@ -0,0 +1,166 @@
+package ntql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Dialect controls the parts of the generated SQL that differ between database
+// engines.
+type Dialect interface {
+	// Name returns the name the dialect is registered under in DialectByName.
+	Name() string
+	// Placeholder returns the placeholder for the n-th bind argument,
+	// counting from 1.
+	Placeholder(n int) string
+	// QuoteIdentifier quotes a table or column name.
+	QuoteIdentifier(name string) string
+	// Like returns a LIKE comparison of expr against pattern. Dialects decide
+	// whether the comparison ignores case.
+	Like(expr, pattern string) string
+	// LikeEscape returns the ESCAPE clause that makes backslash the escape
+	// character in LIKE patterns.
+	LikeEscape() string
+	// BoolLiteral returns the literal for a boolean value.
+	BoolLiteral(b bool) string
+	// DateLiteral wraps a rendered date or date-time value, either a quoted
+	// literal or a placeholder, in whatever cast the engine needs to compare it
+	// with a column of type dtype.
+	DateLiteral(value string, dtype DType) string
+	// Xor returns the exclusive or of two boolean expressions.
+	Xor(left, right string) string
+	// Now returns an expression for the current timestamp.
+	Now() string
+}
+
+// DialectByName returns the built-in dialect with the given name. Names are
+// matched ignoring case.
+func DialectByName(name string) (Dialect, error) {
+	switch strings.ToLower(name) {
+	case "", "generic":
+		return GenericDialect{}, nil
+	case "postgres", "postgresql":
+		return PostgresDialect{}, nil
+	case "mysql":
+		return MySQLDialect{}, nil
+	case "sqlite", "sqlite3":
+		return SQLiteDialect{}, nil
+	case "sqlserver", "mssql":
+		return SQLServerDialect{}, nil
+	}
+	return nil, fmt.Errorf("unknown SQL dialect: %s", name)
+}
+
+// GenericDialect is used when no dialect is given. It writes identifiers as-is,
+// uses ? placeholders, plain LIKE, XOR and NOW(), which matches what NTQL has
+// always generated.
+type GenericDialect struct{}
+
+func (GenericDialect) Name() string                             { return "generic" }
+func (GenericDialect) Placeholder(n int) string                 { return "?" }
+func (GenericDialect) QuoteIdentifier(name string) string       { return name }
+func (GenericDialect) Like(expr, pattern string) string         { return expr + " LIKE " + pattern }
+func (GenericDialect) LikeEscape() string                       { return `ESCAPE '\'` }
+func (GenericDialect) BoolLiteral(b bool) string                { return strconv.FormatBool(b) }
+func (GenericDialect) DateLiteral(value string, _ DType) string { return value }
+func (GenericDialect) Xor(left, right string) string            { return "(" + left + " XOR " + right + ")" }
+func (GenericDialect) Now() string                              { return "NOW()" }
+
+// PostgresDialect generates SQL for PostgreSQL.
+type PostgresDialect struct{}
+
+func (PostgresDialect) Name() string                       { return "postgres" }
+func (PostgresDialect) Placeholder(n int) string           { return "$" + strconv.Itoa(n) }
+func (PostgresDialect) QuoteIdentifier(name string) string { return quoteIdentifier(name, `"`, `"`) }
+func (PostgresDialect) Like(expr, pattern string) string   { return expr + " ILIKE " + pattern }
+func (PostgresDialect) LikeEscape() string                 { return `ESCAPE '\'` }
+func (PostgresDialect) BoolLiteral(b bool) string          { return strings.ToUpper(strconv.FormatBool(b)) }
+func (PostgresDialect) DateLiteral(value string, dtype DType) string {
+	if dtype == DTypeDateTime {
+		return "CAST(" + value + " AS TIMESTAMP)"
+	}
+	return "CAST(" + value + " AS DATE)"
+}
+func (PostgresDialect) Xor(left, right string) string { return "((" + left + ") <> (" + right + "))" }
+func (PostgresDialect) Now() string                   { return "NOW()" }
+
+// MySQLDialect generates SQL for MySQL and MariaDB.
+type MySQLDialect struct{}
+
+func (MySQLDialect) Name() string                       { return "mysql" }
+func (MySQLDialect) Placeholder(n int) string           { return "?" }
+func (MySQLDialect) QuoteIdentifier(name string) string { return quoteIdentifier(name, "`", "`") }
+func (MySQLDialect) Like(expr, pattern string) string {
+	return "LOWER(" + expr + ") LIKE LOWER(" + pattern + ")"
+}
+
+// LikeEscape doubles the backslash because MySQL treats backslash as an escape
+// character inside string literals.
+func (MySQLDialect) LikeEscape() string        { return `ESCAPE '\\'` }
+func (MySQLDialect) BoolLiteral(b bool) string { return strings.ToUpper(strconv.FormatBool(b)) }
+func (MySQLDialect) DateLiteral(value string, dtype DType) string {
+	if dtype == DTypeDateTime {
+		return "CAST(" + value + " AS DATETIME)"
+	}
+	return "CAST(" + value + " AS DATE)"
+}
+func (MySQLDialect) Xor(left, right string) string { return "(" + left + " XOR " + right + ")" }
+func (MySQLDialect) Now() string                   { return "NOW()" }
+
+// SQLiteDialect generates SQL for SQLite. Dates are compared as ISO 8601 text,
+// which is how SQLite stores them.
+type SQLiteDialect struct{}
+
+func (SQLiteDialect) Name() string                       { return "sqlite" }
+func (SQLiteDialect) Placeholder(n int) string           { return "?" }
+func (SQLiteDialect) QuoteIdentifier(name string) string { return quoteIdentifier(name, `"`, `"`) }
+func (SQLiteDialect) Like(expr, pattern string) string {
+	return "LOWER(" + expr + ") LIKE LOWER(" + pattern + ")"
+}
+func (SQLiteDialect) LikeEscape() string { return `ESCAPE '\'` }
+func (SQLiteDialect) BoolLiteral(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+func (SQLiteDialect) DateLiteral(value string, _ DType) string { return value }
+func (SQLiteDialect) Xor(left, right string) string            { return "((" + left + ") <> (" + right + "))" }
+func (SQLiteDialect) Now() string                              { return "CURRENT_TIMESTAMP" }
+
+// SQLServerDialect generates SQL for Microsoft SQL Server.
+type SQLServerDialect struct{}
+
+func (SQLServerDialect) Name() string                       { return "sqlserver" }
+func (SQLServerDialect) Placeholder(n int) string           { return "@p" + strconv.Itoa(n) }
+func (SQLServerDialect) QuoteIdentifier(name string) string { return quoteIdentifier(name, "[", "]") }
+func (SQLServerDialect) Like(expr, pattern string) string {
+	return "LOWER(" + expr + ") LIKE LOWER(" + pattern + ")"
+}
+func (SQLServerDialect) LikeEscape() string { return `ESCAPE '\'` }
+func (SQLServerDialect) BoolLiteral(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+func (SQLServerDialect) DateLiteral(value string, dtype DType) string {
+	if dtype == DTypeDateTime {
+		return "CAST(" + value + " AS DATETIME2)"
+	}
+	return "CAST(" + value + " AS DATE)"
+}
+
+// Xor compares the truth values through CASE because SQL Server predicates are
+// not values and cannot be compared directly.
+func (SQLServerDialect) Xor(left, right string) string {
+	return "(CASE WHEN " + left + " THEN 1 ELSE 0 END <> CASE WHEN " + right + " THEN 1 ELSE 0 END)"
+}
+func (SQLServerDialect) Now() string { return "CURRENT_TIMESTAMP" }
+
+// quoteIdentifier wraps name in the given quotes, doubling any closing quote
+// inside it.
+func quoteIdentifier(name, open, close string) string {
+	return open + strings.ReplaceAll(name, close, close+close) + close
+}
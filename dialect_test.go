@@ -0,0 +1,105 @@
+package ntql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDialectParameterizedCondition(t *testing.T) {
+	q := NewQueryAnd(
+		&QueryCondition{Field: "title", Value: "Road", Operator: OperatorCnt},
+		&QueryCondition{Field: "due_date", Value: "2026-01-31", Operator: OperatorLT},
+	)
+
+	tests := []struct {
+		dialect  Dialect
+		expected string
+	}{
+		{PostgresDialect{}, `("title" ILIKE $1 ESCAPE '\' AND "due_date" < CAST($2 AS DATE))`},
+		{MySQLDialect{}, "(LOWER(`title`) LIKE LOWER(?) ESCAPE '\\\\' AND `due_date` < CAST(? AS DATE))"},
+		{SQLiteDialect{}, `(LOWER("title") LIKE LOWER(?) ESCAPE '\' AND "due_date" < ?)`},
+		{SQLServerDialect{}, `(LOWER([title]) LIKE LOWER(@p1) ESCAPE '\' AND [due_date] < CAST(@p2 AS DATE))`},
+	}
+
+	for _, test := range tests {
+		sql, args, err := ToSQLWithOptions(q, SQLOptions{Dialect: test.dialect, Parameterized: true})
+		if err != nil {
+			t.Fatalf("%s: ToSQLWithOptions() failed: %v", test.dialect.Name(), err)
+		}
+		if sql != test.expected {
+			t.Errorf("%s: ToSQLWithOptions() returned %q, expected %q", test.dialect.Name(), sql, test.expected)
+		}
+		if !reflect.DeepEqual(args, []any{"%Road%", "2026-01-31"}) {
+			t.Errorf("%s: unexpected args %#v", test.dialect.Name(), args)
+		}
+	}
+}
+
+func TestDialectXor(t *testing.T) {
+	q := &QueryBinaryOp{
+		Left:     &QueryCondition{Field: "priority", Value: "1", Operator: OperatorEq},
+		Right:    &QueryCondition{Field: "priority", Value: "2", Operator: OperatorEq},
+		Operator: OperatorXor,
+	}
+
+	tests := []struct {
+		dialect  Dialect
+		expected string
+	}{
+		{GenericDialect{}, "(priority = 1 XOR priority = 2)"},
+		{MySQLDialect{}, "(`priority` = 1 XOR `priority` = 2)"},
+		{PostgresDialect{}, `(("priority" = 1) <> ("priority" = 2))`},
+		{SQLiteDialect{}, `(("priority" = 1) <> ("priority" = 2))`},
+		{SQLServerDialect{}, "(CASE WHEN [priority] = 1 THEN 1 ELSE 0 END <> CASE WHEN [priority] = 2 THEN 1 ELSE 0 END)"},
+	}
+
+	for _, test := range tests {
+		sql, _, err := ToSQLWithOptions(q, SQLOptions{Dialect: test.dialect})
+		if err != nil {
+			t.Fatalf("%s: ToSQLWithOptions() failed: %v", test.dialect.Name(), err)
+		}
+		if sql != test.expected {
+			t.Errorf("%s: ToSQLWithOptions() returned %q, expected %q", test.dialect.Name(), sql, test.expected)
+		}
+	}
+}
+
+func TestDialectNowAndBool(t *testing.T) {
+	completed := &QueryCondition{Field: "completed", Value: "true", Operator: OperatorEq}
+	sql, _, err := ToSQLWithOptions(completed, SQLOptions{Dialect: SQLiteDialect{}})
+	if err != nil {
+		t.Fatalf("ToSQLWithOptions() failed: %v", err)
+	}
+	if sql != `"completed_at" < CURRENT_TIMESTAMP` {
+		t.Fatalf("unexpected SQLite SQL %q", sql)
+	}
+
+	allDay := &QueryCondition{Field: "all_day", Value: "false", Operator: OperatorEq}
+	sql, _, err = ToSQLWithOptions(allDay, SQLOptions{Dialect: SQLServerDialect{}})
+	if err != nil {
+		t.Fatalf("ToSQLWithOptions() failed: %v", err)
+	}
+	if sql != "[all_day] = 0" {
+		t.Fatalf("unexpected SQL Server SQL %q", sql)
+	}
+}
+
+func TestDialectByName(t *testing.T) {
+	for _, name := range []string{"postgres", "MySQL", "sqlite", "sqlserver", "generic"} {
+		if _, err := DialectByName(name); err != nil {
+			t.Errorf("expected dialect %s to exist, got error: %v", name, err)
+		}
+	}
+	if _, err := DialectByName("oracle"); err == nil {
+		t.Fatalf("expected unknown dialect to be rejected")
+	}
+}
+
+func TestQuoteIdentifierEscapesQuotes(t *testing.T) {
+	if got := (PostgresDialect{}).QuoteIdentifier(`we"ird`); got != `"we""ird"` {
+		t.Fatalf("unexpected quoted identifier %s", got)
+	}
+	if got := (SQLServerDialect{}).QuoteIdentifier("we]ird"); got != "[we]]ird]" {
+		t.Fatalf("unexpected quoted identifier %s", got)
+	}
+}
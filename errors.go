@@ -8,11 +8,19 @@ type ScannerError struct {
 
 type ErrInvalidLexeme struct {
 	Input byte
+	// Char is the character starting at Input, which may span several bytes.
+	Char     rune
+	Position int
+	End      int
 }
 
 type ErrInvalidSubject struct {
 	Position int
+	End      int
 	Lexeme   Lexeme
+	// Suggestions are the subjects and aliases of the schema that Lexeme may
+	// be a misspelling of, best first.
+	Suggestions []string
 }
 
 type ErrEndOfInput struct{}
@@ -20,12 +28,34 @@ type ErrEndOfInput struct{}
 type ErrInvalidToken struct {
 	Expected []TokenType
 	Position int
+	End      int
 	Lexeme   Lexeme
+	// Mismatch is set when Lexeme is a value of a type that the subject of
+	// the function call does not accept.
+	Mismatch *TypeMismatch
+	// Suggestions are what Lexeme may have been meant to be, best first:
+	// relative dates for a misspelt one, or subjects that accept the value
+	// for a type mismatch.
+	Suggestions []string
+}
+
+// TypeMismatch describes a value of a type its subject does not accept.
+type TypeMismatch struct {
+	Subject  string
+	Accepted []DType
+	Got      DType
 }
 
 type ParserError struct {
 	Message string
 	Token   Token
+	// Code classifies the error, and Expected lists the tokens that would
+	// have been accepted, if known.
+	Code     DiagnosticCode
+	Expected []TokenType
+	// Suggestions are the names the token may be a misspelling of, best
+	// first. They are also listed at the end of Message.
+	Suggestions []string
 }
 
 func (e *ScannerError) Error() string {
@@ -33,11 +63,18 @@ func (e *ScannerError) Error() string {
 }
 
 func (e ErrInvalidLexeme) Error() string {
-	return fmt.Sprintf("Invalid character, %v", e.Input)
+	return fmt.Sprintf("Invalid character %q at position %d", e.char(), e.Position)
+}
+
+func (e ErrInvalidLexeme) char() rune {
+	if e.Char != 0 {
+		return e.Char
+	}
+	return rune(e.Input)
 }
 
 func (e ErrInvalidSubject) Error() string {
-	return fmt.Sprintf("Invalid subject: %s", e.Lexeme)
+	return fmt.Sprintf("Invalid subject: %s%s", e.Lexeme, didYouMean(e.Suggestions))
 }
 
 func (e ErrEndOfInput) Error() string {
@@ -45,17 +82,32 @@ func (e ErrEndOfInput) Error() string {
 }
 
 func (e ErrInvalidToken) Error() string {
-	// convert expected tokens to string
+	if e.Mismatch != nil {
+		return e.Mismatch.message(e.Lexeme) + didYouMean(e.Suggestions)
+	}
+	return fmt.Sprintf("Invalid lexeme '%s': expected type from [%s]%s", e.Lexeme, joinTokenTypes(e.Expected), didYouMean(e.Suggestions))
+}
+
+func (m *TypeMismatch) message(lexeme Lexeme) string {
+	return fmt.Sprintf("Type mismatch: %s takes %s, not %s %s", m.Subject, joinDTypes(m.Accepted), m.Got, lexeme)
+}
+
+// joinTokenTypes lists token types separated by commas, without duplicates.
+func joinTokenTypes(types []TokenType) string {
 	var expected string
-	for i, t := range e.Expected {
-		if i == 0 {
+	seen := map[TokenType]bool{}
+	for _, t := range types {
+		if seen[t] {
+			continue
+		}
+		seen[t] = true
+		if expected == "" {
 			expected = t.String()
 		} else {
 			expected = fmt.Sprintf("%s, %s", expected, t.String())
 		}
 	}
-
-	return fmt.Sprintf("Invalid lexeme '%s': expected type from [%s]", e.Lexeme, expected)
+	return expected
 }
 
 func (e *ParserError) Error() string {
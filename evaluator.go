@@ -0,0 +1,579 @@
+package ntql
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Record gives the evaluator access to the values of a single record. Lookup
+// is called with a subject name, its aliases and its table-qualified column
+// name, such as tasks.due_date, in that order, until it reports a value.
+type Record interface {
+	Lookup(key string) (any, bool)
+}
+
+// MapRecord is a Record backed by a map. Keys are matched exactly first, then
+// ignoring case and underscores.
+type MapRecord map[string]any
+
+func (m MapRecord) Lookup(key string) (any, bool) {
+	if value, ok := m[key]; ok {
+		return value, true
+	}
+	for k, value := range m {
+		if toLowerCase(k) == toLowerCase(key) {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// structRecord is a Record backed by a struct value.
+type structRecord struct {
+	value reflect.Value
+}
+
+// StructRecord returns a Record that reads the exported fields of a struct or
+// pointer to struct. A field is matched by its `ntql:"name"` tag, or otherwise
+// by its name ignoring case and underscores, so DueDate matches due_date.
+func StructRecord(v any) Record {
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return structRecord{}
+		}
+		value = value.Elem()
+	}
+	return structRecord{value: value}
+}
+
+func (r structRecord) Lookup(key string) (any, bool) {
+	if !r.value.IsValid() || r.value.Kind() != reflect.Struct {
+		return nil, false
+	}
+	for _, field := range reflect.VisibleFields(r.value.Type()) {
+		if !field.IsExported() || field.Anonymous {
+			continue
+		}
+		name := field.Name
+		if tag, ok := field.Tag.Lookup("ntql"); ok {
+			name, _, _ = strings.Cut(tag, ",")
+			if name == "-" {
+				continue
+			}
+		}
+		if toLowerCase(name) == toLowerCase(key) {
+			return r.value.FieldByIndex(field.Index).Interface(), true
+		}
+	}
+	return nil, false
+}
+
+// Evaluator applies a query expression to records held in memory. It follows
+// the semantics of the generated SQL: a missing or nil value is unknown, NOT of
+// unknown is unknown, and a record only matches if the whole query is true.
+// Values holding a slice match a condition if any element does, like a to-many
+// join; a nil slice holds no elements. String matching with contains, startsWith and endsWith ignores case.
+type Evaluator struct {
+	// Clock resolves relative dates such as today or startOfWeek.
+	Clock Clock
+	// BaseTable is the table records are rows of, as in JoinQueryOptions. It
+	// decides which subjects Implies treats as behind a to-many join. The
+	// schema's root table is used when it is empty.
+	BaseTable string
+	schema    *Schema
+}
+
+// NewEvaluator creates an evaluator that resolves subjects against schema, or
+// the default schema if it is nil.
+func NewEvaluator(schema *Schema) *Evaluator {
+	if schema == nil {
+		schema = DefaultSchema()
+	}
+	return &Evaluator{schema: schema}
+}
+
+// schemaOrDefault returns the evaluator's schema, or the default schema for
+// the zero Evaluator.
+func (e *Evaluator) schemaOrDefault() *Schema {
+	if e.schema == nil {
+		return DefaultSchema()
+	}
+	return e.schema
+}
+
+// Evaluate reports whether record matches expr, using the default schema.
+// See Evaluator.Evaluate.
+func Evaluate(expr QueryExpr, record any) (bool, error) {
+	return NewEvaluator(DefaultSchema()).Evaluate(expr, record)
+}
+
+// Evaluate reports whether record matches expr. The record can be a Record, a
+// map[string]any, or a struct or pointer to struct.
+func (e *Evaluator) Evaluate(expr QueryExpr, record any) (bool, error) {
+	if expr == nil {
+		return false, errors.New("query expression cannot be nil")
+	}
+	rec, err := asRecord(record)
+	if err != nil {
+		return false, err
+	}
+	result, err := e.eval(expr, rec)
+	if err != nil {
+		return false, err
+	}
+	return result == truthTrue, nil
+}
+
+func asRecord(record any) (Record, error) {
+	switch r := record.(type) {
+	case Record:
+		return r, nil
+	case map[string]any:
+		return MapRecord(r), nil
+	}
+	value := reflect.ValueOf(record)
+	for value.Kind() == reflect.Pointer && !value.IsNil() {
+		value = value.Elem()
+	}
+	if value.Kind() == reflect.Struct {
+		return StructRecord(record), nil
+	}
+	return nil, fmt.Errorf("unsupported record type %T", record)
+}
+
+// truth is a value in SQL's three-valued logic.
+type truth int
+
+const (
+	truthFalse truth = iota
+	truthTrue
+	truthUnknown
+)
+
+func truthOf(b bool) truth {
+	if b {
+		return truthTrue
+	}
+	return truthFalse
+}
+
+func (e *Evaluator) eval(expr QueryExpr, rec Record) (truth, error) {
+	switch node := expr.(type) {
+	case *QueryCondition:
+		return e.evalCondition(node, rec)
+	case *QueryBinaryOp:
+		left, err := e.eval(node.Left, rec)
+		if err != nil {
+			return truthUnknown, err
+		}
+		right, err := e.eval(node.Right, rec)
+		if err != nil {
+			return truthUnknown, err
+		}
+		switch node.Operator {
+		case OperatorAnd:
+			if left == truthFalse || right == truthFalse {
+				return truthFalse, nil
+			}
+			if left == truthUnknown || right == truthUnknown {
+				return truthUnknown, nil
+			}
+			return truthTrue, nil
+		case OperatorOr:
+			if left == truthTrue || right == truthTrue {
+				return truthTrue, nil
+			}
+			if left == truthUnknown || right == truthUnknown {
+				return truthUnknown, nil
+			}
+			return truthFalse, nil
+		case OperatorXor:
+			if left == truthUnknown || right == truthUnknown {
+				return truthUnknown, nil
+			}
+			return truthOf(left != right), nil
+		default:
+			return truthUnknown, errors.New("invalid operator: " + node.Operator.ToStr())
+		}
+	case *QueryUnaryOp:
+		operand, err := e.eval(node.Operand, rec)
+		if err != nil {
+			return truthUnknown, err
+		}
+		switch node.Operator {
+		case OperatorNot:
+			switch operand {
+			case truthTrue:
+				return truthFalse, nil
+			case truthFalse:
+				return truthTrue, nil
+			}
+			return truthUnknown, nil
+		default:
+			return truthUnknown, errors.New("invalid operator: " + node.Operator.ToStr())
+		}
+	case *QueryError:
+		return truthUnknown, node.Diagnostic
+	default:
+		return truthUnknown, errors.New("unsupported query expression node")
+	}
+}
+
+func (e *Evaluator) evalCondition(c *QueryCondition, rec Record) (truth, error) {
+	if c.TypedValue().Kind() == LiteralList {
+		expanded, err := expandList(c)
+		if err != nil {
+			return truthUnknown, err
+		}
+		return e.eval(expanded, rec)
+	}
+	value, found := e.lookup(c.Field, rec)
+	if c.Field == "completed" && !found {
+		return e.evalCompleted(c, rec)
+	}
+	dtype := e.conditionType(c, value)
+	if !found || isMissing(value, dtype) {
+		// Still reject operators that the SQL output would reject.
+		if _, err := e.compareValues(c, dtype, zeroValue(dtype)); err != nil {
+			return truthUnknown, err
+		}
+		return truthUnknown, nil
+	}
+
+	rv := reflect.ValueOf(value)
+	if (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array) && rv.Type().Elem().Kind() != reflect.Uint8 {
+		// Like an EXISTS subquery: true if any related value matches.
+		for i := 0; i < rv.Len(); i++ {
+			item := rv.Index(i).Interface()
+			if isMissing(item, dtype) {
+				continue
+			}
+			ok, err := e.compareValues(c, dtype, item)
+			if err != nil {
+				return truthUnknown, err
+			}
+			if ok {
+				return truthTrue, nil
+			}
+		}
+		return truthFalse, nil
+	}
+
+	ok, err := e.compareValues(c, dtype, value)
+	if err != nil {
+		return truthUnknown, err
+	}
+	return truthOf(ok), nil
+}
+
+// evalCompleted handles the completed subject for records that only carry a
+// completed_at time. As in the SQL output, a task is completed once
+// completed_at is in the past.
+func (e *Evaluator) evalCompleted(c *QueryCondition, rec Record) (truth, error) {
+	var want bool
+	switch {
+	case (c.Value == "true" && c.Operator == OperatorEq) || (c.Value == "false" && c.Operator == OperatorNeq):
+		want = true
+	case (c.Value == "true" && c.Operator == OperatorNeq) || (c.Value == "false" && c.Operator == OperatorEq):
+		want = false
+	default:
+		return truthUnknown, errors.New("invalid value: " + c.Value + " for field: " + c.Field)
+	}
+	value, found := rec.Lookup("completed_at")
+	if !found || isMissing(value, DTypeDateTime) {
+		return truthOf(!want), nil
+	}
+	completedAt, err := toTime(value)
+	if err != nil {
+		return truthUnknown, fmt.Errorf("field %s: %w", c.Field, err)
+	}
+	return truthOf(completedAt.Before(e.Clock.now()) == want), nil
+}
+
+// lookup finds the record value for a subject, trying its name, aliases and
+// table-qualified column name. The bare column name is not tried, since it can
+// be the name of another subject, as tag's column name is an alias of title.
+func (e *Evaluator) lookup(field string, rec Record) (any, bool) {
+	keys := []string{field}
+	if subject, err := e.schemaOrDefault().Subject(field); err == nil {
+		keys = append(keys, subject.Name)
+		keys = append(keys, subject.Aliases...)
+		if subject.Table != "" && subject.Column != "" {
+			keys = append(keys, subject.Table+"."+subject.Column)
+		}
+	}
+	for _, key := range keys {
+		if value, ok := rec.Lookup(key); ok {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+// conditionType decides how a condition's value is compared. Like the SQL
+// output, the schema's field type lists come first, checked against both the
+// subject and its column. After that the subject's declared type is used, and
+// finally the Go type of the record value.
+func (e *Evaluator) conditionType(c *QueryCondition, value any) DType {
+	names := []string{c.Field}
+	subject, err := e.schemaOrDefault().Subject(c.Field)
+	if err == nil && subject.Column != "" {
+		names = append(names, subject.Column)
+	}
+	for _, name := range names {
+		switch {
+		case slices.Contains(e.schemaOrDefault().dateTypes, name):
+			if c.TypedValue().Kind() == LiteralDateTime {
+				return DTypeDateTime
+			}
+			return DTypeDate
+		case slices.Contains(e.schemaOrDefault().boolTypes, name):
+			return dtypeBool
+		case slices.Contains(e.schemaOrDefault().stringTypes, name):
+			return DTypeString
+		case slices.Contains(e.schemaOrDefault().numericTypes, name):
+			return numericType(c)
+		}
+	}
+	if err == nil && len(subject.ValidTypes) > 0 {
+		for _, dtype := range subject.ValidTypes {
+			if dtype == DTypeDateTime && c.TypedValue().Kind() == LiteralDateTime {
+				return DTypeDateTime
+			}
+		}
+		return subject.ValidTypes[0]
+	}
+	switch v := value.(type) {
+	case time.Time, *time.Time:
+		return DTypeDate
+	case bool:
+		return dtypeBool
+	case string:
+		return DTypeString
+	default:
+		kind := reflect.ValueOf(v).Kind()
+		if kind >= reflect.Int && kind <= reflect.Uint64 {
+			return DTypeInt
+		}
+		if kind == reflect.Float32 || kind == reflect.Float64 {
+			return DTypeFloat
+		}
+	}
+	return DTypeString
+}
+
+// dtypeBool is used internally for the schema's boolTypes columns, which have
+// no DType of their own.
+const dtypeBool DType = -1
+
+// zeroValue returns a record value of the kind compareValues expects for dtype.
+func zeroValue(dtype DType) any {
+	switch dtype {
+	case DTypeDate, DTypeDateTime:
+		return time.Time{}
+	case DTypeInt, DTypeFloat:
+		return 0
+	case dtypeBool:
+		return false
+	}
+	return ""
+}
+
+func isNil(value any) bool {
+	if value == nil {
+		return true
+	}
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Pointer, reflect.Interface, reflect.Map:
+		// A nil slice is an empty list, as NOT EXISTS treats no related rows.
+		return rv.IsNil()
+	}
+	return false
+}
+
+// isMissing reports whether a record value counts as no value: nil, or for a
+// date an empty string or the zero time, as held by structs whose date was
+// never set.
+func isMissing(value any, dtype DType) bool {
+	if isNil(value) {
+		return true
+	}
+	if dtype != DTypeDate && dtype != DTypeDateTime {
+		return false
+	}
+	switch v := value.(type) {
+	case string:
+		return v == ""
+	case time.Time:
+		return v.IsZero()
+	case *time.Time:
+		return v.IsZero()
+	}
+	return false
+}
+
+// compareValues applies the condition's operator to a single record value.
+func (e *Evaluator) compareValues(c *QueryCondition, dtype DType, value any) (bool, error) {
+	switch dtype {
+	case DTypeDate, DTypeDateTime:
+		want, wantType, err := e.dateValue(c)
+		if err != nil {
+			return false, err
+		}
+		got, err := toTime(value)
+		if err != nil {
+			return false, fmt.Errorf("field %s: %w", c.Field, err)
+		}
+		if dtype == DTypeDate && wantType == DTypeDate {
+			got = time.Date(got.Year(), got.Month(), got.Day(), 0, 0, 0, 0, time.UTC)
+		}
+		return compareOrdered(c, got.Compare(want))
+	case DTypeInt, DTypeFloat:
+		// Validate the value the same way the SQL output does, then compare
+		// exactly so large integers do not lose precision.
+		w := newSQLWriter(SQLOptions{})
+		var err error
+		if dtype == DTypeInt {
+			_, err = w.intValue(c)
+		} else {
+			_, err = w.floatValue(c)
+		}
+		if err != nil {
+			return false, err
+		}
+		want, ok := new(big.Rat).SetString(c.Value)
+		if !ok {
+			return false, errors.New("invalid value: " + c.Value + " for field: " + c.Field)
+		}
+		got, err := toRat(value)
+		if err != nil {
+			return false, fmt.Errorf("field %s: %w", c.Field, err)
+		}
+		return compareOrdered(c, got.Cmp(want))
+	case dtypeBool:
+		want, err := strconv.ParseBool(c.Value)
+		if err != nil {
+			return false, errors.New("invalid value: " + c.Value + " for field: " + c.Field)
+		}
+		got, ok := value.(bool)
+		if !ok {
+			return false, fmt.Errorf("field %s: expected bool, got %T", c.Field, value)
+		}
+		switch c.Operator {
+		case OperatorEq:
+			return got == want, nil
+		case OperatorNeq:
+			return got != want, nil
+		}
+		return false, errors.New("invalid operator: " + c.Operator.ToStr() + " for field: " + c.Field)
+	default:
+		got := fmt.Sprint(value)
+		switch c.Operator {
+		case OperatorEq:
+			return got == c.Value, nil
+		case OperatorNeq:
+			return got != c.Value, nil
+		case OperatorCnt:
+			return strings.Contains(strings.ToLower(got), strings.ToLower(c.Value)), nil
+		case OperatorSW:
+			return strings.HasPrefix(strings.ToLower(got), strings.ToLower(c.Value)), nil
+		case OperatorEw:
+			return strings.HasSuffix(strings.ToLower(got), strings.ToLower(c.Value)), nil
+		}
+		return false, errors.New("invalid operator: " + c.Operator.ToStr() + " for field: " + c.Field)
+	}
+}
+
+// compareOrdered applies an ordering operator to the result of comparing the
+// record value with the condition value.
+func compareOrdered(c *QueryCondition, cmp int) (bool, error) {
+	switch c.Operator {
+	case OperatorEq:
+		return cmp == 0, nil
+	case OperatorNeq:
+		return cmp != 0, nil
+	case OperatorGt:
+		return cmp > 0, nil
+	case OperatorLT:
+		return cmp < 0, nil
+	case OperatorGte:
+		return cmp >= 0, nil
+	case OperatorLte:
+		return cmp <= 0, nil
+	}
+	return false, errors.New("invalid operator: " + c.Operator.ToStr() + " for field: " + c.Field)
+}
+
+// dateValue returns the condition's date or date-time value. Dates are
+// returned as midnight UTC of the day they name, relative dates included.
+func (e *Evaluator) dateValue(c *QueryCondition) (time.Time, DType, error) {
+	value := c.TypedValue()
+	switch value.Kind() {
+	case LiteralRelativeDate:
+		t, dtype, err := resolveRelativeDate(value.String(), e.Clock)
+		if err != nil {
+			return time.Time{}, dtype, err
+		}
+		if dtype == DTypeDate {
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		}
+		return t, dtype, nil
+	case LiteralDate:
+		return value.Time(), DTypeDate, nil
+	case LiteralDateTime:
+		return value.Time(), DTypeDateTime, nil
+	}
+	return time.Time{}, DTypeDate, errors.New("invalid value: " + c.Value + " for field: " + c.Field)
+}
+
+// parseDateValue parses a date or date-time value as written in a query.
+// Values without a zone are read as UTC.
+func parseDateValue(s string) (time.Time, error) {
+	for _, layout := range []string{time.DateOnly, "2006-01-02T15:04:05", time.RFC3339} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date: %s", s)
+}
+
+func toTime(value any) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case *time.Time:
+		return *v, nil
+	case string:
+		return parseDateValue(v)
+	}
+	return time.Time{}, fmt.Errorf("expected a time.Time or date string, got %T", value)
+}
+
+func toRat(value any) (*big.Rat, error) {
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return new(big.Rat).SetInt64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return new(big.Rat).SetUint64(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		if r := new(big.Rat).SetFloat64(rv.Float()); r != nil {
+			return r, nil
+		}
+		return nil, fmt.Errorf("cannot compare %v", value)
+	case reflect.String:
+		if r, ok := new(big.Rat).SetString(rv.String()); ok {
+			return r, nil
+		}
+		return nil, fmt.Errorf("invalid number: %s", rv.String())
+	}
+	return nil, fmt.Errorf("expected a number, got %T", value)
+}
@@ -0,0 +1,221 @@
+package ntql
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type evaluatorTask struct {
+	Title    string
+	Priority int
+	DueDate  time.Time `ntql:"tasks.due_date"`
+	Owner    string    `ntql:"createdBy"`
+	Tags     []string  `ntql:"tag"`
+	Hidden   *bool     `ntql:"hide_from_calendar"`
+}
+
+func TestEvaluatorMapRecord(t *testing.T) {
+	q := &QueryBinaryOp{
+		Left:     &QueryCondition{Field: "title", Value: "REPORT", Operator: OperatorCnt},
+		Right:    &QueryCondition{Field: "priority", Value: "2", Operator: OperatorGte},
+		Operator: OperatorAnd,
+	}
+	record := map[string]any{"title": "Weekly report", "priority": 3}
+	ok, err := Evaluate(q, record)
+	if err != nil {
+		t.Fatalf("Evaluate() failed: %v", err)
+	}
+	if !ok {
+		t.Fatalf("expected record to match")
+	}
+
+	record["priority"] = 1
+	ok, err = Evaluate(q, record)
+	if err != nil {
+		t.Fatalf("Evaluate() failed: %v", err)
+	}
+	if ok {
+		t.Fatalf("expected record not to match")
+	}
+}
+
+func TestEvaluatorStructRecord(t *testing.T) {
+	task := evaluatorTask{
+		Title:    "Ship release",
+		Priority: 1,
+		DueDate:  time.Date(2024, 3, 10, 15, 30, 0, 0, time.UTC),
+		Owner:    "alice",
+		Tags:     []string{"work", "urgent"},
+	}
+	cases := []struct {
+		expr     QueryExpr
+		expected bool
+	}{
+		{&QueryCondition{Field: "due", Value: "2024-03-11", Operator: OperatorLT}, true},
+		{&QueryCondition{Field: "due", Value: "2024-03-10", Operator: OperatorEq}, true},
+		{&QueryCondition{Field: "due", Value: "2024-03-10T16:00:00", Operator: OperatorGt}, false},
+		{&QueryCondition{Field: "title", Value: "ship", Operator: OperatorSW}, true},
+		{&QueryCondition{Field: "title", Value: "ASE", Operator: OperatorEw}, true},
+		{&QueryCondition{Field: "createdBy", Value: "alice", Operator: OperatorEq}, true},
+		{&QueryCondition{Field: "tag", Value: "urgent", Operator: OperatorEq}, true},
+		{&QueryCondition{Field: "tag", Value: "home", Operator: OperatorEq}, false},
+		{NewQueryNot(&QueryCondition{Field: "tag", Value: "home", Operator: OperatorEq}), true},
+	}
+	for _, tc := range cases {
+		ok, err := Evaluate(tc.expr, &task)
+		if err != nil {
+			t.Fatalf("Evaluate(%s) failed: %v", tc.expr, err)
+		}
+		if ok != tc.expected {
+			t.Fatalf("expected Evaluate(%s) to return %v, got %v", tc.expr, tc.expected, ok)
+		}
+	}
+}
+
+func TestEvaluatorLookupIgnoresBareColumn(t *testing.T) {
+	// tag's column is name, which is an alias of title.
+	cond := &QueryCondition{Field: "tag", Value: "urgent", Operator: OperatorEq}
+	if ok, err := Evaluate(cond, MapRecord{"name": "urgent"}); err != nil || ok {
+		t.Fatalf("expected a title not to be read as a tag, got %v, %v", ok, err)
+	}
+	if ok, err := Evaluate(cond, MapRecord{"tags.name": "urgent"}); err != nil || !ok {
+		t.Fatalf("expected the qualified column to be read, got %v, %v", ok, err)
+	}
+}
+
+func TestEvaluatorEmptyDateIsUnknown(t *testing.T) {
+	type task struct {
+		Due string
+	}
+	cond := &QueryCondition{Field: "due", Value: "2024-03-11", Operator: OperatorLT}
+	for _, record := range []any{task{}, MapRecord{"due": time.Time{}}} {
+		for _, expr := range []QueryExpr{cond, NewQueryNot(cond)} {
+			ok, err := Evaluate(expr, record)
+			if err != nil {
+				t.Fatalf("Evaluate(%s) failed for %#v: %v", expr, record, err)
+			}
+			if ok {
+				t.Fatalf("expected Evaluate(%s) to be unknown for %#v", expr, record)
+			}
+		}
+	}
+}
+
+func TestEvaluatorMissingValueIsUnknown(t *testing.T) {
+	cond := &QueryCondition{Field: "hide_from_calendar", Value: "true", Operator: OperatorEq}
+	task := evaluatorTask{}
+
+	for _, expr := range []QueryExpr{cond, NewQueryNot(cond)} {
+		ok, err := Evaluate(expr, task)
+		if err != nil {
+			t.Fatalf("Evaluate(%s) failed: %v", expr, err)
+		}
+		if ok {
+			t.Fatalf("expected Evaluate(%s) to be false for a nil value", expr)
+		}
+	}
+
+	// unknown OR true is true
+	q := NewQueryOr(NewQueryNot(cond), &QueryCondition{Field: "title", Value: "", Operator: OperatorEq})
+	ok, err := Evaluate(q, task)
+	if err != nil {
+		t.Fatalf("Evaluate() failed: %v", err)
+	}
+	if !ok {
+		t.Fatalf("expected unknown OR true to match")
+	}
+}
+
+func TestEvaluatorCompleted(t *testing.T) {
+	done := MapRecord{"completed_at": time.Now().Add(-time.Hour)}
+	open := MapRecord{"completed_at": nil}
+	cond := &QueryCondition{Field: "completed", Value: "true", Operator: OperatorEq}
+
+	if ok, err := Evaluate(cond, done); err != nil || !ok {
+		t.Fatalf("expected completed task to match, got %v, %v", ok, err)
+	}
+	if ok, err := Evaluate(cond, open); err != nil || ok {
+		t.Fatalf("expected open task not to match, got %v, %v", ok, err)
+	}
+}
+
+func TestEvaluatorRejectsInvalidOperator(t *testing.T) {
+	q := &QueryCondition{Field: "title", Value: "x", Operator: OperatorGt}
+	if _, err := Evaluate(q, MapRecord{}); err == nil {
+		t.Fatalf("expected an error for an operator the field does not support")
+	}
+	q = &QueryCondition{Field: "priority", Value: "high", Operator: OperatorEq}
+	if _, err := Evaluate(q, MapRecord{"priority": 1}); err == nil {
+		t.Fatalf("expected an error for a non-numeric priority")
+	}
+}
+
+func TestEvaluatorWithSchema(t *testing.T) {
+	schema, err := NewSchemaFromYAML([]byte(ticketSchemaYAML))
+	if err != nil {
+		t.Fatalf("NewSchemaFromYAML() failed: %v", err)
+	}
+	e := NewEvaluator(schema)
+	q := NewQueryAnd(
+		&QueryCondition{Field: "customer", Value: "Acme", Operator: OperatorEq},
+		&QueryCondition{Field: "severity", Value: "3", Operator: OperatorGt},
+	)
+	// customer is read from its qualified column name, severity from the
+	// field types.
+	ok, err := e.Evaluate(q, MapRecord{"customers.name": "Acme", "severity": int64(4)})
+	if err != nil {
+		t.Fatalf("Evaluate() failed: %v", err)
+	}
+	if !ok {
+		t.Fatalf("expected record to match")
+	}
+	if _, err := e.Evaluate(q, 42); err == nil {
+		t.Fatalf("expected an error for an unsupported record type")
+	}
+}
+
+func TestEvaluatorNumbers(t *testing.T) {
+	cases := []struct {
+		cond     *QueryCondition
+		value    any
+		expected bool
+	}{
+		{&QueryCondition{Field: "priority", Value: "10", Operator: OperatorGte}, 12, true},
+		{&QueryCondition{Field: "priority", Value: "-3", Operator: OperatorLT}, int8(-4), true},
+		{&QueryCondition{Field: "priority", Value: "2.5", Operator: OperatorGt}, 2.25, false},
+		{&QueryCondition{Field: "priority", Value: "9007199254740993", Operator: OperatorEq}, int64(9007199254740992), false},
+	}
+	for _, tc := range cases {
+		ok, err := Evaluate(tc.cond, MapRecord{"priority": tc.value})
+		if err != nil {
+			t.Fatalf("Evaluate(%s) failed: %v", tc.cond, err)
+		}
+		if ok != tc.expected {
+			t.Fatalf("expected Evaluate(%s) with %v to return %v", tc.cond, tc.value, tc.expected)
+		}
+	}
+}
+
+func TestEvaluatorNilSchema(t *testing.T) {
+	cond := &QueryCondition{Field: "title", Value: "a", Operator: OperatorEq}
+	for _, e := range []*Evaluator{NewEvaluator(nil), {}} {
+		if ok, err := e.Evaluate(cond, MapRecord{"title": "a"}); err != nil || !ok {
+			t.Fatalf("expected the default schema to be used, got %v, %v", ok, err)
+		}
+	}
+}
+
+func TestEvaluatorNilSliceIsEmpty(t *testing.T) {
+	expr := NewQueryNot(&QueryCondition{Field: "tag", Value: "a", Operator: OperatorEq})
+	// The join query matches a task without tags: no related row is a.
+	sql, err := BuildSQLJoinQuery(expr, JoinQueryOptions{})
+	if err != nil || !strings.Contains(sql, "NOT (EXISTS") {
+		t.Fatalf("expected NOT EXISTS, got %s, %v", sql, err)
+	}
+	for _, tags := range [][]string{nil, {}} {
+		if ok, err := Evaluate(expr, evaluatorTask{Tags: tags}); err != nil || !ok {
+			t.Fatalf("expected %s to match the tags %#v, as the join query does, got %v, %v", expr, tags, ok, err)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package ntql
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Format renders expr as canonical NTQL for the default schema. See
+// FormatWithSchema.
+func Format(expr QueryExpr) (string, error) {
+	return FormatWithSchema(DefaultSchema(), expr)
+}
+
+// FormatWithSchema renders expr as canonical NTQL that parses back to the same
+// expression:
+//
+//	title.contains("foo") AND !(status.equals("done"))
+//
+// Subjects and verbs are written with their canonical names, connectors in
+// upper case, and every NOT as ! followed by parentheses. Parentheses are
+// only added where precedence needs them. Conditions on the same subject and
+// verb that were distributed from one function call, such as
+// title.contains("a") OR title.contains("b"), are collapsed back into
+// title.contains("a" OR "b").
+//
+// A condition whose subject has no verb for its operator, such as the
+// notEquals that Normalize writes, is written as the negation of the verb for
+// the opposite operator, as in !(title.equals("a")).
+//
+// It fails for expressions that NTQL cannot express, such as XOR, error nodes
+// from ParseWithRecovery, and subjects, verbs or values that schema does not
+// have.
+func FormatWithSchema(schema *Schema, expr QueryExpr) (string, error) {
+	if expr == nil {
+		return "", errors.New("empty query")
+	}
+	f := &formatter{schema: schema}
+	return f.expr(expr, "")
+}
+
+// FormatQuery parses query against schema and returns it formatted by
+// FormatWithSchema.
+func FormatQuery(schema *Schema, query string) (string, error) {
+	tokens, err := NewLexerWithSchema(schema, query).Lex()
+	if err != nil {
+		return "", err
+	}
+	if len(tokens) == 0 {
+		return "", errors.New("empty query")
+	}
+	expr, err := NewParserWithSchema(schema, tokens).Parse()
+	if err != nil {
+		return "", err
+	}
+	return FormatWithSchema(schema, expr)
+}
+
+type formatter struct {
+	schema *Schema
+}
+
+// expr formats e as an operand of parent, which is empty at the top level and
+// inside parentheses.
+func (f *formatter) expr(e QueryExpr, parent Operator) (string, error) {
+	switch e := e.(type) {
+	case *QueryCondition:
+		if e.TypedValue().Kind() == LiteralList {
+			expanded, err := expandList(e)
+			if err != nil {
+				return "", err
+			}
+			return f.expr(expanded, parent)
+		}
+		if f.negated(e) {
+			opposite := *e
+			opposite.Operator = negatedOperators[e.Operator]
+			return f.expr(NewQueryNot(&opposite), parent)
+		}
+		return f.call(e, func() (string, error) {
+			return f.value(e)
+		})
+	case *QueryUnaryOp:
+		if e.Operator != OperatorNot {
+			return "", errors.New("invalid operator: " + e.Operator.ToStr())
+		}
+		operand, err := f.expr(e.Operand, "")
+		if err != nil {
+			return "", err
+		}
+		return "!(" + operand + ")", nil
+	case *QueryBinaryOp:
+		if conditions, ok := sameCall(e); ok && !f.negated(conditions[0]) {
+			return f.call(conditions[0], func() (string, error) {
+				return f.values(e, "")
+			})
+		}
+		if e.Operator != OperatorAnd && e.Operator != OperatorOr {
+			return "", fmt.Errorf("operator %s cannot be written in NTQL", e.Operator)
+		}
+		left, err := f.expr(e.Left, e.Operator)
+		if err != nil {
+			return "", err
+		}
+		right, err := f.expr(e.Right, e.Operator)
+		if err != nil {
+			return "", err
+		}
+		return group(left+" "+string(e.Operator)+" "+right, e.Operator, parent), nil
+	case *QueryError:
+		return "", e.Diagnostic
+	}
+	return "", fmt.Errorf("cannot format %T", e)
+}
+
+// values formats the value expression of a collapsed function call, in which
+// every condition has the same subject and verb.
+func (f *formatter) values(e QueryExpr, parent Operator) (string, error) {
+	switch e := e.(type) {
+	case *QueryCondition:
+		return f.value(e)
+	case *QueryUnaryOp:
+		if e.Operator != OperatorNot {
+			return "", errors.New("invalid operator: " + e.Operator.ToStr())
+		}
+		operand, err := f.values(e.Operand, "")
+		if err != nil {
+			return "", err
+		}
+		return "!(" + operand + ")", nil
+	case *QueryBinaryOp:
+		if e.Operator != OperatorAnd && e.Operator != OperatorOr {
+			return "", fmt.Errorf("operator %s cannot be written in NTQL", e.Operator)
+		}
+		left, err := f.values(e.Left, e.Operator)
+		if err != nil {
+			return "", err
+		}
+		right, err := f.values(e.Right, e.Operator)
+		if err != nil {
+			return "", err
+		}
+		return group(left+" "+string(e.Operator)+" "+right, e.Operator, parent), nil
+	}
+	return "", fmt.Errorf("cannot format %T", e)
+}
+
+// negated reports whether c is written as NOT of the verb for the negation
+// of its operator, which its subject has no verb for.
+func (f *formatter) negated(c *QueryCondition) bool {
+	subject, err := f.schema.Subject(c.Field)
+	if err != nil {
+		return false
+	}
+	_, negated, _ := f.schema.conditionVerb(subject, c.Operator)
+	return negated
+}
+
+// group wraps an operation in parentheses when it binds more loosely than its
+// parent: an OR inside an AND.
+func group(s string, op, parent Operator) string {
+	if op == OperatorOr && parent == OperatorAnd {
+		return "(" + s + ")"
+	}
+	return s
+}
+
+// call formats a function call on the subject and verb of c, with the
+// arguments written by args.
+func (f *formatter) call(c *QueryCondition, args func() (string, error)) (string, error) {
+	subject, err := f.schema.Subject(c.Field)
+	if err != nil {
+		return "", fmt.Errorf("unknown subject: %s", c.Field)
+	}
+	verb, ok := f.schema.verbFor(subject, c.Operator)
+	if !ok {
+		return "", fmt.Errorf("subject %s has no verb for operator %s", subject.Name, c.Operator)
+	}
+	value, err := args()
+	if err != nil {
+		return "", err
+	}
+	return subject.Name + "." + verb + "(" + value + ")", nil
+}
+
+// value formats the value of c so that it lexes back as a value its subject
+// accepts. Values that lex as a date, number or relative date the subject
+// accepts are written bare; other values are quoted for string subjects and
+// written as a tag for tag subjects.
+func (f *formatter) value(c *QueryCondition) (string, error) {
+	subject, err := f.schema.Subject(c.Field)
+	if err != nil {
+		return "", fmt.Errorf("unknown subject: %s", c.Field)
+	}
+	kind := c.TypedValue().tokenType()
+	if kind != TokenString && kind != TokenTag && slices.Contains(valueTokenTypes(subject.ValidTypes), kind) {
+		return c.Value, nil
+	}
+	if subject.acceptsType(DTypeString) {
+		return quoteString(c.Value), nil
+	}
+	if subject.acceptsType(DTypeTag) && isSingleLexeme(c.Value) {
+		return c.Value, nil
+	}
+	return "", fmt.Errorf("invalid value: %s for field: %s", c.Value, c.Field)
+}
+
+// isSingleLexeme reports whether s scans as exactly one lexeme.
+func isSingleLexeme(s string) bool {
+	scanner := NewScanner(s)
+	lexeme, err := scanner.ScanLexeme()
+	return err == nil && string(lexeme) == s && scanner.atEnd()
+}
+
+// sameCall returns the conditions of e if there are at least two and they all
+// have the same subject and verb, so e can be written as one function call.
+func sameCall(e QueryExpr) ([]*QueryCondition, bool) {
+	var conditions []*QueryCondition
+	var collect func(e QueryExpr) bool
+	collect = func(e QueryExpr) bool {
+		switch e := e.(type) {
+		case *QueryCondition:
+			if len(conditions) > 0 && (e.Field != conditions[0].Field || e.Operator != conditions[0].Operator) {
+				return false
+			}
+			conditions = append(conditions, e)
+			return true
+		case *QueryUnaryOp:
+			return e.Operator == OperatorNot && collect(e.Operand)
+		case *QueryBinaryOp:
+			return (e.Operator == OperatorAnd || e.Operator == OperatorOr) && collect(e.Left) && collect(e.Right)
+		}
+		return false
+	}
+	if !collect(e) || len(conditions) < 2 {
+		return nil, false
+	}
+	return conditions, true
+}
@@ -0,0 +1,69 @@
+package ntql
+
+import (
+	"testing"
+)
+
+func TestFormatQuery(t *testing.T) {
+	cases := []struct {
+		query    string
+		expected string
+	}{
+		{`title.contains("foo") AND !status.eq("done")`, `title.contains("foo") AND !(status.equals("done"))`},
+		{`name.contains("a" or "b")`, `title.contains("a" OR "b")`},
+		{`(title.contains("a") OR title.contains("b")) AND deadline.before(today)`, `title.contains("a" OR "b") AND due.before(today)`},
+		{`title.startswith("a" AND !("b" OR "c"))`, `title.startswith("a" AND !("b" OR "c"))`},
+		{`title.eq("a") OR (title.eq("b") AND priority.eq(3))`, `title.equals("a") OR title.equals("b") AND priority.equals(3)`},
+		{`(title.eq("a") OR priority.eq(1)) AND tag.eq(school)`, `(title.equals("a") OR priority.equals(1)) AND tag.equals(school)`},
+		{"due.after(2024-01-01T10:00:00)\n  OR  due.equals(tomorrow+1d)", `due.after(2024-01-01T10:00:00) OR due.equals(tomorrow+1d)`},
+		{`title.eq("c\"\\runch")`, `title.equals("c\"\\runch")`},
+		{`title.eq("3")`, `title.equals("3")`},
+	}
+	for _, tc := range cases {
+		formatted, err := FormatQuery(DefaultSchema(), tc.query)
+		if err != nil {
+			t.Fatalf("FormatQuery(%s) failed: %v", tc.query, err)
+		}
+		if formatted != tc.expected {
+			t.Errorf("FormatQuery(%s) = %s, expected %s", tc.query, formatted, tc.expected)
+		}
+		// Formatting is stable and parses back to the same expression.
+		again, err := FormatQuery(DefaultSchema(), formatted)
+		if err != nil || again != formatted {
+			t.Errorf("reformatting %s returned %s: %v", formatted, again, err)
+		}
+		original, _ := parseQuery(tc.query)
+		reparsed, _ := parseQuery(formatted)
+		if original.String() != reparsed.String() {
+			t.Errorf("expected %s to parse as %s, got %s", formatted, original, reparsed)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	a := &QueryCondition{Field: "title", Operator: OperatorCnt, Value: `say "hi"`}
+	b := &QueryCondition{Field: "title", Operator: OperatorCnt, Value: "bye"}
+	c := &QueryCondition{Field: "tag", Operator: OperatorEq, Value: "work"}
+	formatted, err := Format(NewQueryAnd(NewQueryOr(a, NewQueryNot(b)), c))
+	if err != nil {
+		t.Fatalf("Format() failed: %v", err)
+	}
+	if expected := `title.contains("say \"hi\"" OR !("bye")) AND tag.equals(work)`; formatted != expected {
+		t.Fatalf("expected %s, got %s", expected, formatted)
+	}
+
+	invalid := []QueryExpr{
+		nil,
+		&QueryBinaryOp{Left: a, Right: c, Operator: OperatorXor},
+		&QueryCondition{Field: "color", Operator: OperatorEq, Value: "red"},
+		&QueryCondition{Field: "due", Operator: OperatorCnt, Value: "today"},
+		&QueryCondition{Field: "priority", Operator: OperatorEq, Value: "high"},
+		&QueryCondition{Field: "tag", Operator: OperatorEq, Value: "two words"},
+		&QueryError{Diagnostic: Diagnostic{Code: CodeUnknownSubject, Message: "Invalid subject: titel"}},
+	}
+	for _, expr := range invalid {
+		if formatted, err := Format(expr); err == nil {
+			t.Errorf("expected an error formatting %v, got %s", expr, formatted)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package ntql
+
+import (
+	"context"
+	"slices"
+	"strings"
+)
+
+// Scores given by fuzzyScore. Better kinds of match always outrank worse
+// ones; within a kind, closer matches score higher.
+const (
+	scoreExact       = 100.0
+	scorePrefix      = 80.0
+	scoreSubsequence = 60.0
+	scoreExtends     = 40.0
+	scoreTypo        = 30.0
+)
+
+// Bonuses added to fuzzyScore when ranking completions. A recently used item
+// gets scoreRecent, less two points for each more recent one, but never less
+// than two points.
+const (
+	scoreCanonical = 5.0
+	scoreRecent    = 20.0
+)
+
+// candidate is a label that may be suggested.
+type candidate struct {
+	label string
+	// canonical is false for aliases.
+	canonical bool
+}
+
+// rankedCandidate is a candidate with its score.
+type rankedCandidate struct {
+	candidate
+	score float64
+}
+
+// candidates returns every label that could be suggested in c, whether or not
+// it matches the prefix, in schema order.
+func (e *CompletionEngine) candidates(ctx context.Context, c completionContext) ([]candidate, error) {
+	var candidates []candidate
+	switch c.kind {
+	case CompletionSubject:
+		for _, subject := range e.schema.subjects {
+			candidates = append(candidates, candidate{label: subject.Name, canonical: true})
+			for _, alias := range subject.Aliases {
+				candidates = append(candidates, candidate{label: alias})
+			}
+		}
+	case CompletionVerb:
+		if _, ok := e.verbTries[toLowerCase(c.subject.Name)]; !ok {
+			// Subjects that are not in the schema have no verbs.
+			return nil, nil
+		}
+		for _, verb := range c.subject.ValidVerbs {
+			candidates = append(candidates, candidate{label: verb.Name, canonical: true})
+			for _, alias := range verb.Aliases {
+				candidates = append(candidates, candidate{label: alias})
+			}
+		}
+	case CompletionConnector:
+		for _, connector := range e.connectors {
+			candidates = append(candidates, candidate{label: connector, canonical: true})
+		}
+	case CompletionValue:
+		if provider, ok := e.providers[toLowerCase(c.subject.Name)]; ok {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			// Providers are asked for every value, since a fuzzy match need
+			// not start with the prefix.
+			values, err := e.fetchValues(ctx, provider, *c.subject, "")
+			if err != nil {
+				return nil, err
+			}
+			for _, value := range values {
+				candidates = append(candidates, candidate{label: formatValue(*c.subject, value), canonical: true})
+			}
+			return candidates, nil
+		}
+		var values []string
+		for _, dtype := range c.subject.ValidTypes {
+			switch dtype {
+			case DTypeTag:
+				values = append(values, e.tags...)
+			case DTypeDate:
+				values = append(values, relativeDateSuggestions...)
+			case DTypeDateTime:
+				values = append(values, relativeDateTimeSuggestions...)
+			}
+		}
+		for _, value := range values {
+			candidates = append(candidates, candidate{label: value, canonical: true})
+		}
+	}
+	return candidates, nil
+}
+
+// rankCandidates scores the candidates that fuzzily match prefix and sorts
+// them best first. Canonical names and the labels in recent, most recent
+// first, get a bonus. Candidates with equal scores keep their order.
+func rankCandidates(prefix string, candidates []candidate, recent []string) []rankedCandidate {
+	prefix = unquoteValue(prefix)
+	ranked := make([]rankedCandidate, 0, len(candidates))
+	seen := map[string]bool{}
+	for _, c := range candidates {
+		if seen[c.label] {
+			continue
+		}
+		seen[c.label] = true
+		score, ok := fuzzyScore(prefix, unquoteValue(c.label))
+		if !ok {
+			continue
+		}
+		if c.canonical {
+			score += scoreCanonical
+		}
+		for i, label := range recent {
+			if strings.EqualFold(label, c.label) {
+				score += max(scoreRecent-2*float64(i), 2)
+				break
+			}
+		}
+		ranked = append(ranked, rankedCandidate{candidate: c, score: score})
+	}
+	slices.SortStableFunc(ranked, func(a, b rankedCandidate) int {
+		switch {
+		case a.score > b.score:
+			return -1
+		case a.score < b.score:
+			return 1
+		}
+		return 0
+	})
+	return ranked
+}
+
+// fuzzyScore reports how well candidate matches the typed input, ignoring
+// case. It reports false if candidate does not match at all.
+// An empty input matches every candidate with a score of zero.
+//
+// From best to worst, candidate may equal the input, start with it, contain
+// its characters in order (createdAt for crt), be the start of it (due for
+// dueDate), or be within a few typos of it (title for titel), counting
+// insertions, deletions, substitutions and swaps of adjacent characters.
+func fuzzyScore(input, candidate string) (float64, bool) {
+	input = toLowerCase(input)
+	candidate = toLowerCase(candidate)
+	if input == "" {
+		return 0, true
+	}
+	if input == candidate {
+		return scoreExact, true
+	}
+	// Closer matches score up to 10 points more than looser ones.
+	closeness := func(a, b string) float64 {
+		return 10 * float64(min(len(a), len(b))) / float64(max(len(a), len(b)))
+	}
+	if strings.HasPrefix(candidate, input) {
+		return scorePrefix + closeness(input, candidate), true
+	}
+	if isSubsequence(input, candidate) {
+		return scoreSubsequence + closeness(input, candidate), true
+	}
+	if strings.HasPrefix(input, candidate) && len(candidate) >= 2 {
+		return scoreExtends + closeness(input, candidate), true
+	}
+	maxEdits := maxTypos(input)
+	distance := editDistance(input, candidate)
+	if len(candidate) > len(input) {
+		// The input may be a misspelt start of the candidate.
+		distance = min(distance, editDistance(input, candidate[:len(input)]))
+	}
+	if distance <= maxEdits {
+		return scoreTypo - 10*float64(distance), true
+	}
+	return 0, false
+}
+
+// maxTypos is the number of typos tolerated in input: none in very short
+// words, one in short words and two in longer ones.
+func maxTypos(input string) int {
+	switch {
+	case len(input) <= 2:
+		return 0
+	case len(input) <= 5:
+		return 1
+	}
+	return 2
+}
+
+// isSubsequence reports whether the bytes of sub appear in s in order.
+func isSubsequence(sub, s string) bool {
+	i := 0
+	for j := 0; i < len(sub) && j < len(s); j++ {
+		if sub[i] == s[j] {
+			i++
+		}
+	}
+	return i == len(sub)
+}
+
+// editDistance returns the optimal string alignment distance between a and b:
+// the number of insertions, deletions, substitutions and transpositions of
+// adjacent bytes needed to turn a into b.
+func editDistance(a, b string) int {
+	// d[i][j] is the distance between a[:i] and b[:j].
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+// maxSuggestions is the most "did you mean" suggestions given for a mistake.
+const maxSuggestions = 3
+
+// closestMatches returns up to maxSuggestions of the candidates that input
+// could be a misspelling of, best first. Candidates with the same key, such as
+// a subject's name and its aliases, are suggested once.
+func closestMatches(input string, candidates []candidate, key func(label string) string) []string {
+	if input == "" {
+		return nil
+	}
+	var suggestions []string
+	seen := map[string]bool{}
+	for _, c := range rankCandidates(input, candidates, nil) {
+		k := key(c.label)
+		if seen[k] || strings.EqualFold(c.label, input) {
+			continue
+		}
+		seen[k] = true
+		suggestions = append(suggestions, c.label)
+		if len(suggestions) == maxSuggestions {
+			break
+		}
+	}
+	return suggestions
+}
+
+// closestSubjects returns the names and aliases of the subjects that name
+// could be a misspelling of. If dtypes are given, only subjects accepting one
+// of them are suggested.
+func (s *Schema) closestSubjects(name string, dtypes ...DType) []string {
+	var candidates []candidate
+	for _, subject := range s.subjects {
+		if len(dtypes) > 0 && !slices.ContainsFunc(dtypes, subject.acceptsType) {
+			continue
+		}
+		candidates = append(candidates, candidate{label: subject.Name, canonical: true})
+		for _, alias := range subject.Aliases {
+			candidates = append(candidates, candidate{label: alias})
+		}
+	}
+	return closestMatches(name, candidates, func(label string) string {
+		subject, _ := s.Subject(label)
+		return subject.Name
+	})
+}
+
+// closestVerbs returns the verbs and aliases of the subject that name could be
+// a misspelling of.
+func (s *Subject) closestVerbs(name string) []string {
+	var candidates []candidate
+	for _, verb := range s.ValidVerbs {
+		candidates = append(candidates, candidate{label: verb.Name, canonical: true})
+		for _, alias := range verb.Aliases {
+			candidates = append(candidates, candidate{label: alias})
+		}
+	}
+	return closestMatches(name, candidates, func(label string) string {
+		verb, _ := s.LookupVerb(label)
+		return verb.Name
+	})
+}
+
+// closestRelativeDates returns the relative dates that word could be a
+// misspelling of, for a subject accepting dtypes.
+func closestRelativeDates(word string, dtypes []DType) []string {
+	var candidates []candidate
+	for _, dtype := range dtypes {
+		switch dtype {
+		case DTypeDate:
+			for _, value := range relativeDateSuggestions {
+				candidates = append(candidates, candidate{label: value, canonical: true})
+			}
+		case DTypeDateTime:
+			for _, value := range relativeDateTimeSuggestions {
+				candidates = append(candidates, candidate{label: value, canonical: true})
+			}
+		}
+	}
+	return closestMatches(word, candidates, toLowerCase)
+}
+
+// didYouMean formats suggestions to follow an error message, such as
+// " (did you mean title or tag?)". It returns "" if there are none.
+func didYouMean(suggestions []string) string {
+	switch len(suggestions) {
+	case 0:
+		return ""
+	case 1:
+		return " (did you mean " + suggestions[0] + "?)"
+	}
+	last := len(suggestions) - 1
+	return " (did you mean " + strings.Join(suggestions[:last], ", ") + " or " + suggestions[last] + "?)"
+}
@@ -0,0 +1,164 @@
+package ntql
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFuzzyScore(t *testing.T) {
+	cases := []struct {
+		input     string
+		candidate string
+		matches   bool
+	}{
+		{"title", "title", true},
+		{"TIT", "title", true},
+		{"dueDate", "due", true},
+		{"crt", "createdAt", true},
+		{"titel", "title", true},
+		{"contians", "contains", true},
+		{"xyz", "title", false},
+		{"d", "title", false},
+		// Two-letter words tolerate no typos.
+		{"ti", "at", false},
+	}
+	for _, tc := range cases {
+		if _, ok := fuzzyScore(tc.input, tc.candidate); ok != tc.matches {
+			t.Errorf("fuzzyScore(%q, %q) matched %v, expected %v", tc.input, tc.candidate, ok, tc.matches)
+		}
+	}
+
+	// Better kinds of match rank higher.
+	ordered := []string{"due", "duel", "dueDate", "dxue", "du", "dua"}
+	previous := 1000.0
+	for _, candidate := range ordered {
+		score, ok := fuzzyScore("due", candidate)
+		if !ok || score >= previous {
+			t.Fatalf("expected %q to score below %v, got %v", candidate, previous, score)
+		}
+		previous = score
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		distance int
+	}{
+		{"", "", 0},
+		{"title", "title", 0},
+		{"titel", "title", 1},
+		{"tile", "title", 1},
+		{"kitten", "sitting", 3},
+		{"", "abc", 3},
+	}
+	for _, tc := range cases {
+		if d := editDistance(tc.a, tc.b); d != tc.distance {
+			t.Errorf("editDistance(%q, %q) = %d, expected %d", tc.a, tc.b, d, tc.distance)
+		}
+	}
+}
+
+func TestSuggestAtFuzzy(t *testing.T) {
+	engine := NewCompletionEngine([]string{"school", "work"})
+	cases := []struct {
+		query string
+		label string
+	}{
+		{`crt`, "createdAt"},
+		{`dueDate`, "due"},
+		{`titel`, "title"},
+		{`title.contians`, "contains"},
+		{`due.before(tdy`, "today"},
+		{`tag.eq(scool`, "school"},
+	}
+	for _, tc := range cases {
+		items, err := engine.SuggestAt(tc.query, len(tc.query))
+		if err != nil {
+			t.Fatalf("SuggestAt(%s) failed: %v", tc.query, err)
+		}
+		if len(items) == 0 || items[0].Label != tc.label {
+			t.Fatalf("expected %s first for %s, got %+v", tc.label, tc.query, items)
+		}
+		if items[0].Score <= 0 {
+			t.Fatalf("expected a positive score for %s, got %v", tc.query, items[0].Score)
+		}
+	}
+}
+
+func TestSuggestAtRanking(t *testing.T) {
+	engine := NewCompletionEngine(nil)
+	ctx := context.Background()
+
+	// An exact prefix of a canonical name beats an alias.
+	items, err := engine.SuggestAtWithOptions(ctx, `sta`, 3, SuggestOptions{})
+	if err != nil {
+		t.Fatalf("SuggestAtWithOptions() failed: %v", err)
+	}
+	if len(items) != 2 || items[0].Label != "status" || items[1].Label != "state" {
+		t.Fatalf("expected status before state, got %+v", items)
+	}
+
+	// Recently used items move up among matches of the same quality.
+	items, err = engine.SuggestAtWithOptions(ctx, `sta`, 3, SuggestOptions{Recent: []string{"state"}})
+	if err != nil {
+		t.Fatalf("SuggestAtWithOptions() failed: %v", err)
+	}
+	if items[0].Label != "state" || items[0].Score <= items[1].Score {
+		t.Fatalf("expected the recent item first, got %+v", items)
+	}
+
+	// With nothing typed, canonical names keep schema order ahead of aliases.
+	items, err = engine.SuggestAtWithOptions(ctx, ``, 0, SuggestOptions{Limit: 2})
+	if err != nil {
+		t.Fatalf("SuggestAtWithOptions() failed: %v", err)
+	}
+	if len(items) != 2 || items[0].Label != "title" || items[1].Label != "due" {
+		t.Fatalf("expected the first two subjects, got %+v", items)
+	}
+}
+
+func TestSuggestAtFuzzyProvidedValues(t *testing.T) {
+	var requests []ValueRequest
+	engine := NewCompletionEngineWithOptions(DefaultSchema(), CompletionOptions{
+		ValueProviders: map[string]ValueProvider{
+			"status": ValueProviderFunc(func(ctx context.Context, req ValueRequest) ([]string, error) {
+				requests = append(requests, req)
+				return []string{"open", "on hold", "closed"}, nil
+			}),
+		},
+	})
+	query := `status.equals("clsd`
+	items, err := engine.SuggestAt(query, len(query))
+	if err != nil {
+		t.Fatalf("SuggestAt() failed: %v", err)
+	}
+	if len(items) != 1 || items[0].Label != `"closed"` {
+		t.Fatalf("expected \"closed\", got %+v", items)
+	}
+	if len(requests) != 1 || requests[0].Prefix != "" {
+		t.Fatalf("expected the provider to be asked for every value, got %+v", requests)
+	}
+}
+
+func TestDidYouMean(t *testing.T) {
+	cases := []struct {
+		suggestions []string
+		expected    string
+	}{
+		{nil, ""},
+		{[]string{"title"}, " (did you mean title?)"},
+		{[]string{"createdAt", "createdBy"}, " (did you mean createdAt or createdBy?)"},
+		{[]string{"a", "b", "c"}, " (did you mean a, b or c?)"},
+	}
+	for _, tc := range cases {
+		if got := didYouMean(tc.suggestions); got != tc.expected {
+			t.Errorf("didYouMean(%q) = %q, expected %q", tc.suggestions, got, tc.expected)
+		}
+	}
+
+	// A subject's name and aliases are suggested once.
+	if got := DefaultSchema().closestSubjects("stat"); len(got) != 1 || got[0] != "status" {
+		t.Fatalf("expected only status, got %q", got)
+	}
+}
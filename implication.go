@@ -0,0 +1,639 @@
+package ntql
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// maxConjunctions limits the disjunctive normal form built by Implies, which
+// can grow exponentially with the size of a query.
+const maxConjunctions = 10000
+
+var errTooComplex = errors.New("query is too complex to compare")
+
+// Implies reports whether every record matching a also matches b, using the
+// default schema. See Evaluator.Implies.
+func Implies(a, b QueryExpr) (bool, MapRecord, error) {
+	return NewEvaluator(DefaultSchema()).Implies(a, b)
+}
+
+// Equivalent reports whether a and b match the same records, using the
+// default schema. See Evaluator.Equivalent.
+func Equivalent(a, b QueryExpr) (bool, MapRecord, error) {
+	return NewEvaluator(DefaultSchema()).Equivalent(a, b)
+}
+
+// Implies reports whether every record that matches a also matches b. If not,
+// it returns a counterexample: a record that matches a but not b.
+//
+// Both queries are normalized by NormalizeWithSchema and a AND NOT b is
+// expanded into a disjunctive normal form. Each conjunction is then solved
+// subject by subject: equals, notEquals and ranges on numbers and dates by
+// interval reasoning, and equals, contains, startsWith and endsWith on strings
+// by building a value that passes them all. A subject without a value matches
+// no condition, as in the evaluator. A subject behind a to-many join holds a
+// list of values, as a slice in counterexamples, and a condition on it
+// matches if any value does. Relative dates are resolved with the
+// evaluator's clock, so the answer holds at that time. XOR is not supported.
+func (e *Evaluator) Implies(a, b QueryExpr) (bool, MapRecord, error) {
+	if a == nil || b == nil {
+		return false, nil, errors.New("query expression cannot be nil")
+	}
+	left, err := e.dnf(a, false)
+	if err != nil {
+		return false, nil, err
+	}
+	right, err := e.dnf(b, true)
+	if err != nil {
+		return false, nil, err
+	}
+	for _, l := range left {
+		for _, r := range right {
+			record, ok, err := e.solve(append(append(conjunction{}, l...), r...))
+			if err != nil {
+				return false, nil, err
+			}
+			if ok {
+				return false, record, nil
+			}
+		}
+	}
+	return true, nil, nil
+}
+
+// Equivalent reports whether a and b match the same records. If not, it
+// returns a counterexample that matches exactly one of them. See Implies.
+func (e *Evaluator) Equivalent(a, b QueryExpr) (bool, MapRecord, error) {
+	if ok, record, err := e.Implies(a, b); !ok || err != nil {
+		return ok, record, err
+	}
+	return e.Implies(b, a)
+}
+
+// literal is a condition in a conjunction. negated is a NOT applied to the
+// condition in the query. A complement literal holds whenever the condition
+// does not, including when the subject has no value.
+type literal struct {
+	condition  *QueryCondition
+	negated    bool
+	complement bool
+}
+
+type conjunction []literal
+
+// dnf returns expr, or its complement, as a disjunction of conjunctions.
+func (e *Evaluator) dnf(expr QueryExpr, complement bool) ([]conjunction, error) {
+	normalized, err := NormalizeWithOptions(expr, NormalizeOptions{Schema: e.schemaOrDefault(), BaseTable: e.BaseTable})
+	if errors.Is(err, ErrContradiction) {
+		if complement {
+			return []conjunction{{}}, nil
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return toDNF(normalized, complement)
+}
+
+// toDNF returns a normalized expression, or its complement, as a disjunction
+// of conjunctions.
+func toDNF(expr QueryExpr, complement bool) ([]conjunction, error) {
+	switch e := expr.(type) {
+	case *QueryCondition:
+		return []conjunction{{{condition: e, complement: complement}}}, nil
+	case *QueryUnaryOp:
+		if c, ok := e.Operand.(*QueryCondition); ok && e.Operator == OperatorNot {
+			return []conjunction{{{condition: c, negated: true, complement: complement}}}, nil
+		}
+		if q, ok := e.Operand.(*QueryError); ok {
+			return nil, q.Diagnostic
+		}
+	case *QueryBinaryOp:
+		if e.Operator != OperatorAnd && e.Operator != OperatorOr {
+			return nil, fmt.Errorf("operator %s is not supported", e.Operator)
+		}
+		left, err := toDNF(e.Left, complement)
+		if err != nil {
+			return nil, err
+		}
+		right, err := toDNF(e.Right, complement)
+		if err != nil {
+			return nil, err
+		}
+		// The complement of an AND is the OR of the complements, and the
+		// reverse.
+		if (e.Operator == OperatorOr) != complement {
+			if len(left)+len(right) > maxConjunctions {
+				return nil, errTooComplex
+			}
+			return append(left, right...), nil
+		}
+		if len(left)*len(right) > maxConjunctions {
+			return nil, errTooComplex
+		}
+		var product []conjunction
+		for _, l := range left {
+			for _, r := range right {
+				product = append(product, append(append(conjunction{}, l...), r...))
+			}
+		}
+		return product, nil
+	case *QueryError:
+		return nil, e.Diagnostic
+	}
+	return nil, fmt.Errorf("cannot compare %T", expr)
+}
+
+// valueTest is a condition a non-empty value must pass. negated inverts
+// contains, startsWith and endsWith.
+type valueTest struct {
+	condition *QueryCondition
+	negated   bool
+}
+
+// solve returns a record that satisfies every literal in c, and reports false
+// if there is none.
+func (e *Evaluator) solve(c conjunction) (MapRecord, bool, error) {
+	var fields []string
+	literals := map[string][]literal{}
+	for _, lit := range c {
+		field := lit.condition.Field
+		if _, ok := literals[field]; !ok {
+			fields = append(fields, field)
+		}
+		literals[field] = append(literals[field], lit)
+	}
+
+	record := MapRecord{}
+	for _, field := range fields {
+		solve := e.solveValue
+		if e.schemaOrDefault().toMany(e.BaseTable, field) {
+			solve = e.solveRelated
+		}
+		value, ok, err := solve(field, literals[field])
+		if err != nil || !ok {
+			return nil, false, err
+		}
+		if value != nil {
+			record[field] = value
+		}
+	}
+	return record, true, nil
+}
+
+// solveValue returns a value for a subject with a single value that satisfies
+// every literal, or nil to leave it without a value, which satisfies every
+// complement.
+func (e *Evaluator) solveValue(field string, literals []literal) (any, bool, error) {
+	var tests []valueTest
+	valued := false
+	for _, lit := range literals {
+		test := valueTest{condition: lit.condition, negated: lit.negated}
+		if lit.complement {
+			test = test.negate()
+		} else {
+			valued = true
+		}
+		tests = append(tests, test)
+	}
+	if !valued {
+		return nil, true, nil
+	}
+	return e.solveField(field, tests)
+}
+
+// solveRelated returns the values of a subject behind a to-many join that
+// satisfy every literal. As with EXISTS in the join query, a condition holds
+// if some value passes it, and its negation if none does. When no list of
+// values does and every literal is a complement, it returns nil to leave the
+// subject without a value.
+func (e *Evaluator) solveRelated(field string, literals []literal) (any, bool, error) {
+	values, ok, err := e.solveValues(field, literals)
+	if err != nil || ok {
+		return values, ok, err
+	}
+	for _, lit := range literals {
+		if !lit.complement {
+			return nil, false, nil
+		}
+	}
+	return nil, true, nil
+}
+
+// solveValues returns a list of values that satisfies every literal, and
+// reports false if there is none.
+func (e *Evaluator) solveValues(field string, literals []literal) (any, bool, error) {
+	var some, none []valueTest
+	for _, lit := range literals {
+		test := valueTest{condition: lit.condition}
+		if lit.negated == lit.complement {
+			some = append(some, test)
+		} else {
+			none = append(none, test.negate())
+		}
+	}
+	values := []any{}
+	for _, test := range some {
+		value, ok, err := e.solveField(field, append([]valueTest{test}, none...))
+		if err != nil || !ok {
+			return nil, false, err
+		}
+		values = append(values, value)
+	}
+	return values, true, nil
+}
+
+// negate returns the test for a value that fails t.
+func (t valueTest) negate() valueTest {
+	if op, ok := negatedOperators[t.condition.Operator]; ok {
+		c := *t.condition
+		c.Operator = op
+		return valueTest{condition: &c, negated: t.negated}
+	}
+	return valueTest{condition: t.condition, negated: !t.negated}
+}
+
+// solveField returns a value for field that passes every test, and reports
+// false if there is none.
+func (e *Evaluator) solveField(field string, tests []valueTest) (any, bool, error) {
+	var dates, numbers, bools, ints int
+	for _, t := range tests {
+		switch e.conditionType(t.condition, nil) {
+		case DTypeDate, DTypeDateTime:
+			dates++
+		case DTypeInt:
+			numbers++
+			ints++
+		case DTypeFloat:
+			numbers++
+		case dtypeBool:
+			bools++
+		}
+	}
+	switch len(tests) {
+	case dates:
+		return e.solveDates(tests)
+	case numbers:
+		return solveNumbers(tests, ints == len(tests))
+	case bools:
+		return solveBool(tests)
+	}
+	if dates+numbers+bools > 0 {
+		return nil, false, fmt.Errorf("cannot compare values of different types for field: %s", field)
+	}
+	return solveString(tests)
+}
+
+// interval is a set of ordered values between lo and hi. A nil bound is
+// unbounded.
+type interval struct {
+	lo, hi         *big.Rat
+	loOpen, hiOpen bool
+}
+
+func (i interval) contains(x *big.Rat) bool {
+	if i.lo != nil {
+		if c := x.Cmp(i.lo); c < 0 || (c == 0 && i.loOpen) {
+			return false
+		}
+	}
+	if i.hi != nil {
+		if c := x.Cmp(i.hi); c > 0 || (c == 0 && i.hiOpen) {
+			return false
+		}
+	}
+	return true
+}
+
+// closed returns the integers of i as a closed interval.
+func (i interval) closed() interval {
+	one := big.NewRat(1, 1)
+	if i.lo != nil {
+		lo := ratCeil(i.lo)
+		if i.loOpen && lo.Cmp(i.lo) == 0 {
+			lo.Add(lo, one)
+		}
+		i.lo, i.loOpen = lo, false
+	}
+	if i.hi != nil {
+		hi := ratFloor(i.hi)
+		if i.hiOpen && hi.Cmp(i.hi) == 0 {
+			hi.Sub(hi, one)
+		}
+		i.hi, i.hiOpen = hi, false
+	}
+	return i
+}
+
+func ratFloor(x *big.Rat) *big.Rat {
+	// Euclidean division rounds down for a positive denominator.
+	return new(big.Rat).SetInt(new(big.Int).Div(x.Num(), x.Denom()))
+}
+
+func ratCeil(x *big.Rat) *big.Rat {
+	return new(big.Rat).Neg(ratFloor(new(big.Rat).Neg(x)))
+}
+
+// orderedTest turns a comparison with the values in match, which equals
+// matches, into the interval of values that pass it, or that fail it for
+// notEquals.
+func orderedTest(c *QueryCondition, match interval) (interval, bool, error) {
+	switch c.Operator {
+	case OperatorEq:
+		return match, false, nil
+	case OperatorNeq:
+		return match, true, nil
+	case OperatorGt:
+		return interval{lo: match.hi, loOpen: !match.hiOpen}, false, nil
+	case OperatorGte:
+		return interval{lo: match.lo, loOpen: match.loOpen}, false, nil
+	case OperatorLT:
+		return interval{hi: match.lo, hiOpen: !match.loOpen}, false, nil
+	case OperatorLte:
+		return interval{hi: match.hi, hiOpen: match.hiOpen}, false, nil
+	}
+	return interval{}, false, errors.New("invalid operator: " + c.Operator.ToStr() + " for field: " + c.Field)
+}
+
+// solveOrdered returns the smallest value, or one close to it, that is in
+// every allowed interval and in no excluded one. With integer set, only
+// integers are considered.
+func solveOrdered(allowed, excluded []interval, integer bool) (*big.Rat, bool) {
+	var within interval
+	for _, i := range allowed {
+		if i.lo != nil && (within.lo == nil || i.lo.Cmp(within.lo) > 0 || (i.lo.Cmp(within.lo) == 0 && i.loOpen)) {
+			within.lo, within.loOpen = i.lo, i.loOpen
+		}
+		if i.hi != nil && (within.hi == nil || i.hi.Cmp(within.hi) < 0 || (i.hi.Cmp(within.hi) == 0 && i.hiOpen)) {
+			within.hi, within.hiOpen = i.hi, i.hiOpen
+		}
+	}
+	if integer {
+		within = within.closed()
+		for n, i := range excluded {
+			excluded[n] = i.closed()
+		}
+	}
+
+	var bounds []*big.Rat
+	for _, i := range append([]interval{within}, excluded...) {
+		for _, b := range []*big.Rat{i.lo, i.hi} {
+			if b != nil {
+				bounds = append(bounds, b)
+			}
+		}
+	}
+	// above returns a value above x, with no bound between them.
+	above := func(x *big.Rat) *big.Rat {
+		if integer {
+			return new(big.Rat).Add(x, big.NewRat(1, 1))
+		}
+		var next *big.Rat
+		for _, b := range bounds {
+			if b.Cmp(x) > 0 && (next == nil || b.Cmp(next) < 0) {
+				next = b
+			}
+		}
+		if next == nil {
+			return new(big.Rat).Add(x, big.NewRat(1, 1))
+		}
+		mid := new(big.Rat).Add(x, next)
+		return mid.Quo(mid, big.NewRat(2, 1))
+	}
+
+	var x *big.Rat
+	switch {
+	case within.lo != nil && within.loOpen:
+		x = above(within.lo)
+	case within.lo != nil:
+		x = within.lo
+	default:
+		x = new(big.Rat)
+		for _, b := range bounds {
+			if b.Cmp(x) < 0 {
+				x = b
+			}
+		}
+		x = new(big.Rat).Sub(x, big.NewRat(1, 1))
+	}
+	for moved := true; moved; {
+		moved = false
+		for _, i := range excluded {
+			if !i.contains(x) {
+				continue
+			}
+			if i.hiOpen {
+				x = i.hi
+			} else {
+				x = above(i.hi)
+			}
+			moved = true
+		}
+	}
+	return x, within.contains(x)
+}
+
+func solveNumbers(tests []valueTest, integer bool) (any, bool, error) {
+	var allowed, excluded []interval
+	for _, t := range tests {
+		c := t.condition
+		v, ok := new(big.Rat).SetString(c.Value)
+		if !ok || t.negated {
+			return nil, false, errors.New("invalid value: " + c.Value + " for field: " + c.Field)
+		}
+		i, exclude, err := orderedTest(c, interval{lo: v, hi: v})
+		if err != nil {
+			return nil, false, err
+		}
+		if exclude {
+			excluded = append(excluded, i)
+		} else {
+			allowed = append(allowed, i)
+		}
+	}
+	x, ok := solveOrdered(allowed, excluded, integer)
+	if !ok {
+		return nil, false, nil
+	}
+	if x.IsInt() && x.Num().IsInt64() {
+		return x.Num().Int64(), true, nil
+	}
+	if f, exact := x.Float64(); exact {
+		return f, true, nil
+	}
+	return x.RatString(), true, nil
+}
+
+// solveDates solves date conditions in nanoseconds. As in the evaluator, a
+// date compared with a date field matches the whole day.
+func (e *Evaluator) solveDates(tests []valueTest) (any, bool, error) {
+	var allowed, excluded []interval
+	for _, t := range tests {
+		c := t.condition
+		want, wantType, err := e.dateValue(c)
+		if err != nil {
+			return nil, false, err
+		}
+		if t.negated {
+			return nil, false, errors.New("invalid operator: " + c.Operator.ToStr() + " for field: " + c.Field)
+		}
+		match := interval{lo: timeNanos(want), hi: timeNanos(want)}
+		if e.conditionType(c, nil) == DTypeDate && wantType == DTypeDate {
+			match.hi, match.hiOpen = timeNanos(want.AddDate(0, 0, 1)), true
+		}
+		i, exclude, err := orderedTest(c, match)
+		if err != nil {
+			return nil, false, err
+		}
+		if exclude {
+			excluded = append(excluded, i)
+		} else {
+			allowed = append(allowed, i)
+		}
+	}
+	x, ok := solveOrdered(allowed, excluded, true)
+	if !ok {
+		return nil, false, nil
+	}
+	sec, nsec := new(big.Int).DivMod(x.Num(), big.NewInt(int64(time.Second)), new(big.Int))
+	return time.Unix(sec.Int64(), nsec.Int64()).UTC(), true, nil
+}
+
+// timeNanos returns t as nanoseconds since the Unix epoch.
+func timeNanos(t time.Time) *big.Rat {
+	ns := new(big.Int).Mul(big.NewInt(t.Unix()), big.NewInt(int64(time.Second)))
+	return new(big.Rat).SetInt(ns.Add(ns, big.NewInt(int64(t.Nanosecond()))))
+}
+
+func solveBool(tests []valueTest) (any, bool, error) {
+	for _, value := range []bool{true, false} {
+		ok := true
+		for _, t := range tests {
+			c := t.condition
+			want, err := strconv.ParseBool(c.Value)
+			if err != nil {
+				return nil, false, errors.New("invalid value: " + c.Value + " for field: " + c.Field)
+			}
+			switch {
+			case c.Operator == OperatorEq && !t.negated:
+				ok = ok && value == want
+			case c.Operator == OperatorNeq && !t.negated:
+				ok = ok && value != want
+			default:
+				return nil, false, errors.New("invalid operator: " + c.Operator.ToStr() + " for field: " + c.Field)
+			}
+		}
+		if ok {
+			return value, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// solveString returns a string that passes every test. Without an equals
+// test, it joins the longest startsWith value, the contains values and the
+// longest endsWith value with a separator that no negated test mentions, so
+// a negated test can only fail on one of those parts, which every passing
+// value holds.
+func solveString(tests []valueTest) (any, bool, error) {
+	for _, t := range tests {
+		c := t.condition
+		switch c.Operator {
+		case OperatorEq, OperatorNeq, OperatorCnt, OperatorSW, OperatorEw:
+		default:
+			return nil, false, errors.New("invalid operator: " + c.Operator.ToStr() + " for field: " + c.Field)
+		}
+	}
+	for _, t := range tests {
+		if t.condition.Operator == OperatorEq && !t.negated {
+			value := t.condition.Value
+			return value, passesAll(value, tests), nil
+		}
+	}
+
+	var prefix, suffix string
+	var contains, patterns []string
+	excluded := map[string]bool{}
+	for _, t := range tests {
+		value, lower := t.condition.Value, strings.ToLower(t.condition.Value)
+		switch {
+		case t.condition.Operator == OperatorNeq:
+			excluded[value] = true
+		case t.negated:
+			patterns = append(patterns, lower)
+		case t.condition.Operator == OperatorCnt:
+			contains = append(contains, value)
+		case t.condition.Operator == OperatorSW:
+			if strings.HasPrefix(lower, strings.ToLower(prefix)) {
+				prefix = value
+			} else if !strings.HasPrefix(strings.ToLower(prefix), lower) {
+				return nil, false, nil
+			}
+		case t.condition.Operator == OperatorEw:
+			if strings.HasSuffix(lower, strings.ToLower(suffix)) {
+				suffix = value
+			} else if !strings.HasSuffix(strings.ToLower(suffix), lower) {
+				return nil, false, nil
+			}
+		}
+	}
+
+	sep := separator(patterns)
+	for range len(excluded) + 1 {
+		value := strings.Join(append(append([]string{prefix}, contains...), suffix), sep)
+		if !excluded[value] {
+			return value, passesAll(value, tests), nil
+		}
+		prefix += sep
+	}
+	return nil, false, nil
+}
+
+// separator returns a character that is in none of patterns, whatever its
+// case.
+func separator(patterns []string) string {
+	for r := rune('_'); ; r++ {
+		if unicode.ToLower(r) != r || unicode.ToUpper(r) != r || !unicode.IsPrint(r) {
+			continue
+		}
+		used := false
+		for _, p := range patterns {
+			used = used || strings.ContainsRune(p, r)
+		}
+		if !used {
+			return string(r)
+		}
+	}
+}
+
+// passesAll reports whether value passes every string test, compared the way
+// the evaluator compares strings.
+func passesAll(value string, tests []valueTest) bool {
+	lower := strings.ToLower(value)
+	for _, t := range tests {
+		want := t.condition.Value
+		var ok bool
+		switch t.condition.Operator {
+		case OperatorEq:
+			ok = value == want
+		case OperatorNeq:
+			ok = value != want
+		case OperatorCnt:
+			ok = strings.Contains(lower, strings.ToLower(want))
+		case OperatorSW:
+			ok = strings.HasPrefix(lower, strings.ToLower(want))
+		case OperatorEw:
+			ok = strings.HasSuffix(lower, strings.ToLower(want))
+		}
+		if ok == t.negated {
+			return false
+		}
+	}
+	return true
+}
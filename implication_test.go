@@ -0,0 +1,182 @@
+package ntql
+
+import (
+	"testing"
+	"time"
+)
+
+func implicationEvaluator() *Evaluator {
+	e := NewEvaluator(DefaultSchema())
+	e.Clock = Clock{Now: func() time.Time { return time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC) }}
+	return e
+}
+
+func priority(op Operator, value string) *QueryCondition {
+	return &QueryCondition{Field: "priority", Operator: op, Value: value}
+}
+
+func TestImplies(t *testing.T) {
+	parse := func(query string) QueryExpr {
+		expr, err := parseQuery(query)
+		if err != nil {
+			t.Fatalf("parsing %s failed: %v", query, err)
+		}
+		return expr
+	}
+	cases := []struct {
+		a, b     QueryExpr
+		expected bool
+	}{
+		{parse(`title.startswith("ab")`), parse(`name.contains("B")`), true},
+		{parse(`title.contains("b")`), parse(`title.startswith("ab")`), false},
+		{NewQueryAnd(parse(`title.startswith("ab")`), &QueryCondition{Field: "title", Operator: OperatorEw, Value: "c"}), parse(`title.contains("abc")`), false},
+		{parse(`title.startswith("ab") AND !title.contains("b")`), parse(`tag.eq(z)`), true},
+		{parse(`title.eq("a")`), parse(`title.eq("a") OR tag.eq(x)`), true},
+		{parse(`title.eq("a") OR tag.eq(x)`), parse(`title.eq("a")`), false},
+		{parse(`project.eq("x")`), parse(`!project.eq("y")`), true},
+		{parse(`!project.eq("y")`), parse(`project.eq("x")`), false},
+		// A subject without a value matches neither a condition nor its
+		// negation.
+		{parse(`title.eq("a")`), parse(`project.eq("x") OR !project.eq("x")`), false},
+		// A task can have several tags, and none.
+		{parse(`tag.eq(x)`), parse(`!tag.eq(y)`), false},
+		{parse(`tag.eq(x) AND tag.eq(y)`), parse(`tag.eq(y)`), true},
+		{parse(`tag.eq(x) AND !tag.eq(y)`), parse(`!tag.eq(y) AND !tag.eq(z)`), false},
+		// A task without tags matches neither.
+		{parse(`title.eq("a")`), parse(`tag.eq(x) OR !tag.eq(x)`), false},
+		{parse(`due.after(2024-01-10)`), parse(`due.after(2024-01-01)`), true},
+		{parse(`due.after(2024-01-01)`), parse(`due.after(2024-01-10)`), false},
+		{parse(`due.equals(2024-01-01)`), parse(`due.after(2023-12-31) AND due.before(2024-01-02)`), true},
+		{parse(`due.after(2023-12-31) AND due.before(2024-01-02)`), parse(`due.equals(2024-01-01)`), true},
+		{parse(`due.after(2023-12-31) AND due.before(2024-01-03)`), parse(`due.equals(2024-01-01)`), false},
+		{parse(`due.after(today)`), parse(`due.after(yesterday)`), true},
+		{parse(`due.before(2024-03-16)`), parse(`due.before(tomorrow)`), true},
+		{priority(OperatorGt, "5"), priority(OperatorGte, "6"), true},
+		// Like the evaluator, a value with a decimal point compares as a float.
+		{priority(OperatorGte, "5.5"), priority(OperatorGte, "6"), false},
+		{priority(OperatorGt, "5"), priority(OperatorGt, "6"), false},
+		{NewQueryAnd(priority(OperatorGt, "5"), priority(OperatorLT, "3")), priority(OperatorEq, "4"), true},
+		{NewQueryAnd(priority(OperatorGte, "1"), priority(OperatorLte, "3")), NewQueryOr(priority(OperatorEq, "1"), NewQueryOr(priority(OperatorEq, "2"), priority(OperatorEq, "3"))), true},
+		{NewQueryAnd(priority(OperatorGte, "1"), NewQueryAnd(priority(OperatorLte, "3"), priority(OperatorNeq, "2"))), NewQueryOr(priority(OperatorEq, "1"), priority(OperatorEq, "2")), false},
+	}
+	e := implicationEvaluator()
+	for _, tc := range cases {
+		implies, record, err := e.Implies(tc.a, tc.b)
+		if err != nil {
+			t.Fatalf("Implies(%s, %s) failed: %v", tc.a, tc.b, err)
+		}
+		if implies != tc.expected {
+			t.Errorf("Implies(%s, %s) = %v, expected %v", tc.a, tc.b, implies, tc.expected)
+			continue
+		}
+		if implies {
+			if record != nil {
+				t.Errorf("expected no counterexample for Implies(%s, %s), got %v", tc.a, tc.b, record)
+			}
+			continue
+		}
+		matchA, errA := e.Evaluate(tc.a, record)
+		matchB, errB := e.Evaluate(tc.b, record)
+		if errA != nil || errB != nil || !matchA || matchB {
+			t.Errorf("expected counterexample %v to match %s but not %s, got %v, %v: %v, %v", record, tc.a, tc.b, matchA, matchB, errA, errB)
+		}
+	}
+}
+
+func TestImpliesMissingRelated(t *testing.T) {
+	records := []MapRecord{
+		{"title": "x"},
+		{"title": "x", "tag": []any{}},
+		{"title": "x", "tag": []any{"a"}},
+	}
+	tag := func(op Operator, value string) *QueryCondition {
+		return &QueryCondition{Field: "tag", Operator: op, Value: value}
+	}
+	title := &QueryCondition{Field: "title", Operator: OperatorEq, Value: "x"}
+	// tag.notEquals(b) OR NOT tag.equals(a)
+	b := NewQueryOr(tag(OperatorNeq, "b"), NewQueryNot(tag(OperatorEq, "a")))
+	cases := []struct {
+		a, b     QueryExpr
+		expected bool
+	}{
+		{title, b, false},
+		{NewQueryAnd(title, tag(OperatorEq, "a")), b, true},
+		{NewQueryAnd(title, NewQueryNot(tag(OperatorEq, "a"))), NewQueryOr(NewQueryNot(tag(OperatorEq, "a")), tag(OperatorEq, "b")), true},
+	}
+	e := implicationEvaluator()
+	for _, tc := range cases {
+		a, b := tc.a, tc.b
+		implies, record, err := e.Implies(a, b)
+		if err != nil {
+			t.Fatalf("Implies(%s, %s) failed: %v", a, b, err)
+		}
+		if implies != tc.expected {
+			t.Fatalf("Implies(%s, %s) = %v, expected %v", a, b, implies, tc.expected)
+		}
+		if !implies {
+			records = append(records, record)
+		}
+		for _, r := range records {
+			matchA, errA := e.Evaluate(a, r)
+			matchB, errB := e.Evaluate(b, r)
+			if errA != nil || errB != nil {
+				t.Fatalf("Evaluate() failed for %v: %v, %v", r, errA, errB)
+			}
+			if implies && matchA && !matchB {
+				t.Fatalf("expected %v to match %s as it matches %s", r, b, a)
+			}
+		}
+		if !implies {
+			matchA, _ := e.Evaluate(a, record)
+			matchB, _ := e.Evaluate(b, record)
+			if !matchA || matchB {
+				t.Fatalf("expected counterexample %v to match %s but not %s", record, a, b)
+			}
+			if _, ok := record["tag"]; ok {
+				t.Fatalf("expected counterexample %v to have no tag", record)
+			}
+		}
+	}
+}
+
+func TestEquivalent(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		expected bool
+	}{
+		{`title.contains("a" OR "b")`, `title.contains("b") OR name.contains("a")`, true},
+		{`!(title.eq("a") AND tag.eq(x))`, `!tag.eq(x) OR !title.eq("a")`, true},
+		{`title.startswith("ab")`, `title.startswith("a") AND title.startswith("ab")`, true},
+		{`title.contains("a" AND "b")`, `title.contains("a" OR "b")`, false},
+		{`due.before(2024-01-01) OR due.after(2024-01-01)`, `!due.equals(2024-01-01)`, true},
+		{`title.eq("a")`, `title.eq("A")`, false},
+	}
+	e := implicationEvaluator()
+	for _, tc := range cases {
+		a, _ := parseQuery(tc.a)
+		b, _ := parseQuery(tc.b)
+		equivalent, record, err := e.Equivalent(a, b)
+		if err != nil {
+			t.Fatalf("Equivalent(%s, %s) failed: %v", tc.a, tc.b, err)
+		}
+		if equivalent != tc.expected {
+			t.Errorf("Equivalent(%s, %s) = %v, expected %v", tc.a, tc.b, equivalent, tc.expected)
+			continue
+		}
+		if !equivalent {
+			matchA, _ := e.Evaluate(a, record)
+			matchB, _ := e.Evaluate(b, record)
+			if matchA == matchB {
+				t.Errorf("expected counterexample %v to match one of %s and %s", record, tc.a, tc.b)
+			}
+		}
+	}
+
+	xor := &QueryBinaryOp{Left: priority(OperatorEq, "1"), Right: priority(OperatorEq, "2"), Operator: OperatorXor}
+	if _, _, err := Implies(xor, priority(OperatorEq, "1")); err == nil {
+		t.Fatalf("expected an error for XOR")
+	}
+	if _, _, err := Implies(nil, priority(OperatorEq, "1")); err == nil {
+		t.Fatalf("expected an error for an empty query")
+	}
+}
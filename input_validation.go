@@ -4,7 +4,8 @@ import "regexp"
 
 // Regexps for various token types
 var alphaNumRegexp = regexp.MustCompile("^[a-zA-Z0-9]$")
-var numRegexp = regexp.MustCompile("^[0-9]$")
+var numRegexp = regexp.MustCompile("^-?[0-9]+$")
+var floatRegexp = regexp.MustCompile(`^-?[0-9]+\.[0-9]+$`)
 var dateRegexp = regexp.MustCompile("^[0-9]{4}-[0-9]{2}-[0-9]{2}$")
 var dateTimeRegexp = regexp.MustCompile("^[0-9]{4}-[0-9]{2}-[0-9]{2}T[0-9]{2}:[0-9]{2}:[0-9]{2}$")
 var stringRegexp = regexp.MustCompile("^\".*\"$")
@@ -12,3 +13,13 @@ var stringRegexp = regexp.MustCompile("^\".*\"$")
 func isSymbol(c byte) bool {
 	return c == '!' || c == '(' || c == ')' || c == '.'
 }
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// isWhitespace reports whether c separates lexemes. Queries may span several
+// lines, as they do in an editor.
+func isWhitespace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
@@ -1,5 +1,7 @@
 package ntql
 
+import "slices"
+
 type Lexer struct {
 	Tokens            []Token
 	Scanner           *Scanner
@@ -7,12 +9,22 @@ type Lexer struct {
 	ExpectedTokens    []TokenType
 	lastTokenVerb     bool
 	ExpectedDataTypes []DType
+	schema            *Schema
+	// subject is the subject of the current function call, or nil if it is
+	// not in the schema.
+	subject *Subject
 }
 
 var connectorTypes = []TokenType{TokenAnd, TokenOr}
 
+// NewLexer creates a lexer that checks subjects against the default schema.
 func NewLexer(s string) *Lexer {
-	return &Lexer{Tokens: []Token{}, Scanner: NewScanner(s), InnerDepth: 0, ExpectedTokens: []TokenType{TokenLParen, TokenBang, TokenSubject}}
+	return NewLexerWithSchema(DefaultSchema(), s)
+}
+
+// NewLexerWithSchema creates a lexer that checks subjects against schema.
+func NewLexerWithSchema(schema *Schema, s string) *Lexer {
+	return &Lexer{Tokens: []Token{}, Scanner: NewScanner(s), InnerDepth: 0, ExpectedTokens: []TokenType{TokenLParen, TokenBang, TokenSubject}, schema: schema}
 }
 
 // Lex takes a string and returns a slice of tokens
@@ -118,6 +130,14 @@ func (t *Lexer) ScanToken() error {
 			if res {
 				return nil
 			}
+		case TokenRelativeDate:
+			res, err := t.matchRelativeDate(lexeme)
+			if err != nil {
+				return err
+			}
+			if res {
+				return nil
+			}
 		case TokenString:
 			res, err := t.matchString(lexeme)
 			if err != nil {
@@ -134,6 +154,14 @@ func (t *Lexer) ScanToken() error {
 			if res {
 				return nil
 			}
+		case TokenFloat:
+			res, err := t.matchFloat(lexeme)
+			if err != nil {
+				return err
+			}
+			if res {
+				return nil
+			}
 		case TokenVerb:
 			res, err := t.matchVerb(lexeme)
 			if err != nil {
@@ -151,10 +179,38 @@ func (t *Lexer) ScanToken() error {
 				return nil
 			}
 		default:
-			return ErrInvalidToken{Expected: t.ExpectedTokens, Position: t.Scanner.Pos, Lexeme: lexeme}
+			return t.invalidToken(lexeme)
 		}
 	}
-	return nil
+	return t.invalidToken(lexeme)
+}
+
+// invalidToken reports that lexeme is none of the expected tokens.
+func (t *Lexer) invalidToken(lexeme Lexeme) error {
+	start, end := t.Scanner.LastSpan()
+	err := ErrInvalidToken{Expected: append([]TokenType{}, t.ExpectedTokens...), Position: start, End: end, Lexeme: lexeme}
+	if t.insideMethodCall() && t.subject != nil {
+		t.explainValue(&err)
+	}
+	return err
+}
+
+// explainValue adds what the lexeme of err, an argument of the current
+// function call, may have been meant to be: a type mismatch and the subjects
+// that accept its type, or the relative dates it looks like.
+func (t *Lexer) explainValue(err *ErrInvalidToken) {
+	kind, ok := valueTokenType(err.Lexeme)
+	if !ok {
+		err.Suggestions = closestRelativeDates(string(err.Lexeme), t.subject.ValidTypes)
+		return
+	}
+	if slices.Contains(valueTokenTypes(t.subject.ValidTypes), kind) {
+		// The value is fine but out of place, such as a second argument.
+		return
+	}
+	got := tokenDType(kind)
+	err.Mismatch = &TypeMismatch{Subject: t.subject.Name, Accepted: t.subject.ValidTypes, Got: got}
+	err.Suggestions = t.schema.closestSubjects(t.subject.Name, got)
 }
 
 func (t *Lexer) GetPosition() int {
@@ -170,7 +226,8 @@ func (l *Lexer) insideMethodCall() bool {
 }
 
 func (t *Lexer) appendToken(kind TokenType, lexeme Lexeme) {
-	t.Tokens = append(t.Tokens, createToken(string(lexeme), kind, t.GetPosition()))
+	start, end := t.Scanner.LastSpan()
+	t.Tokens = append(t.Tokens, createToken(string(lexeme), kind, start, end))
 }
 
 func (t *Lexer) atEnd() bool {
@@ -183,13 +240,76 @@ func (t *Lexer) lastToken() (Token, error) {
 	return t.Tokens[len(t.Tokens)-1], nil
 }
 
+// valueTokenTypes returns the value tokens accepted for arguments of the given
+// data types. Decimal subjects also accept whole numbers.
+func valueTokenTypes(dtypes []DType) []TokenType {
+	tokens := []TokenType{}
+	for _, dtype := range dtypes {
+		switch dtype {
+		case DTypeString:
+			tokens = append(tokens, TokenString)
+		case DTypeInt:
+			tokens = append(tokens, TokenInt)
+		case DTypeFloat:
+			tokens = append(tokens, TokenInt, TokenFloat)
+		case DTypeDateTime:
+			tokens = append(tokens, TokenDateTime, TokenRelativeDate)
+		case DTypeDate:
+			tokens = append(tokens, TokenDate, TokenRelativeDate)
+		case DTypeTag:
+			tokens = append(tokens, TokenTag)
+		}
+	}
+	return tokens
+}
+
+// valueTokenType returns the kind of value lexeme is, ignoring tags, which
+// any word can be. It reports false if lexeme is not a value.
+func valueTokenType(lexeme Lexeme) (TokenType, bool) {
+	s := string(lexeme)
+	switch {
+	case stringRegexp.MatchString(s):
+		return TokenString, true
+	case dateTimeRegexp.MatchString(s):
+		return TokenDateTime, true
+	case dateRegexp.MatchString(s):
+		return TokenDate, true
+	case isRelativeDate(s):
+		return TokenRelativeDate, true
+	case numRegexp.MatchString(s):
+		return TokenInt, true
+	case floatRegexp.MatchString(s):
+		return TokenFloat, true
+	}
+	return TokenInvalid, false
+}
+
+// tokenDType returns the data type of a value token.
+func tokenDType(kind TokenType) DType {
+	switch kind {
+	case TokenInt:
+		return DTypeInt
+	case TokenFloat:
+		return DTypeFloat
+	case TokenDate, TokenRelativeDate:
+		return DTypeDate
+	case TokenDateTime:
+		return DTypeDateTime
+	case TokenTag:
+		return DTypeTag
+	}
+	return DTypeString
+}
+
 func (t *Lexer) matchSubject(lexeme Lexeme) (bool, error) {
 	t.appendToken(TokenSubject, lexeme)
 	t.ExpectedTokens = []TokenType{TokenDot}
-	subj, err := getSubject(string(lexeme))
+	subj, err := t.schema.Subject(string(lexeme))
+	t.subject = subj
 	if err != nil {
 		t.ExpectedDataTypes = []DType{}
-		return false, ErrInvalidSubject{Position: t.Scanner.Pos, Lexeme: lexeme}
+		start, end := t.Scanner.LastSpan()
+		return false, ErrInvalidSubject{Position: start, End: end, Lexeme: lexeme, Suggestions: t.schema.closestSubjects(string(lexeme))}
 	}
 
 	t.ExpectedDataTypes = subj.ValidTypes
@@ -198,6 +318,10 @@ func (t *Lexer) matchSubject(lexeme Lexeme) (bool, error) {
 }
 
 func (t *Lexer) matchTag(lexeme Lexeme) (bool, error) {
+	if stringRegexp.MatchString(string(lexeme)) {
+		// A quoted tag is the name between the quotes, as for strings.
+		lexeme = lexeme[1 : len(lexeme)-1]
+	}
 	t.appendToken(TokenTag, lexeme)
 	t.ExpectedTokens = []TokenType{TokenAnd, TokenOr, TokenRParen}
 	return true, nil
@@ -234,20 +358,7 @@ func (t *Lexer) matchLParen(lexeme Lexeme) (bool, error) {
 		if t.InnerDepth != 0 || prev.Kind == TokenVerb { // if we are in a method
 			t.InnerDepth++
 			t.ExpectedTokens = []TokenType{TokenLParen, TokenBang}
-			for _, dtype := range t.ExpectedDataTypes {
-				switch dtype {
-				case DTypeString:
-					t.ExpectedTokens = append(t.ExpectedTokens, TokenString)
-				case DTypeInt:
-					t.ExpectedTokens = append(t.ExpectedTokens, TokenInt)
-				case DTypeDateTime:
-					t.ExpectedTokens = append(t.ExpectedTokens, TokenDateTime)
-				case DTypeDate:
-					t.ExpectedTokens = append(t.ExpectedTokens, TokenDate)
-				case DTypeTag:
-					t.ExpectedTokens = append(t.ExpectedTokens, TokenTag)
-				}
-			}
+			t.ExpectedTokens = append(t.ExpectedTokens, valueTokenTypes(t.ExpectedDataTypes)...)
 			t.ExpectedTokens = append(t.ExpectedTokens, TokenBang)
 		}
 		t.appendToken(TokenLParen, lexeme)
@@ -273,20 +384,7 @@ func (t *Lexer) matchAnd(lexeme Lexeme) (bool, error) {
 		t.appendToken(TokenAnd, lexeme)
 		if t.InnerDepth != 0 { // if we are in a method
 			t.ExpectedTokens = []TokenType{TokenLParen, TokenBang}
-			for _, dtype := range t.ExpectedDataTypes {
-				switch dtype {
-				case DTypeString:
-					t.ExpectedTokens = append(t.ExpectedTokens, TokenString)
-				case DTypeInt:
-					t.ExpectedTokens = append(t.ExpectedTokens, TokenInt)
-				case DTypeDateTime:
-					t.ExpectedTokens = append(t.ExpectedTokens, TokenDateTime)
-				case DTypeDate:
-					t.ExpectedTokens = append(t.ExpectedTokens, TokenDate)
-				case DTypeTag:
-					t.ExpectedTokens = append(t.ExpectedTokens, TokenTag)
-				}
-			}
+			t.ExpectedTokens = append(t.ExpectedTokens, valueTokenTypes(t.ExpectedDataTypes)...)
 		} else {
 			t.ExpectedTokens = []TokenType{TokenLParen, TokenBang, TokenSubject}
 		}
@@ -300,20 +398,7 @@ func (t *Lexer) matchOr(lexeme Lexeme) (bool, error) {
 		t.appendToken(TokenOr, lexeme)
 		if t.InnerDepth != 0 { // if we are in a method
 			t.ExpectedTokens = []TokenType{TokenLParen, TokenBang}
-			for _, dtype := range t.ExpectedDataTypes {
-				switch dtype {
-				case DTypeString:
-					t.ExpectedTokens = append(t.ExpectedTokens, TokenString)
-				case DTypeInt:
-					t.ExpectedTokens = append(t.ExpectedTokens, TokenInt)
-				case DTypeDateTime:
-					t.ExpectedTokens = append(t.ExpectedTokens, TokenDateTime)
-				case DTypeDate:
-					t.ExpectedTokens = append(t.ExpectedTokens, TokenDate)
-				case DTypeTag:
-					t.ExpectedTokens = append(t.ExpectedTokens, TokenTag)
-				}
-			}
+			t.ExpectedTokens = append(t.ExpectedTokens, valueTokenTypes(t.ExpectedDataTypes)...)
 		} else {
 			t.ExpectedTokens = []TokenType{TokenLParen, TokenBang, TokenSubject}
 		}
@@ -340,6 +425,15 @@ func (t *Lexer) matchDate(lexeme Lexeme) (bool, error) {
 	return false, nil
 }
 
+func (t *Lexer) matchRelativeDate(lexeme Lexeme) (bool, error) {
+	if isRelativeDate(string(lexeme)) {
+		t.appendToken(TokenRelativeDate, lexeme)
+		t.ExpectedTokens = append(connectorTypes, TokenRParen)
+		return true, nil
+	}
+	return false, nil
+}
+
 func (t *Lexer) matchDateTime(lexeme Lexeme) (bool, error) {
 	if dateTimeRegexp.MatchString(string(lexeme)) {
 		t.appendToken(TokenDateTime, lexeme)
@@ -358,6 +452,15 @@ func (t *Lexer) matchString(lexeme Lexeme) (bool, error) {
 	return false, nil
 }
 
+func (t *Lexer) matchFloat(lexeme Lexeme) (bool, error) {
+	if floatRegexp.MatchString(string(lexeme)) {
+		t.appendToken(TokenFloat, lexeme)
+		t.ExpectedTokens = append(connectorTypes, TokenRParen)
+		return true, nil
+	}
+	return false, nil
+}
+
 func (t *Lexer) matchDigit(lexeme Lexeme) (bool, error) {
 	if numRegexp.MatchString(string(lexeme)) {
 		t.appendToken(TokenInt, lexeme)
@@ -54,3 +54,56 @@ func TestLexer(t *testing.T) {
 		}
 	}
 }
+
+const numericSchemaYAML = `
+subjects:
+  - name: priority
+    aliases: []
+    validVerbs:
+      - name: greaterthanorequal
+        aliases: [gte]
+    validTypes: [int]
+  - name: estimate
+    aliases: []
+    validVerbs:
+      - name: lessthan
+        aliases: [lt]
+    validTypes: [decimal]
+fieldTypes:
+  dateTypes: [due_date]
+  boolTypes: [completed]
+  numericTypes: [priority, estimate]
+  stringTypes: [title]
+`
+
+func TestLexerNumbers(t *testing.T) {
+	schema, err := NewSchemaFromYAML([]byte(numericSchemaYAML))
+	if err != nil {
+		t.Fatalf("NewSchemaFromYAML() failed: %v", err)
+	}
+	tokens, err := NewLexerWithSchema(schema, "priority.gte(10 OR -3) AND estimate.lt(2.5 OR 4)").Lex()
+	if err != nil {
+		t.Fatalf("Lex() failed: %v", err)
+	}
+
+	expected := []Token{
+		{Kind: TokenInt, Literal: "10"},
+		{Kind: TokenInt, Literal: "-3"},
+		{Kind: TokenFloat, Literal: "2.5"},
+		{Kind: TokenInt, Literal: "4"},
+	}
+	values := []Token{}
+	for _, tok := range tokens {
+		if tok.Kind == TokenInt || tok.Kind == TokenFloat {
+			values = append(values, tok)
+		}
+	}
+	if len(values) != len(expected) {
+		t.Fatalf("Expected %d number tokens, got %s", len(expected), values)
+	}
+	for i, tok := range values {
+		if tok.Kind != expected[i].Kind || tok.Literal != expected[i].Literal {
+			t.Errorf("Expected token %s, got %s", expected[i], tok)
+		}
+	}
+}
@@ -0,0 +1,378 @@
+package ntql
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LiteralKind is the type of a value in a query condition.
+type LiteralKind int
+
+const (
+	// LiteralInvalid is the kind of the zero Literal, a value of unknown type.
+	LiteralInvalid LiteralKind = iota
+	LiteralString
+	LiteralInt
+	LiteralDecimal
+	LiteralDate
+	LiteralDateTime
+	LiteralRelativeDate
+	LiteralBool
+	LiteralTag
+	LiteralList
+)
+
+var literalKindNames = map[LiteralKind]string{
+	LiteralString:       "string",
+	LiteralInt:          "int",
+	LiteralDecimal:      "decimal",
+	LiteralDate:         "date",
+	LiteralDateTime:     "dateTime",
+	LiteralRelativeDate: "relativeDate",
+	LiteralBool:         "bool",
+	LiteralTag:          "tag",
+	LiteralList:         "list",
+}
+
+// String returns the name used for the kind in JSON.
+func (k LiteralKind) String() string {
+	if name, ok := literalKindNames[k]; ok {
+		return name
+	}
+	return "invalid"
+}
+
+// parseLiteralKind returns the kind named s, as written by String.
+func parseLiteralKind(s string) (LiteralKind, bool) {
+	for kind, name := range literalKindNames {
+		if name == s {
+			return kind, true
+		}
+	}
+	return LiteralInvalid, false
+}
+
+// Literal is a typed value in a query condition, such as the int 42 or the
+// date 2024-01-08. It keeps the text it was written as, so that "007" stays
+// "007". The zero Literal has kind LiteralInvalid.
+type Literal struct {
+	kind LiteralKind
+	text string
+	// lexed is the kind an unparsed literal was lexed as.
+	lexed LiteralKind
+	n     int64
+	f     float64
+	b     bool
+	t     time.Time
+	items []Literal
+}
+
+func NewStringLiteral(s string) Literal {
+	return Literal{kind: LiteralString, text: s}
+}
+
+// NewTagLiteral returns a reference to a tag by name, or by id if the name is
+// a number.
+func NewTagLiteral(name string) Literal {
+	return Literal{kind: LiteralTag, text: name}
+}
+
+func NewIntLiteral(n int64) Literal {
+	return Literal{kind: LiteralInt, text: strconv.FormatInt(n, 10), n: n, f: float64(n)}
+}
+
+// NewDecimalLiteral returns a decimal value, written with a decimal point even
+// if it is whole. f must be finite.
+func NewDecimalLiteral(f float64) Literal {
+	text := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.Contains(text, ".") {
+		text += ".0"
+	}
+	return Literal{kind: LiteralDecimal, text: text, f: f}
+}
+
+// NewDateLiteral returns the day of t, as midnight UTC.
+func NewDateLiteral(t time.Time) Literal {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return Literal{kind: LiteralDate, text: day.Format(time.DateOnly), t: day}
+}
+
+// NewDateTimeLiteral returns t in UTC, to the second.
+func NewDateTimeLiteral(t time.Time) Literal {
+	t = t.UTC().Truncate(time.Second)
+	return Literal{kind: LiteralDateTime, text: t.Format("2006-01-02T15:04:05"), t: t}
+}
+
+func NewBoolLiteral(b bool) Literal {
+	return Literal{kind: LiteralBool, text: strconv.FormatBool(b), b: b}
+}
+
+// unparsedLiteral records text that was lexed as a value of kind, but that
+// the kind cannot hold, such as an int that does not fit in 64 bits. It has
+// kind LiteralInvalid, so it is read as untyped text.
+func unparsedLiteral(kind LiteralKind, text string) Literal {
+	return Literal{text: text, lexed: kind}
+}
+
+// NewListLiteral returns a list of values. A condition on a list matches if it
+// matches any of the items; see QueryCondition.
+func NewListLiteral(items ...Literal) Literal {
+	texts := make([]string, len(items))
+	for i, item := range items {
+		texts[i] = item.String()
+	}
+	return Literal{kind: LiteralList, text: "[" + strings.Join(texts, ", ") + "]", items: items}
+}
+
+// ParseLiteral returns the literal of the given kind written as text in a
+// query. Relative dates are kept as written, since they are resolved against a
+// clock when the query is used. Lists cannot be parsed from text.
+func ParseLiteral(kind LiteralKind, text string) (Literal, error) {
+	invalid := errors.New("invalid value: " + text + " for type: " + kind.String())
+	switch kind {
+	case LiteralString:
+		return NewStringLiteral(text), nil
+	case LiteralTag:
+		return NewTagLiteral(text), nil
+	case LiteralInt:
+		if !numRegexp.MatchString(text) {
+			return Literal{}, invalid
+		}
+		n, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return Literal{}, invalid
+		}
+		return Literal{kind: kind, text: text, n: n, f: float64(n)}, nil
+	case LiteralDecimal:
+		if !floatRegexp.MatchString(text) && !numRegexp.MatchString(text) {
+			return Literal{}, invalid
+		}
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil || math.IsInf(f, 0) {
+			return Literal{}, invalid
+		}
+		return Literal{kind: kind, text: text, f: f}, nil
+	case LiteralDate:
+		t, err := time.Parse(time.DateOnly, text)
+		if err != nil {
+			return Literal{}, invalid
+		}
+		return Literal{kind: kind, text: text, t: t}, nil
+	case LiteralDateTime:
+		t, err := parseDateValue(text)
+		if err != nil || !strings.Contains(text, "T") {
+			return Literal{}, invalid
+		}
+		return Literal{kind: kind, text: text, t: t}, nil
+	case LiteralRelativeDate:
+		if !isRelativeDate(text) {
+			return Literal{}, invalid
+		}
+		return Literal{kind: kind, text: text}, nil
+	case LiteralBool:
+		if text != "true" && text != "false" {
+			return Literal{}, invalid
+		}
+		return NewBoolLiteral(text == "true"), nil
+	}
+	return Literal{}, errors.New("cannot parse a value of type: " + kind.String())
+}
+
+// inferLiteral returns the literal text reads as when its type is not known:
+// a date, date-time, relative date or number as the lexer would read it bare,
+// and a string otherwise. Date-times with a zone, such as
+// 2024-01-08T09:00:00Z, are read as date-times too.
+func inferLiteral(text string) Literal {
+	kind, ok := valueTokenType(Lexeme(text))
+	if !ok || kind == TokenString {
+		kind = TokenDateTime
+	}
+	if lit, err := ParseLiteral(literalKindOf(kind), text); err == nil {
+		return lit
+	}
+	return NewStringLiteral(text)
+}
+
+// Kind returns the type of the value.
+func (l Literal) Kind() LiteralKind {
+	return l.kind
+}
+
+// String returns the value as written in a query, without quotes or escapes.
+// Lists are written as their items in brackets.
+func (l Literal) String() string {
+	return l.text
+}
+
+// Int returns the value of an int literal, and 0 for other kinds.
+func (l Literal) Int() int64 {
+	return l.n
+}
+
+// Float returns the value of a decimal or int literal, and 0 for other kinds.
+func (l Literal) Float() float64 {
+	return l.f
+}
+
+// Bool returns the value of a bool literal, and false for other kinds.
+func (l Literal) Bool() bool {
+	return l.b
+}
+
+// Time returns the value of a date or date-time literal, and the zero time for
+// other kinds. Dates and date-times without a zone are in UTC.
+func (l Literal) Time() time.Time {
+	return l.t
+}
+
+// Items returns the items of a list literal, and nil for other kinds.
+func (l Literal) Items() []Literal {
+	return l.items
+}
+
+// literalKindOf returns the kind of literal a value token holds.
+func literalKindOf(kind TokenType) LiteralKind {
+	switch kind {
+	case TokenString:
+		return LiteralString
+	case TokenInt:
+		return LiteralInt
+	case TokenFloat:
+		return LiteralDecimal
+	case TokenDate:
+		return LiteralDate
+	case TokenDateTime:
+		return LiteralDateTime
+	case TokenRelativeDate:
+		return LiteralRelativeDate
+	case TokenBool:
+		return LiteralBool
+	case TokenTag:
+		return LiteralTag
+	}
+	return LiteralInvalid
+}
+
+// tokenType returns the value token the literal is written as.
+func (l Literal) tokenType() TokenType {
+	switch l.kind {
+	case LiteralInt:
+		return TokenInt
+	case LiteralDecimal:
+		return TokenFloat
+	case LiteralDate:
+		return TokenDate
+	case LiteralDateTime:
+		return TokenDateTime
+	case LiteralRelativeDate:
+		return TokenRelativeDate
+	case LiteralBool:
+		return TokenBool
+	case LiteralTag:
+		return TokenTag
+	}
+	return TokenString
+}
+
+type jsonLiteral struct {
+	Kind  string          `json:"kind"`
+	Value json.RawMessage `json:"value"`
+}
+
+// MarshalJSON encodes the literal as {"kind": "int", "value": 42}. Numbers and
+// bools are JSON numbers and bools, lists are arrays of literals, and other
+// kinds are strings.
+func (l Literal) MarshalJSON() ([]byte, error) {
+	var value any
+	switch l.kind {
+	case LiteralInvalid:
+		return nil, errors.New("cannot encode an invalid literal")
+	case LiteralInt, LiteralDecimal:
+		if math.IsNaN(l.f) || math.IsInf(l.f, 0) {
+			return nil, errors.New("cannot encode value: " + l.text)
+		}
+		text := l.text
+		if !json.Valid([]byte(text)) {
+			// Leading zeros, as in 007, are not valid in JSON.
+			text = NewIntLiteral(l.n).text
+			if l.kind == LiteralDecimal {
+				text = NewDecimalLiteral(l.f).text
+			}
+		}
+		value = json.Number(text)
+	case LiteralBool:
+		value = l.b
+	case LiteralList:
+		items := l.items
+		if items == nil {
+			items = []Literal{}
+		}
+		value = items
+	default:
+		value = l.text
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonLiteral{Kind: l.kind.String(), Value: data})
+}
+
+func (l *Literal) UnmarshalJSON(data []byte) error {
+	var node jsonLiteral
+	if err := decodeStrict(data, &node); err != nil {
+		return err
+	}
+	kind, ok := parseLiteralKind(node.Kind)
+	if !ok {
+		return fmt.Errorf("unknown value kind %q", node.Kind)
+	}
+	if node.Value == nil {
+		return errors.New("missing value of kind " + node.Kind)
+	}
+	var lit Literal
+	var err error
+	switch kind {
+	case LiteralInt, LiteralDecimal:
+		decoder := json.NewDecoder(bytes.NewReader(node.Value))
+		decoder.UseNumber()
+		var value any
+		if err := decoder.Decode(&value); err != nil {
+			return err
+		}
+		n, ok := value.(json.Number)
+		if !ok {
+			return fmt.Errorf("invalid %s value: %s", node.Kind, node.Value)
+		}
+		lit, err = ParseLiteral(kind, n.String())
+	case LiteralBool:
+		var b bool
+		if err := json.Unmarshal(node.Value, &b); err != nil {
+			return fmt.Errorf("invalid %s value: %s", node.Kind, node.Value)
+		}
+		lit = NewBoolLiteral(b)
+	case LiteralList:
+		var items []Literal
+		if err := json.Unmarshal(node.Value, &items); err != nil {
+			return fmt.Errorf("invalid %s value: %w", node.Kind, err)
+		}
+		lit = NewListLiteral(items...)
+	default:
+		var s string
+		if err := json.Unmarshal(node.Value, &s); err != nil {
+			return fmt.Errorf("invalid %s value: %s", node.Kind, node.Value)
+		}
+		lit, err = ParseLiteral(kind, s)
+	}
+	if err != nil {
+		return err
+	}
+	*l = lit
+	return nil
+}
@@ -0,0 +1,197 @@
+package ntql
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLiteral(t *testing.T) {
+	date := time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		kind LiteralKind
+		text string
+		want Literal
+	}{
+		{LiteralString, "hello", NewStringLiteral("hello")},
+		{LiteralTag, "school", NewTagLiteral("school")},
+		{LiteralInt, "-42", NewIntLiteral(-42)},
+		{LiteralDecimal, "2.5", NewDecimalLiteral(2.5)},
+		{LiteralDate, "2024-01-08", NewDateLiteral(date.Add(15 * time.Hour))},
+		{LiteralDateTime, "2024-01-08T09:30:00", NewDateTimeLiteral(date.Add(9*time.Hour + 30*time.Minute))},
+		{LiteralBool, "true", NewBoolLiteral(true)},
+	}
+	for _, tc := range cases {
+		lit, err := ParseLiteral(tc.kind, tc.text)
+		if err != nil {
+			t.Fatalf("ParseLiteral(%s, %s) failed: %v", tc.kind, tc.text, err)
+		}
+		if lit.Kind() != tc.want.Kind() || lit.String() != tc.want.String() || lit.Int() != tc.want.Int() ||
+			lit.Float() != tc.want.Float() || lit.Bool() != tc.want.Bool() || !lit.Time().Equal(tc.want.Time()) {
+			t.Errorf("ParseLiteral(%s, %s) = %#v, expected %#v", tc.kind, tc.text, lit, tc.want)
+		}
+	}
+
+	// The text is kept as written.
+	if lit, _ := ParseLiteral(LiteralInt, "007"); lit.String() != "007" || lit.Int() != 7 {
+		t.Fatalf("expected 007 to be kept, got %#v", lit)
+	}
+	if lit := NewDecimalLiteral(3); lit.String() != "3.0" {
+		t.Fatalf("expected a whole decimal to have a decimal point, got %s", lit)
+	}
+
+	invalid := []struct {
+		kind LiteralKind
+		text string
+	}{
+		{LiteralInt, "9223372036854775808"},
+		{LiteralInt, "1.5"},
+		{LiteralDecimal, "NaN"},
+		{LiteralDecimal, "1e400"},
+		{LiteralDate, "2024-13-01"},
+		{LiteralDateTime, "2024-01-08"},
+		{LiteralRelativeDate, "someday"},
+		{LiteralBool, "yes"},
+		{LiteralList, "[1, 2]"},
+	}
+	for _, tc := range invalid {
+		if lit, err := ParseLiteral(tc.kind, tc.text); err == nil {
+			t.Errorf("expected ParseLiteral(%s, %s) to fail, got %#v", tc.kind, tc.text, lit)
+		}
+	}
+}
+
+func TestLiteralJSON(t *testing.T) {
+	cases := []struct {
+		literal  Literal
+		expected string
+	}{
+		{NewStringLiteral("a"), `{"kind":"string","value":"a"}`},
+		{NewIntLiteral(42), `{"kind":"int","value":42}`},
+		{NewDecimalLiteral(2.5), `{"kind":"decimal","value":2.5}`},
+		{NewDateLiteral(time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC)), `{"kind":"date","value":"2024-01-08"}`},
+		{NewBoolLiteral(false), `{"kind":"bool","value":false}`},
+		{NewListLiteral(NewTagLiteral("a"), NewTagLiteral("b")), `{"kind":"list","value":[{"kind":"tag","value":"a"},{"kind":"tag","value":"b"}]}`},
+	}
+	for _, tc := range cases {
+		data, err := json.Marshal(tc.literal)
+		if err != nil {
+			t.Fatalf("json.Marshal(%s) failed: %v", tc.literal, err)
+		}
+		if string(data) != tc.expected {
+			t.Errorf("expected %s, got %s", tc.expected, data)
+		}
+		var decoded Literal
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatalf("json.Unmarshal(%s) failed: %v", data, err)
+		}
+		if decoded.Kind() != tc.literal.Kind() || decoded.String() != tc.literal.String() {
+			t.Errorf("expected %s to decode to %#v, got %#v", data, tc.literal, decoded)
+		}
+	}
+
+	// Leading zeros are dropped, since JSON does not allow them.
+	lit, _ := ParseLiteral(LiteralInt, "007")
+	if data, err := json.Marshal(lit); err != nil || string(data) != `{"kind":"int","value":7}` {
+		t.Fatalf("expected 007 to be encoded as 7, got %s: %v", data, err)
+	}
+
+	invalid := []string{
+		`{"kind":"int","value":"7"}`,
+		`{"kind":"int","value":1.5}`,
+		`{"kind":"date","value":"tomorrow"}`,
+		`{"kind":"color","value":"red"}`,
+		`{"kind":"string"}`,
+		`{"kind":"string","value":"a","extra":1}`,
+	}
+	for _, data := range invalid {
+		var lit Literal
+		if err := json.Unmarshal([]byte(data), &lit); err == nil {
+			t.Errorf("expected %s to be rejected, got %#v", data, lit)
+		}
+	}
+	if _, err := json.Marshal(Literal{}); err == nil {
+		t.Fatalf("expected an error for an invalid literal")
+	}
+}
+
+func TestConditionLiteral(t *testing.T) {
+	cases := []struct {
+		query string
+		kind  LiteralKind
+	}{
+		{`title.eq("42")`, LiteralString},
+		{`priority.eq(42)`, LiteralInt},
+		{`due.before(2024-01-08)`, LiteralDate},
+		{`due.before(2024-01-08T09:00:00)`, LiteralDateTime},
+		{`due.before(tomorrow)`, LiteralRelativeDate},
+		{`tag.eq(school)`, LiteralTag},
+	}
+	for _, tc := range cases {
+		expr, err := parseQuery(tc.query)
+		if err != nil {
+			t.Fatalf("parsing %s failed: %v", tc.query, err)
+		}
+		c := expr.(*QueryCondition)
+		if c.Literal.Kind() != tc.kind || c.TypedValue().Kind() != tc.kind {
+			t.Errorf("expected the value of %s to be a %s, got %s", tc.query, tc.kind, c.Literal.Kind())
+		}
+	}
+
+	// Conditions built from Value alone infer the type from the text, and so
+	// do conditions whose Value was changed after parsing.
+	c := &QueryCondition{Field: "priority", Operator: OperatorEq, Value: "2.5"}
+	if kind := c.TypedValue().Kind(); kind != LiteralDecimal {
+		t.Fatalf("expected 2.5 to be a decimal, got %s", kind)
+	}
+	c = NewTypedCondition("title", OperatorEq, NewStringLiteral("42"))
+	c.Value = "2024-01-08"
+	if kind := c.TypedValue().Kind(); kind != LiteralDate {
+		t.Fatalf("expected the changed value to be a date, got %s", kind)
+	}
+}
+
+func TestListCondition(t *testing.T) {
+	in := NewTypedCondition("priority", OperatorEq, NewListLiteral(NewIntLiteral(1), NewIntLiteral(2)))
+	notIn := NewTypedCondition("priority", OperatorNeq, NewListLiteral(NewIntLiteral(1), NewIntLiteral(2)))
+
+	sql, err := in.ToSQL()
+	if err != nil || sql != "(priority = 1 OR priority = 2)" {
+		t.Fatalf("unexpected SQL for a list: %s, %v", sql, err)
+	}
+	sql, err = notIn.ToSQL()
+	if err != nil || sql != "(priority != 1 AND priority != 2)" {
+		t.Fatalf("unexpected SQL for a notEquals list: %s, %v", sql, err)
+	}
+	sql, err = BuildSQLJoinQuery(in, JoinQueryOptions{})
+	if err != nil || !strings.Contains(sql, "t0.priority = 1 OR t0.priority = 2") {
+		t.Fatalf("unexpected join query for a list: %s, %v", sql, err)
+	}
+
+	for _, tc := range []struct {
+		expr     QueryExpr
+		priority int
+		expected bool
+	}{
+		{in, 2, true},
+		{in, 3, false},
+		{notIn, 2, false},
+		{notIn, 3, true},
+	} {
+		matched, err := Evaluate(tc.expr, MapRecord{"priority": tc.priority})
+		if err != nil || matched != tc.expected {
+			t.Errorf("Evaluate(%s, %d) = %v, %v, expected %v", tc.expr, tc.priority, matched, err, tc.expected)
+		}
+	}
+
+	formatted, err := Format(in)
+	if err != nil || formatted != "priority.equals(1 OR 2)" {
+		t.Fatalf("unexpected format for a list: %s, %v", formatted, err)
+	}
+
+	empty := NewTypedCondition("priority", OperatorEq, NewListLiteral())
+	if _, err := empty.ToSQL(); err == nil {
+		t.Fatalf("expected an error for an empty list")
+	}
+}
@@ -0,0 +1,172 @@
+package lsp
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// The subset of the Language Server Protocol types used by the server.
+
+type Position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+type TextDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+type TextDocumentItem struct {
+	URI        string `json:"uri"`
+	LanguageID string `json:"languageId"`
+	Version    int    `json:"version"`
+	Text       string `json:"text"`
+}
+
+type TextDocumentPositionParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Position     Position               `json:"position"`
+}
+
+type DidOpenTextDocumentParams struct {
+	TextDocument TextDocumentItem `json:"textDocument"`
+}
+
+type DidChangeTextDocumentParams struct {
+	TextDocument   TextDocumentIdentifier           `json:"textDocument"`
+	ContentChanges []TextDocumentContentChangeEvent `json:"contentChanges"`
+}
+
+// TextDocumentContentChangeEvent holds the full text of a document; the
+// server only supports full document sync.
+type TextDocumentContentChangeEvent struct {
+	Text string `json:"text"`
+}
+
+type DidCloseTextDocumentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+type SemanticTokensParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
+
+// DiagnosticSeverityError is the severity of every diagnostic the server
+// publishes.
+const DiagnosticSeverityError = 1
+
+type Diagnostic struct {
+	Range    Range  `json:"range"`
+	Severity int    `json:"severity"`
+	Code     string `json:"code"`
+	Source   string `json:"source"`
+	Message  string `json:"message"`
+}
+
+type PublishDiagnosticsParams struct {
+	URI         string       `json:"uri"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+// Completion item kinds from the LSP specification.
+const (
+	CompletionItemKindMethod  = 2
+	CompletionItemKindField   = 5
+	CompletionItemKindValue   = 12
+	CompletionItemKindKeyword = 14
+	CompletionItemKindSnippet = 15
+)
+
+type TextEdit struct {
+	Range   Range  `json:"range"`
+	NewText string `json:"newText"`
+}
+
+type CompletionItem struct {
+	Label    string    `json:"label"`
+	Kind     int       `json:"kind"`
+	Detail   string    `json:"detail,omitempty"`
+	TextEdit *TextEdit `json:"textEdit,omitempty"`
+	// SortText and FilterText keep the server's fuzzy ranking in editors,
+	// which otherwise sort by label and drop items not starting with the
+	// typed word.
+	SortText   string `json:"sortText,omitempty"`
+	FilterText string `json:"filterText,omitempty"`
+}
+
+type CompletionList struct {
+	IsIncomplete bool             `json:"isIncomplete"`
+	Items        []CompletionItem `json:"items"`
+}
+
+type MarkupContent struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+type Hover struct {
+	Contents MarkupContent `json:"contents"`
+	Range    *Range        `json:"range,omitempty"`
+}
+
+type SemanticTokens struct {
+	Data []int `json:"data"`
+}
+
+// offsetToPosition converts a byte offset in text to an LSP position, whose
+// character is counted in UTF-16 code units.
+func offsetToPosition(text string, offset int) Position {
+	offset = min(max(offset, 0), len(text))
+	var pos Position
+	for i, r := range text[:offset] {
+		if r == '\n' {
+			pos.Line++
+			pos.Character = 0
+			continue
+		}
+		if i+utf8.RuneLen(r) > offset {
+			break
+		}
+		pos.Character += utf16Len(r)
+	}
+	return pos
+}
+
+// positionToOffset converts an LSP position to a byte offset in text. A
+// position past the end of its line maps to the end of the line.
+func positionToOffset(text string, pos Position) int {
+	line := 0
+	offset := 0
+	for line < pos.Line {
+		i := strings.IndexByte(text[offset:], '\n')
+		if i < 0 {
+			return len(text)
+		}
+		offset += i + 1
+		line++
+	}
+	character := 0
+	for i, r := range text[offset:] {
+		if r == '\n' || character >= pos.Character {
+			return offset + i
+		}
+		character += utf16Len(r)
+	}
+	return len(text)
+}
+
+func rangeOf(text string, start, end int) Range {
+	return Range{Start: offsetToPosition(text, start), End: offsetToPosition(text, end)}
+}
+
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
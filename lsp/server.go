@@ -0,0 +1,366 @@
+// Package lsp implements a Language Server Protocol server for NTQL queries.
+// It offers completion, diagnostics, hover documentation and semantic tokens
+// over JSON-RPC, and is run over stdio by the ntql-lsp command.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/DeonteVanterpool/ntql"
+)
+
+// semanticTokenTypes is the legend of token types sent to the client. The
+// index of a type in the legend is its number in semantic token data.
+var semanticTokenTypes = []string{"property", "method", "keyword", "operator", "string", "number", "enumMember", "variable"}
+
+// semanticTokenType maps NTQL token kinds to indexes in semanticTokenTypes.
+// Punctuation and invalid tokens are not highlighted.
+var semanticTokenType = map[ntql.TokenType]int{
+	ntql.TokenSubject:      0,
+	ntql.TokenVerb:         1,
+	ntql.TokenAnd:          2,
+	ntql.TokenOr:           2,
+	ntql.TokenBool:         2,
+	ntql.TokenBang:         3,
+	ntql.TokenString:       4,
+	ntql.TokenInt:          5,
+	ntql.TokenFloat:        5,
+	ntql.TokenDate:         5,
+	ntql.TokenDateTime:     5,
+	ntql.TokenTag:          6,
+	ntql.TokenRelativeDate: 7,
+}
+
+// Server answers LSP requests for NTQL documents. Every open document is
+// treated as a single query.
+type Server struct {
+	schema *ntql.Schema
+	engine *ntql.CompletionEngine
+	docs   map[string]string
+
+	mu       sync.Mutex // guards w
+	w        io.Writer
+	shutdown bool
+}
+
+// NewServer creates a server for queries against schema. Tags are offered as
+// completions for tag values.
+func NewServer(schema *ntql.Schema, tags []string) *Server {
+	if schema == nil {
+		schema = ntql.DefaultSchema()
+	}
+	return &Server{
+		schema: schema,
+		engine: ntql.NewCompletionEngineWithSchema(schema, tags),
+		docs:   map[string]string{},
+	}
+}
+
+// Serve reads messages from r and writes responses and notifications to w
+// until the client sends exit or r is closed.
+func (s *Server) Serve(r io.Reader, w io.Writer) error {
+	s.w = w
+	reader := bufio.NewReader(r)
+	for {
+		body, err := readMessage(reader)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var req request
+		if err := json.Unmarshal(body, &req); err != nil {
+			if err := s.replyError(nil, codeParseError, err.Error()); err != nil {
+				return err
+			}
+			continue
+		}
+		if req.Method == "exit" {
+			return nil
+		}
+		if err := s.handle(req); err != nil {
+			return err
+		}
+	}
+}
+
+// handle dispatches a request or notification. It only returns errors from
+// writing to the client.
+func (s *Server) handle(req request) error {
+	if s.shutdown && req.ID != nil {
+		return s.replyError(req.ID, codeInvalidRequest, "server is shutting down")
+	}
+	var result any
+	var err error
+	switch req.Method {
+	case "initialize":
+		result = s.initialize()
+	case "initialized", "$/cancelRequest", "$/setTrace":
+		return nil
+	case "shutdown":
+		s.shutdown = true
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil
+		}
+		return s.update(params.TextDocument.URI, params.TextDocument.Text)
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if err := json.Unmarshal(req.Params, &params); err != nil || len(params.ContentChanges) == 0 {
+			return nil
+		}
+		return s.update(params.TextDocument.URI, params.ContentChanges[len(params.ContentChanges)-1].Text)
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil
+		}
+		delete(s.docs, params.TextDocument.URI)
+		return s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: params.TextDocument.URI, Diagnostics: []Diagnostic{}})
+	case "textDocument/completion":
+		var params TextDocumentPositionParams
+		if err = json.Unmarshal(req.Params, &params); err == nil {
+			result, err = s.completion(params)
+		}
+	case "textDocument/hover":
+		var params TextDocumentPositionParams
+		if err = json.Unmarshal(req.Params, &params); err == nil {
+			result = s.hover(params)
+		}
+	case "textDocument/semanticTokens/full":
+		var params SemanticTokensParams
+		if err = json.Unmarshal(req.Params, &params); err == nil {
+			result = s.semanticTokens(params)
+		}
+	default:
+		if req.ID == nil {
+			return nil
+		}
+		return s.replyError(req.ID, codeMethodNotFound, "method not found: "+req.Method)
+	}
+
+	if req.ID == nil {
+		return nil
+	}
+	if err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+			return s.replyError(req.ID, codeInvalidParams, err.Error())
+		}
+		return s.replyError(req.ID, codeInternalError, err.Error())
+	}
+	return s.write(response{JSONRPC: "2.0", ID: req.ID, Result: result})
+}
+
+func (s *Server) initialize() any {
+	return map[string]any{
+		"capabilities": map[string]any{
+			// Full document sync: every change sends the whole query.
+			"textDocumentSync": 1,
+			"completionProvider": map[string]any{
+				"triggerCharacters": []string{".", "(", " "},
+			},
+			"hoverProvider": true,
+			"semanticTokensProvider": map[string]any{
+				"legend": map[string]any{
+					"tokenTypes":     semanticTokenTypes,
+					"tokenModifiers": []string{},
+				},
+				"full": true,
+			},
+		},
+		"serverInfo": map[string]any{"name": "ntql-lsp"},
+	}
+}
+
+// update stores the text of a document and publishes its diagnostics.
+func (s *Server) update(uri, text string) error {
+	s.docs[uri] = text
+	_, found := ntql.ParseWithRecovery(s.schema, text)
+	diagnostics := make([]Diagnostic, 0, len(found))
+	for _, d := range found {
+		diagnostics = append(diagnostics, Diagnostic{
+			Range:    rangeOf(text, d.Start, d.End),
+			Severity: DiagnosticSeverityError,
+			Code:     d.Code.String(),
+			Source:   "ntql",
+			Message:  d.Message,
+		})
+	}
+	return s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: uri, Diagnostics: diagnostics})
+}
+
+func (s *Server) completion(params TextDocumentPositionParams) (*CompletionList, error) {
+	list := &CompletionList{Items: []CompletionItem{}}
+	text, ok := s.docs[params.TextDocument.URI]
+	if !ok {
+		return list, nil
+	}
+	cursor := positionToOffset(text, params.Position)
+	items, err := s.engine.SuggestAt(text, cursor)
+	if err != nil {
+		return nil, err
+	}
+	// The ranking changes as the word grows, so the editor asks again rather
+	// than filtering this list.
+	list.IsIncomplete = true
+	for i, item := range items {
+		list.Items = append(list.Items, CompletionItem{
+			Label:      item.Label,
+			Kind:       completionItemKind(item.Kind),
+			Detail:     item.Detail,
+			TextEdit:   &TextEdit{Range: rangeOf(text, item.Start, item.End), NewText: item.InsertText},
+			SortText:   fmt.Sprintf("%04d", i),
+			FilterText: text[item.Start:cursor],
+		})
+	}
+	return list, nil
+}
+
+func completionItemKind(kind ntql.CompletionKind) int {
+	switch kind {
+	case ntql.CompletionSubject:
+		return CompletionItemKindField
+	case ntql.CompletionVerb:
+		return CompletionItemKindMethod
+	case ntql.CompletionConnector:
+		return CompletionItemKindKeyword
+	case ntql.CompletionSnippet:
+		return CompletionItemKindSnippet
+	}
+	return CompletionItemKindValue
+}
+
+// hover documents the subject or verb under the cursor. It returns nil for
+// any other position.
+func (s *Server) hover(params TextDocumentPositionParams) *Hover {
+	text, ok := s.docs[params.TextDocument.URI]
+	if !ok {
+		return nil
+	}
+	offset := positionToOffset(text, params.Position)
+	tokens, _ := ntql.NewLexerWithSchema(s.schema, text).LexWithRecovery()
+
+	var subject *ntql.Subject
+	for _, tok := range tokens {
+		if tok.Kind == ntql.TokenSubject {
+			subject, _ = s.schema.Subject(tok.Literal)
+		}
+		if offset < tok.Position || offset > tok.End || subject == nil {
+			continue
+		}
+		var contents string
+		switch tok.Kind {
+		case ntql.TokenSubject:
+			contents = subjectDoc(subject)
+		case ntql.TokenVerb:
+			verb, ok := subject.LookupVerb(tok.Literal)
+			if !ok {
+				continue
+			}
+			contents = verbDoc(subject, verb)
+		default:
+			continue
+		}
+		r := rangeOf(text, tok.Position, tok.End)
+		return &Hover{Contents: MarkupContent{Kind: "markdown", Value: contents}, Range: &r}
+	}
+	return nil
+}
+
+func subjectDoc(subject *ntql.Subject) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "**%s**: %s", subject.Name, typeNames(subject.ValidTypes))
+	if subject.Description != "" {
+		b.WriteString("\n\n" + subject.Description)
+	}
+	if len(subject.Aliases) > 0 {
+		b.WriteString("\n\nAliases: " + codeList(subject.Aliases))
+	}
+	if subject.Table != "" && subject.Column != "" {
+		fmt.Fprintf(&b, "\n\nColumn: `%s.%s`", subject.Table, subject.Column)
+	}
+	return b.String()
+}
+
+func verbDoc(subject *ntql.Subject, verb *ntql.Verb) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "**%s.%s**(%s)", subject.Name, verb.Name, typeNames(subject.ValidTypes))
+	if verb.Description != "" {
+		b.WriteString("\n\n" + verb.Description)
+	}
+	if len(verb.Aliases) > 0 {
+		b.WriteString("\n\nAliases: " + codeList(verb.Aliases))
+	}
+	return b.String()
+}
+
+func typeNames(dtypes []ntql.DType) string {
+	names := make([]string, len(dtypes))
+	for i, dtype := range dtypes {
+		names[i] = dtype.String()
+	}
+	return strings.Join(names, " | ")
+}
+
+func codeList(words []string) string {
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		quoted[i] = "`" + word + "`"
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// semanticTokens encodes the document's tokens as relative line, start
+// character, length, type and modifiers, as the protocol requires.
+func (s *Server) semanticTokens(params SemanticTokensParams) *SemanticTokens {
+	result := &SemanticTokens{Data: []int{}}
+	text, ok := s.docs[params.TextDocument.URI]
+	if !ok {
+		return result
+	}
+	tokens, _ := ntql.NewLexerWithSchema(s.schema, text).LexWithRecovery()
+	var prev Position
+	for _, tok := range tokens {
+		tokenType, ok := semanticTokenType[tok.Kind]
+		if !ok {
+			continue
+		}
+		start := offsetToPosition(text, tok.Position)
+		end := offsetToPosition(text, tok.End)
+		if end.Line != start.Line {
+			// Tokens spanning lines are not supported by the protocol.
+			continue
+		}
+		deltaStart := start.Character
+		if start.Line == prev.Line {
+			deltaStart -= prev.Character
+		}
+		result.Data = append(result.Data, start.Line-prev.Line, deltaStart, end.Character-start.Character, tokenType, 0)
+		prev = start
+	}
+	return result
+}
+
+func (s *Server) notify(method string, params any) error {
+	return s.write(notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+func (s *Server) replyError(id *json.RawMessage, code int, message string) error {
+	return s.write(errorResponse{JSONRPC: "2.0", ID: id, Error: &responseError{Code: code, Message: message}})
+}
+
+func (s *Server) write(v any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeMessage(s.w, v)
+}
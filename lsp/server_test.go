@@ -0,0 +1,246 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/DeonteVanterpool/ntql"
+)
+
+// testClient talks to a Server over in-memory pipes, as an editor would over
+// stdio.
+type testClient struct {
+	t      *testing.T
+	in     io.WriteCloser
+	out    *bufio.Reader
+	nextID int
+	done   chan error
+	// notifications collects the notifications received while waiting for
+	// responses.
+	notifications []request
+}
+
+func newTestClient(t *testing.T) *testClient {
+	t.Helper()
+	serverIn, clientOut := io.Pipe()
+	clientIn, serverOut := io.Pipe()
+	c := &testClient{t: t, in: clientOut, out: bufio.NewReader(clientIn), done: make(chan error, 1)}
+	go func() {
+		err := NewServer(ntql.DefaultSchema(), []string{"school", "work"}).Serve(serverIn, serverOut)
+		serverOut.Close()
+		c.done <- err
+	}()
+	t.Cleanup(func() { clientOut.Close() })
+	return c
+}
+
+func (c *testClient) send(v any) {
+	c.t.Helper()
+	if err := writeMessage(c.in, v); err != nil {
+		c.t.Fatalf("failed to send message: %v", err)
+	}
+}
+
+func (c *testClient) read() map[string]json.RawMessage {
+	c.t.Helper()
+	body, err := readMessage(c.out)
+	if err != nil {
+		c.t.Fatalf("failed to read message: %v", err)
+	}
+	var msg map[string]json.RawMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		c.t.Fatalf("invalid message %s: %v", body, err)
+	}
+	return msg
+}
+
+// call sends a request and decodes the result of its response into result.
+// It fails the test if the response is an error.
+func (c *testClient) call(method string, params any, result any) {
+	c.t.Helper()
+	c.nextID++
+	c.send(map[string]any{"jsonrpc": "2.0", "id": c.nextID, "method": method, "params": params})
+	for {
+		msg := c.read()
+		if _, ok := msg["id"]; !ok {
+			var n request
+			n.Method = strings.Trim(string(msg["method"]), `"`)
+			n.Params = msg["params"]
+			c.notifications = append(c.notifications, n)
+			continue
+		}
+		if e, ok := msg["error"]; ok {
+			c.t.Fatalf("%s returned an error: %s", method, e)
+		}
+		if result != nil {
+			if err := json.Unmarshal(msg["result"], result); err != nil {
+				c.t.Fatalf("invalid %s result %s: %v", method, msg["result"], err)
+			}
+		}
+		return
+	}
+}
+
+func (c *testClient) notify(method string, params any) {
+	c.t.Helper()
+	c.send(map[string]any{"jsonrpc": "2.0", "method": method, "params": params})
+}
+
+// diagnostics waits for the next publishDiagnostics notification.
+func (c *testClient) diagnostics() PublishDiagnosticsParams {
+	c.t.Helper()
+	msg := c.read()
+	var params PublishDiagnosticsParams
+	if err := json.Unmarshal(msg["params"], &params); err != nil {
+		c.t.Fatalf("invalid diagnostics %s: %v", msg["params"], err)
+	}
+	return params
+}
+
+func (c *testClient) open(uri, text string) PublishDiagnosticsParams {
+	c.t.Helper()
+	c.notify("textDocument/didOpen", DidOpenTextDocumentParams{TextDocument: TextDocumentItem{URI: uri, LanguageID: "ntql", Text: text}})
+	return c.diagnostics()
+}
+
+func TestServerInitializeAndShutdown(t *testing.T) {
+	c := newTestClient(t)
+	var init struct {
+		Capabilities map[string]json.RawMessage `json:"capabilities"`
+	}
+	c.call("initialize", map[string]any{}, &init)
+	for _, capability := range []string{"completionProvider", "hoverProvider", "semanticTokensProvider", "textDocumentSync"} {
+		if _, ok := init.Capabilities[capability]; !ok {
+			t.Fatalf("expected capability %s", capability)
+		}
+	}
+	c.notify("initialized", map[string]any{})
+	c.call("shutdown", nil, nil)
+	c.notify("exit", nil)
+	if err := <-c.done; err != nil {
+		t.Fatalf("Serve() failed: %v", err)
+	}
+}
+
+func TestServerDiagnostics(t *testing.T) {
+	c := newTestClient(t)
+	text := "title.contains(\"a\") AND\ntitel.eq(\"b\")"
+	published := c.open("file:///a.ntql", text)
+	if len(published.Diagnostics) != 1 {
+		t.Fatalf("expected 1 diagnostic, got %+v", published.Diagnostics)
+	}
+	d := published.Diagnostics[0]
+	expected := Range{Start: Position{Line: 1, Character: 0}, End: Position{Line: 1, Character: 5}}
+	if d.Code != "NTQL003" || d.Range != expected || d.Severity != DiagnosticSeverityError {
+		t.Fatalf("unexpected diagnostic: %+v", d)
+	}
+
+	c.notify("textDocument/didChange", DidChangeTextDocumentParams{
+		TextDocument:   TextDocumentIdentifier{URI: "file:///a.ntql"},
+		ContentChanges: []TextDocumentContentChangeEvent{{Text: `title.contains("a")`}},
+	})
+	if published := c.diagnostics(); len(published.Diagnostics) != 0 {
+		t.Fatalf("expected no diagnostics after the fix, got %+v", published.Diagnostics)
+	}
+}
+
+func TestServerCompletion(t *testing.T) {
+	c := newTestClient(t)
+	c.open("file:///a.ntql", `title.con("a")`)
+	var list CompletionList
+	c.call("textDocument/completion", TextDocumentPositionParams{
+		TextDocument: TextDocumentIdentifier{URI: "file:///a.ntql"},
+		Position:     Position{Line: 0, Character: 8},
+	}, &list)
+	if len(list.Items) != 1 {
+		t.Fatalf("expected 1 item, got %+v", list.Items)
+	}
+	item := list.Items[0]
+	expected := Range{Start: Position{Character: 6}, End: Position{Character: 9}}
+	if item.Label != "contains" || item.Kind != CompletionItemKindMethod || item.TextEdit == nil || item.TextEdit.Range != expected {
+		t.Fatalf("unexpected item: %+v", item)
+	}
+	if !list.IsIncomplete || item.SortText != "0000" || item.FilterText != "co" {
+		t.Fatalf("expected the server's ranking to be kept: %+v", list)
+	}
+}
+
+func TestServerHover(t *testing.T) {
+	c := newTestClient(t)
+	c.open("file:///a.ntql", `deadline.before(today)`)
+	var hover Hover
+	c.call("textDocument/hover", TextDocumentPositionParams{
+		TextDocument: TextDocumentIdentifier{URI: "file:///a.ntql"},
+		Position:     Position{Line: 0, Character: 3},
+	}, &hover)
+	if !strings.Contains(hover.Contents.Value, "**due**") || !strings.Contains(hover.Contents.Value, "When the task is due.") {
+		t.Fatalf("unexpected subject hover: %s", hover.Contents.Value)
+	}
+
+	c.call("textDocument/hover", TextDocumentPositionParams{
+		TextDocument: TextDocumentIdentifier{URI: "file:///a.ntql"},
+		Position:     Position{Line: 0, Character: 10},
+	}, &hover)
+	if !strings.HasPrefix(hover.Contents.Value, "**due.before**(date | dateTime)") {
+		t.Fatalf("unexpected verb hover: %s", hover.Contents.Value)
+	}
+}
+
+func TestServerSemanticTokens(t *testing.T) {
+	c := newTestClient(t)
+	c.open("file:///a.ntql", "tag.eq(school) OR\n  priority.eq(3)")
+	var tokens SemanticTokens
+	c.call("textDocument/semanticTokens/full", SemanticTokensParams{TextDocument: TextDocumentIdentifier{URI: "file:///a.ntql"}}, &tokens)
+	expected := []int{
+		0, 0, 3, 0, 0, // tag
+		0, 4, 2, 1, 0, // eq
+		0, 3, 6, 6, 0, // school
+		0, 8, 2, 2, 0, // OR
+		1, 2, 8, 0, 0, // priority
+		0, 9, 2, 1, 0, // eq
+		0, 3, 1, 5, 0, // 3
+	}
+	if len(tokens.Data) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, tokens.Data)
+	}
+	for i := range expected {
+		if tokens.Data[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, tokens.Data)
+		}
+	}
+}
+
+func TestServerUnknownMethod(t *testing.T) {
+	c := newTestClient(t)
+	c.send(map[string]any{"jsonrpc": "2.0", "id": 1, "method": "textDocument/rename", "params": map[string]any{}})
+	msg := c.read()
+	var e responseError
+	if err := json.Unmarshal(msg["error"], &e); err != nil || e.Code != codeMethodNotFound {
+		t.Fatalf("expected a method not found error, got %s", msg["error"])
+	}
+}
+
+func TestPositionConversion(t *testing.T) {
+	text := "a\n😀é.x"
+	cases := []struct {
+		offset int
+		pos    Position
+	}{
+		{0, Position{0, 0}},
+		{2, Position{1, 0}},
+		{6, Position{1, 2}},
+		{8, Position{1, 3}},
+		{len(text), Position{1, 5}},
+	}
+	for _, tc := range cases {
+		if got := offsetToPosition(text, tc.offset); got != tc.pos {
+			t.Errorf("offsetToPosition(%d) = %+v, expected %+v", tc.offset, got, tc.pos)
+		}
+		if got := positionToOffset(text, tc.pos); got != tc.offset {
+			t.Errorf("positionToOffset(%+v) = %d, expected %d", tc.pos, got, tc.offset)
+		}
+	}
+}
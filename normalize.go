@@ -0,0 +1,326 @@
+package ntql
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ErrContradiction is returned by Normalize for a query that can never match,
+// such as priority.gt(5) AND priority.lt(3).
+var ErrContradiction = errors.New("query can never match")
+
+// Normalize returns the canonical form of expr for the default schema. See
+// NormalizeWithSchema.
+func Normalize(expr QueryExpr) (QueryExpr, error) {
+	return NormalizeWithSchema(DefaultSchema(), expr)
+}
+
+// NormalizeOptions controls NormalizeWithOptions.
+type NormalizeOptions struct {
+	// Schema resolves subjects. The default schema is used when it is nil.
+	Schema *Schema
+	// BaseTable is the table the query selects from, as in JoinQueryOptions.
+	// It decides which subjects are behind a to-many join. The schema's root
+	// table is used when it is empty.
+	BaseTable string
+}
+
+// NormalizeWithOptions is like NormalizeWithSchema, for a query that selects
+// from opts.BaseTable.
+func NormalizeWithOptions(expr QueryExpr, opts NormalizeOptions) (QueryExpr, error) {
+	schema := opts.Schema
+	if schema == nil {
+		schema = DefaultSchema()
+	}
+	if expr == nil {
+		return nil, errors.New("empty query")
+	}
+	if _, err := schema.selectBaseTable(opts.BaseTable); err != nil {
+		return nil, err
+	}
+	n := &normalizer{schema: schema, baseTable: opts.BaseTable, types: NewEvaluator(schema)}
+	normalized, err := n.normalize(expr, false)
+	if err != nil {
+		return nil, err
+	}
+	if normalized == nil {
+		return nil, n.contradiction
+	}
+	return normalized, nil
+}
+
+// NormalizeWithSchema returns the canonical form of expr, so that equivalent
+// queries written differently normalize to the same tree and can be compared,
+// hashed or cached through Format or MarshalQuery:
+//
+//   - subject aliases are replaced with canonical names;
+//   - NOT is pushed inward with De Morgan's laws until it only applies to
+//     conditions, removing double negation, and NOT equals, greaterThan and
+//     lessThan become notEquals, lessThanOrEquals and greaterThanOrEquals,
+//     except on subjects behind a to-many join;
+//   - nested AND and OR chains are flattened, their operands deduplicated and
+//     sorted, and rebuilt as left-nested chains, conditions first;
+//   - AND chains holding a trivial contradiction, such as a condition and its
+//     negation, equals and notEquals with the same value, or ranges that do
+//     not overlap, are dropped from the OR chains holding them.
+//
+// A condition on a subject behind a to-many join, such as tag, matches if any
+// related row does, both in the join query and in the Evaluator with a slice
+// value. NOT tag.equals(a) and tag.notEquals(a) differ there, so such
+// negations are kept, and two conditions on the subject are never a
+// contradiction unless one is the negation of the other.
+//
+// If the whole query is a contradiction, it returns an error wrapping
+// ErrContradiction. The input is not modified.
+func NormalizeWithSchema(schema *Schema, expr QueryExpr) (QueryExpr, error) {
+	return NormalizeWithOptions(expr, NormalizeOptions{Schema: schema})
+}
+
+type normalizer struct {
+	schema *Schema
+	// baseTable is the table the query selects from, or empty for the root
+	// table.
+	baseTable string
+	// types decides how values are compared, as the evaluator does.
+	types *Evaluator
+	// contradiction describes the first contradiction found.
+	contradiction error
+}
+
+// normalize returns the normal form of expr, or of its negation if negate is
+// set. It returns nil for an expression that can never match.
+func (n *normalizer) normalize(expr QueryExpr, negate bool) (QueryExpr, error) {
+	switch e := expr.(type) {
+	case *QueryCondition:
+		return n.condition(e, negate)
+	case *QueryUnaryOp:
+		if e.Operator != OperatorNot {
+			return nil, errors.New("invalid operator: " + e.Operator.ToStr())
+		}
+		return n.normalize(e.Operand, !negate)
+	case *QueryBinaryOp:
+		switch e.Operator {
+		case OperatorAnd, OperatorOr:
+			return n.chain(e, negate)
+		case OperatorXor:
+			// NOT (a XOR b) is (NOT a) XOR b.
+			left, err := n.normalize(e.Left, negate)
+			if err != nil {
+				return nil, err
+			}
+			right, err := n.normalize(e.Right, false)
+			if err != nil {
+				return nil, err
+			}
+			if left == nil {
+				return right, nil
+			}
+			if right == nil {
+				return left, nil
+			}
+			return &QueryBinaryOp{Left: left, Right: right, Operator: OperatorXor}, nil
+		}
+		return nil, errors.New("invalid operator: " + e.Operator.ToStr())
+	case *QueryError:
+		if negate {
+			return NewQueryNot(e), nil
+		}
+		return e, nil
+	}
+	return nil, fmt.Errorf("cannot normalize %T", expr)
+}
+
+// negatedOperators maps the comparisons that have a negation of their own.
+var negatedOperators = map[Operator]Operator{
+	OperatorEq:  OperatorNeq,
+	OperatorNeq: OperatorEq,
+	OperatorGt:  OperatorLte,
+	OperatorLte: OperatorGt,
+	OperatorLT:  OperatorGte,
+	OperatorGte: OperatorLT,
+}
+
+// condition returns a copy of c with its canonical subject name, negated if
+// negate is set. A condition on a list is normalized as the conditions on its
+// items.
+func (n *normalizer) condition(c *QueryCondition, negate bool) (QueryExpr, error) {
+	if c.TypedValue().Kind() == LiteralList {
+		expanded, err := expandList(c)
+		if err != nil {
+			return nil, err
+		}
+		return n.normalize(expanded, negate)
+	}
+	field := c.Field
+	if subject, err := n.schema.Subject(c.Field); err == nil {
+		field = subject.Name
+	}
+	normalized := &QueryCondition{Field: field, Operator: c.Operator, Value: c.Value, Literal: c.Literal}
+	if !negate {
+		return normalized, nil
+	}
+	if op, ok := negatedOperators[c.Operator]; ok && !n.schema.toMany(n.baseTable, field) {
+		normalized.Operator = op
+		return normalized, nil
+	}
+	return NewQueryNot(normalized), nil
+}
+
+// chain returns the normal form of an AND or OR chain, or of its negation if
+// negate is set.
+func (n *normalizer) chain(e *QueryBinaryOp, negate bool) (QueryExpr, error) {
+	op := e.Operator
+	// De Morgan: NOT (a AND b) is NOT a OR NOT b, and the reverse.
+	if negate && op == OperatorAnd {
+		op = OperatorOr
+	} else if negate {
+		op = OperatorAnd
+	}
+	var operands []QueryExpr
+	for _, side := range []QueryExpr{e.Left, e.Right} {
+		normalized, err := n.normalize(side, negate)
+		if err != nil {
+			return nil, err
+		}
+		if normalized == nil {
+			if op == OperatorAnd {
+				return nil, nil
+			}
+			continue
+		}
+		operands = append(operands, chainOperands(normalized, op)...)
+	}
+
+	slices.SortStableFunc(operands, func(a, b QueryExpr) int {
+		return cmp.Or(cmp.Compare(exprRank(a), exprRank(b)), strings.Compare(exprKey(a), exprKey(b)))
+	})
+	operands = slices.CompactFunc(operands, func(a, b QueryExpr) bool {
+		return exprKey(a) == exprKey(b)
+	})
+	if op == OperatorAnd && n.contradicts(operands) {
+		return nil, nil
+	}
+	if len(operands) == 0 {
+		return nil, nil
+	}
+	chained := operands[0]
+	for _, operand := range operands[1:] {
+		chained = &QueryBinaryOp{Left: chained, Right: operand, Operator: op}
+	}
+	return chained, nil
+}
+
+// chainOperands returns the operands of a normalized chain of op, or expr
+// itself if it is not one.
+func chainOperands(expr QueryExpr, op Operator) []QueryExpr {
+	if e, ok := expr.(*QueryBinaryOp); ok && e.Operator == op {
+		return append(chainOperands(e.Left, op), chainOperands(e.Right, op)...)
+	}
+	return []QueryExpr{expr}
+}
+
+// exprRank orders the operands of a chain: conditions, then negated
+// conditions, then other chains.
+func exprRank(expr QueryExpr) int {
+	switch expr.(type) {
+	case *QueryCondition:
+		return 0
+	case *QueryUnaryOp:
+		return 1
+	}
+	return 2
+}
+
+// exprKey returns a string that identifies a normalized expression.
+func exprKey(expr QueryExpr) string {
+	switch e := expr.(type) {
+	case *QueryCondition:
+		return strconv.Quote(e.Field) + " " + string(e.Operator) + " " + strconv.Quote(e.Value)
+	case *QueryUnaryOp:
+		return string(e.Operator) + "(" + exprKey(e.Operand) + ")"
+	case *QueryBinaryOp:
+		return string(e.Operator) + "(" + exprKey(e.Left) + ", " + exprKey(e.Right) + ")"
+	case *QueryError:
+		return "error(" + e.Diagnostic.Code.String() + " " + strconv.Quote(e.Diagnostic.Message) + ")"
+	}
+	return fmt.Sprintf("%T", expr)
+}
+
+// contradicts reports whether the operands of an AND chain can never all
+// match, recording the first contradiction found.
+func (n *normalizer) contradicts(operands []QueryExpr) bool {
+	for i, a := range operands {
+		for _, b := range operands[i+1:] {
+			if n.excludes(a, b) {
+				if n.contradiction == nil {
+					n.contradiction = fmt.Errorf("%w: %s AND %s", ErrContradiction, a, b)
+				}
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// excludes reports whether a and b can never both match.
+func (n *normalizer) excludes(a, b QueryExpr) bool {
+	if not, ok := b.(*QueryUnaryOp); ok && exprKey(not.Operand) == exprKey(a) {
+		return true
+	}
+	x, ok := a.(*QueryCondition)
+	if !ok {
+		return false
+	}
+	y, ok := b.(*QueryCondition)
+	if !ok || x.Field != y.Field || n.schema.toMany(n.baseTable, x.Field) {
+		return false
+	}
+	order, comparable := n.compareValues(x, y)
+	equal := x.Value == y.Value || (comparable && order == 0)
+	if (x.Operator == OperatorEq && y.Operator == OperatorNeq) || (x.Operator == OperatorNeq && y.Operator == OperatorEq) {
+		return equal
+	}
+	if !comparable {
+		return false
+	}
+	return below(x, y, order) || below(y, x, -order)
+}
+
+// below reports whether every value matching upper is below every value
+// matching lower. order compares the value of upper with the value of lower.
+func below(upper, lower *QueryCondition, order int) bool {
+	upperOpen := upper.Operator == OperatorLT
+	lowerOpen := lower.Operator == OperatorGt
+	if !slices.Contains([]Operator{OperatorEq, OperatorLT, OperatorLte}, upper.Operator) ||
+		!slices.Contains([]Operator{OperatorEq, OperatorGt, OperatorGte}, lower.Operator) {
+		return false
+	}
+	return order < 0 || (order == 0 && (upperOpen || lowerOpen))
+}
+
+// compareValues compares the values of two conditions on the same subject. It
+// reports false unless both are numbers for a numeric subject, or absolute
+// dates of the same precision for a date subject.
+func (n *normalizer) compareValues(x, y *QueryCondition) (int, bool) {
+	switch n.types.conditionType(x, nil) {
+	case DTypeInt, DTypeFloat:
+		a, aok := new(big.Rat).SetString(x.Value)
+		b, bok := new(big.Rat).SetString(y.Value)
+		if !aok || !bok {
+			return 0, false
+		}
+		return a.Cmp(b), true
+	case DTypeDate, DTypeDateTime:
+		a, b := x.TypedValue(), y.TypedValue()
+		if a.Kind() != b.Kind() || (a.Kind() != LiteralDate && a.Kind() != LiteralDateTime) {
+			return 0, false
+		}
+		return a.Time().Compare(b.Time()), true
+	}
+	return 0, false
+}
@@ -0,0 +1,283 @@
+package ntql
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNormalizeQuery(t *testing.T) {
+	cases := []struct {
+		query    string
+		expected string
+	}{
+		// Double negation.
+		{`!(!title.contains("a"))`, `"title" contains "a"`},
+		// NOT pushed inward, turning NOT equals into notEquals.
+		{`!(title.eq("a") OR title.contains("b"))`, `AND("title" notEquals "a", NOT("title" contains "b"))`},
+		// Distributed calls are flattened, deduplicated and sorted, and
+		// aliases replaced.
+		{`title.contains(("b" OR "a") AND !(!"c")) OR name.contains("d")`, `OR("title" contains "d", AND("title" contains "c", OR("title" contains "a", "title" contains "b")))`},
+		{`(title.eq("c") AND (title.eq("b") AND title.eq("a"))) AND title.eq("b")`, `AND(AND("title" equals "a", "title" equals "b"), "title" equals "c")`},
+		// Contradictory AND chains are dropped from an OR.
+		{`(title.contains("a") AND !title.contains("a")) OR title.eq("b")`, `"title" equals "b"`},
+		{`(due.after(2024-01-05) AND due.before(2024-01-01)) OR title.eq("b")`, `"title" equals "b"`},
+	}
+	for _, tc := range cases {
+		expr, err := parseQuery(tc.query)
+		if err != nil {
+			t.Fatalf("parsing %s failed: %v", tc.query, err)
+		}
+		before := exprKey(expr)
+		normalized, err := Normalize(expr)
+		if err != nil {
+			t.Fatalf("Normalize(%s) failed: %v", tc.query, err)
+		}
+		if key := exprKey(normalized); key != tc.expected {
+			t.Errorf("Normalize(%s) = %s, expected %s", tc.query, key, tc.expected)
+		}
+		if exprKey(expr) != before {
+			t.Errorf("expected Normalize(%s) not to modify its input", tc.query)
+		}
+		again, err := Normalize(normalized)
+		if err != nil || exprKey(again) != exprKey(normalized) {
+			t.Errorf("expected normalizing %s to be stable, got %v: %v", tc.expected, again, err)
+		}
+	}
+}
+
+func TestNormalizeEquivalent(t *testing.T) {
+	pairs := [][2]string{
+		{`title.contains("a" OR "b")`, `name.contains("b") OR title.contains("a")`},
+		{`!(title.eq("a") AND tag.eq(x))`, `!tag.eq(x) OR !title.eq("a")`},
+		{`(title.eq("a") OR title.eq("b")) OR title.eq("c")`, `title.eq("a") OR (title.eq("c") OR title.eq("b") OR title.eq("a"))`},
+	}
+	for _, pair := range pairs {
+		a, _ := parseQuery(pair[0])
+		b, _ := parseQuery(pair[1])
+		na, err := Normalize(a)
+		if err != nil {
+			t.Fatalf("Normalize(%s) failed: %v", pair[0], err)
+		}
+		nb, err := Normalize(b)
+		if err != nil {
+			t.Fatalf("Normalize(%s) failed: %v", pair[1], err)
+		}
+		ja, _ := MarshalQuery(na)
+		jb, _ := MarshalQuery(nb)
+		if string(ja) != string(jb) {
+			t.Errorf("expected %s and %s to normalize the same, got %s and %s", pair[0], pair[1], ja, jb)
+		}
+	}
+}
+
+func TestNormalizeNegation(t *testing.T) {
+	cases := []struct {
+		operator Operator
+		expected string
+	}{
+		{OperatorEq, `"priority" notEquals "5"`},
+		{OperatorNeq, `"priority" equals "5"`},
+		{OperatorGt, `"priority" lessThanOrEquals "5"`},
+		{OperatorLT, `"priority" greaterThanOrEquals "5"`},
+		{OperatorGte, `"priority" lessThan "5"`},
+		{OperatorLte, `"priority" greaterThan "5"`},
+	}
+	for _, tc := range cases {
+		normalized, err := Normalize(NewQueryNot(&QueryCondition{Field: "priority", Operator: tc.operator, Value: "5"}))
+		if err != nil {
+			t.Fatalf("Normalize() failed: %v", err)
+		}
+		if key := exprKey(normalized); key != tc.expected {
+			t.Errorf("expected NOT %s to normalize to %s, got %s", tc.operator, tc.expected, key)
+		}
+	}
+
+	// Negations of subjects behind a to-many join are kept.
+	normalized, err := Normalize(NewQueryNot(&QueryCondition{Field: "tag", Operator: OperatorEq, Value: "a"}))
+	if err != nil || exprKey(normalized) != `NOT("tag" equals "a")` {
+		t.Fatalf("expected NOT tag equals a to be kept, got %v: %v", normalized, err)
+	}
+
+	// NOT (a XOR b) is (NOT a) XOR b.
+	a := &QueryCondition{Field: "priority", Operator: OperatorEq, Value: "1"}
+	b := &QueryCondition{Field: "title", Operator: OperatorCnt, Value: "x"}
+	normalized, err = Normalize(NewQueryNot(&QueryBinaryOp{Left: a, Right: b, Operator: OperatorXor}))
+	if err != nil {
+		t.Fatalf("Normalize() failed: %v", err)
+	}
+	if expected := `XOR("priority" notEquals "1", "title" contains "x")`; exprKey(normalized) != expected {
+		t.Fatalf("expected %s, got %s", expected, exprKey(normalized))
+	}
+}
+
+func TestNormalizeContradiction(t *testing.T) {
+	condition := func(field string, op Operator, value string) *QueryCondition {
+		return &QueryCondition{Field: field, Operator: op, Value: value}
+	}
+	contradictions := []QueryExpr{
+		NewQueryAnd(condition("priority", OperatorGt, "5"), condition("priority", OperatorLT, "3")),
+		NewQueryAnd(condition("priority", OperatorGt, "5"), condition("priority", OperatorLte, "5")),
+		NewQueryAnd(condition("priority", OperatorGte, "5.0"), condition("priority", OperatorLT, "5")),
+		NewQueryAnd(condition("priority", OperatorEq, "2"), condition("priority", OperatorGt, "3")),
+		NewQueryAnd(condition("priority", OperatorEq, "2"), condition("priority", OperatorEq, "3")),
+		NewQueryAnd(condition("title", OperatorEq, "a"), condition("name", OperatorNeq, "a")),
+		NewQueryAnd(condition("due", OperatorGt, "2024-01-05"), condition("due", OperatorLT, "2024-01-01")),
+		NewQueryAnd(condition("title", OperatorCnt, "a"), NewQueryNot(condition("name", OperatorCnt, "a"))),
+		NewQueryAnd(condition("tag", OperatorEq, "a"), NewQueryNot(condition("tag", OperatorEq, "a"))),
+		NewQueryAnd(condition("title", OperatorEq, "a"), NewQueryNot(NewQueryOr(condition("title", OperatorEq, "a"), condition("tag", OperatorEq, "x")))),
+	}
+	for _, expr := range contradictions {
+		if normalized, err := Normalize(expr); !errors.Is(err, ErrContradiction) {
+			t.Errorf("expected %s to be a contradiction, got %v: %v", exprKey(expr), normalized, err)
+		}
+	}
+
+	satisfiable := []QueryExpr{
+		NewQueryAnd(condition("priority", OperatorGte, "5"), condition("priority", OperatorLte, "5")),
+		NewQueryAnd(condition("priority", OperatorGt, "3"), condition("priority", OperatorLT, "5")),
+		NewQueryAnd(condition("priority", OperatorEq, "2"), condition("priority", OperatorNeq, "3")),
+		// Only numbers and absolute dates are compared.
+		NewQueryAnd(condition("title", OperatorEq, "a"), condition("title", OperatorEq, "b")),
+		NewQueryAnd(condition("due", OperatorGt, "today"), condition("due", OperatorLT, "yesterday")),
+		NewQueryAnd(condition("due", OperatorGt, "2024-01-05T00:00:00"), condition("due", OperatorLT, "2024-01-01")),
+		NewQueryAnd(condition("priority", OperatorGt, "5"), condition("effort", OperatorLT, "3")),
+		// Different related rows can match each condition.
+		NewQueryAnd(condition("tag", OperatorEq, "a"), condition("tag", OperatorEq, "b")),
+		NewQueryAnd(condition("tag", OperatorEq, "a"), condition("tag", OperatorNeq, "a")),
+	}
+	for _, expr := range satisfiable {
+		if _, err := Normalize(expr); err != nil {
+			t.Errorf("expected %s to normalize, got %v", exprKey(expr), err)
+		}
+	}
+
+	if _, err := Normalize(nil); err == nil {
+		t.Fatalf("expected an error for an empty query")
+	}
+}
+
+func TestNormalizeRoundTrip(t *testing.T) {
+	cases := []struct {
+		query, formatted string
+	}{
+		{`!title.equals("a")`, `!(title.equals("a"))`},
+		{`!(title.eq("a") OR title.eq("b"))`, `!(title.equals("a")) AND !(title.equals("b"))`},
+		{`!due.after(2024-01-01)`, `!(due.after(2024-01-01))`},
+		{`!priority.gt(3) AND title.contains("x")`, `priority.lessthanorequal(3) AND title.contains("x")`},
+	}
+	for _, tc := range cases {
+		expr, err := parseQuery(tc.query)
+		if err != nil {
+			t.Fatalf("parsing %s failed: %v", tc.query, err)
+		}
+		normalized, err := Normalize(expr)
+		if err != nil {
+			t.Fatalf("Normalize(%s) failed: %v", tc.query, err)
+		}
+		if err := TypeCheck(DefaultSchema(), normalized); err != nil {
+			t.Fatalf("TypeCheck(%s) failed: %v", normalized, err)
+		}
+		data, err := MarshalQuery(normalized)
+		if err != nil {
+			t.Fatalf("MarshalQuery(%s) failed: %v", normalized, err)
+		}
+		unmarshalled, err := UnmarshalQuery(DefaultSchema(), data)
+		if err != nil {
+			t.Fatalf("UnmarshalQuery(%s) failed: %v", data, err)
+		}
+		formatted, err := Format(unmarshalled)
+		if err != nil {
+			t.Fatalf("Format(%s) failed: %v", unmarshalled, err)
+		}
+		if formatted != tc.formatted {
+			t.Fatalf("expected %s to round-trip as %s, got %s", tc.query, tc.formatted, formatted)
+		}
+	}
+}
+
+const baseTableSchemaYAML = `
+subjects:
+  - name: title
+    aliases: []
+    validVerbs:
+      - name: equals
+        aliases: [eq]
+      - name: notequals
+        aliases: []
+    validTypes: [string]
+    table: tasks
+    column: title
+  - name: project
+    aliases: []
+    validVerbs:
+      - name: equals
+        aliases: [eq]
+    validTypes: [string]
+    table: projects
+    column: name
+fieldTypes:
+  dateTypes: [due_date]
+  boolTypes: [completed]
+  numericTypes: [priority]
+  stringTypes: [title]
+rootTable: tasks
+tables:
+  - name: tasks
+    primaryKey: id
+  - name: projects
+    primaryKey: id
+joins:
+  - fromTable: tasks
+    toTable: projects
+    fromKey: project_id
+    toKey: id
+    cardinality: many-to-one
+`
+
+func TestNormalizeBaseTable(t *testing.T) {
+	schema, err := NewSchemaFromYAML([]byte(baseTableSchemaYAML))
+	if err != nil {
+		t.Fatalf("failed to load schema: %v", err)
+	}
+	not := NewQueryNot(&QueryCondition{Field: "title", Operator: OperatorEq, Value: "a"})
+	both := NewQueryAnd(
+		&QueryCondition{Field: "title", Operator: OperatorEq, Value: "a"},
+		&QueryCondition{Field: "title", Operator: OperatorNeq, Value: "a"},
+	)
+
+	// A task has one title.
+	normalized, err := NormalizeWithSchema(schema, not)
+	if err != nil || normalized.String() != "title notEquals a" {
+		t.Fatalf("expected notEquals from the root table, got %v, %v", normalized, err)
+	}
+	if _, err := NormalizeWithSchema(schema, both); !errors.Is(err, ErrContradiction) {
+		t.Fatalf("expected a title to contradict its negation, got %v", err)
+	}
+
+	// A project has many tasks, each with a title.
+	opts := NormalizeOptions{Schema: schema, BaseTable: "projects"}
+	normalized, err = NormalizeWithOptions(not, opts)
+	if err != nil || normalized.String() != "NOT title equals a" {
+		t.Fatalf("expected the negation to be kept from the projects table, got %v, %v", normalized, err)
+	}
+	if _, err := NormalizeWithOptions(both, opts); err != nil {
+		t.Fatalf("expected a project to have tasks titled a and other than a, got %v", err)
+	}
+	if _, err := NormalizeWithOptions(not, NormalizeOptions{Schema: schema, BaseTable: "invoices"}); err == nil {
+		t.Fatalf("expected an unknown base table to be rejected")
+	}
+
+	e := NewEvaluator(schema)
+	a := &QueryCondition{Field: "title", Operator: OperatorEq, Value: "a"}
+	if implies, _, err := e.Implies(a, NewQueryNot(&QueryCondition{Field: "title", Operator: OperatorEq, Value: "b"})); err != nil || !implies {
+		t.Fatalf("expected a task titled a not to be titled b, got %v, %v", implies, err)
+	}
+	e.BaseTable = "projects"
+	implies, record, err := e.Implies(a, NewQueryNot(&QueryCondition{Field: "title", Operator: OperatorEq, Value: "b"}))
+	if err != nil || implies {
+		t.Fatalf("expected a project with a task titled a to be able to have one titled b, got %v, %v", implies, err)
+	}
+	if _, ok := record["title"].([]any); !ok {
+		t.Fatalf("expected the counterexample to hold a list of titles, got %v", record)
+	}
+}
@@ -13,8 +13,28 @@ const (
 	DTypeDate
 	DTypeTag
 	DTypeDateTime
+	DTypeFloat
 )
 
+// String returns the name used for the type in schema.yaml.
+func (d DType) String() string {
+	switch d {
+	case DTypeString:
+		return "string"
+	case DTypeInt:
+		return "int"
+	case DTypeDate:
+		return "date"
+	case DTypeTag:
+		return "tag"
+	case DTypeDateTime:
+		return "dateTime"
+	case DTypeFloat:
+		return "float"
+	}
+	return "unknown"
+}
+
 // TODO: Sanitize input
 // BNF Grammar:
 // query = expr
@@ -30,10 +50,15 @@ const (
 // value_not = ["!"] value_term
 // value_term = "(" value_expr ")" | value
 // value = object # type belonging to current verb
-// object = NUMBER | STRING | DATE | TAG
+// object = NUMBER | DECIMAL | STRING | DATE | DATETIME | RELATIVE_DATE | TAG
 type Parser struct {
 	Tokens []Token
 	Pos    int
+	schema *Schema
+	// recovering is set by ParseWithRecovery, which collects errors in
+	// diagnostics instead of returning the first one.
+	recovering  bool
+	diagnostics []Diagnostic
 }
 
 type ValueBinaryOp struct {
@@ -49,6 +74,8 @@ type ValueUnaryOp struct {
 
 type Value struct {
 	Value string
+	// Token is the token the value was written as.
+	Token Token
 }
 
 type Subject struct {
@@ -58,15 +85,42 @@ type Subject struct {
 	ValidTypes []DType
 	Table      string
 	Column     string
+	// Description documents the subject for editors, such as hover text.
+	Description string
 }
 
 type Verb struct {
 	Name    string
 	Aliases []string
+	// Description documents the verb for editors, such as hover text.
+	Description string
+}
+
+// LookupVerb finds one of the subject's verbs by name or alias, ignoring case
+// and underscores.
+func (s *Subject) LookupVerb(name string) (*Verb, bool) {
+	for _, v := range s.ValidVerbs {
+		if toLowerCase(v.Name) == toLowerCase(name) {
+			return &v, true
+		}
+		for _, alias := range v.Aliases {
+			if toLowerCase(alias) == toLowerCase(name) {
+				return &v, true
+			}
+		}
+	}
+	return nil, false
 }
 
 func (p *Parser) Parse() (QueryExpr, error) {
-	return p.Query()
+	expr, err := p.Query()
+	if err != nil {
+		return nil, err
+	}
+	if p.Pos < len(p.Tokens) {
+		return nil, p.errorAtCurrent(CodeUnexpectedToken, "Unexpected token: "+p.Tokens[p.Pos].String(), TokenAnd, TokenOr)
+	}
+	return expr, nil
 }
 
 func NewParserError(message string, t Token) *ParserError {
@@ -76,32 +130,90 @@ func NewParserError(message string, t Token) *ParserError {
 	}
 }
 
+// errorAtCurrent reports an error at the current token. At the end of the
+// input the error points just past the last token and has code
+// CodeUnexpectedEnd.
+func (p *Parser) errorAtCurrent(code DiagnosticCode, message string, expected ...TokenType) *ParserError {
+	var tok Token
+	if p.Pos < len(p.Tokens) {
+		tok = p.Tokens[p.Pos]
+	} else {
+		code = CodeUnexpectedEnd
+		message += ", reached end of input"
+		if len(p.Tokens) > 0 {
+			end := p.Tokens[len(p.Tokens)-1].End
+			tok = Token{Position: end, End: end}
+		}
+	}
+	return &ParserError{Message: message, Token: tok, Code: code, Expected: expected}
+}
+
+// errorAtPrevious reports an error at the token that was just consumed.
+func (p *Parser) errorAtPrevious(code DiagnosticCode, message string, expected ...TokenType) *ParserError {
+	return &ParserError{Message: message, Token: p.previous(), Code: code, Expected: expected}
+}
+
+// suggest adds "did you mean" suggestions to the error and its message.
+func (e *ParserError) suggest(suggestions []string) *ParserError {
+	e.Suggestions = suggestions
+	e.Message += didYouMean(suggestions)
+	return e
+}
+
 type ValueExpr interface {
 	Transform(subject string, verb string) (QueryExpr, error)
 }
 
+// Transform turns v into conditions on subject, with the operator the default
+// schema declares for verb. See TransformWithSchema.
 func (v *Value) Transform(subject string, verb string) (QueryExpr, error) {
-	return NewQueryCondition(subject, verb, v.Value)
+	return TransformWithSchema(DefaultSchema(), v, subject, verb)
 }
 
 func (v *ValueBinaryOp) Transform(subject string, verb string) (QueryExpr, error) {
-	left, err := v.Left.Transform(subject, verb)
-	if err != nil {
-		return nil, err
+	return TransformWithSchema(DefaultSchema(), v, subject, verb)
+}
+
+func (v *ValueUnaryOp) Transform(subject string, verb string) (QueryExpr, error) {
+	return TransformWithSchema(DefaultSchema(), v, subject, verb)
+}
+
+// TransformWithSchema turns the value expression v into conditions on subject,
+// with the operator schema declares for verb. The default schema is used when
+// schema is nil.
+func TransformWithSchema(schema *Schema, v ValueExpr, subject string, verb string) (QueryExpr, error) {
+	if schema == nil {
+		schema = DefaultSchema()
 	}
-	right, err := v.Right.Transform(subject, verb)
+	op, err := schema.verbOperator(verb)
 	if err != nil {
 		return nil, err
 	}
-	return &QueryBinaryOp{Left: left, Right: right, Operator: v.Operator}, nil
+	return transformValue(v, subject, op), nil
 }
 
-func (v *ValueUnaryOp) Transform(subject string, verb string) (QueryExpr, error) {
-	operand, err := v.Operand.Transform(subject, verb)
-	if err != nil {
-		return nil, err
+// transformValue turns a value expression into conditions on subject with the
+// operator op, keeping the structure of the expression.
+func transformValue(v ValueExpr, subject string, op Operator) QueryExpr {
+	switch v := v.(type) {
+	case *ValueBinaryOp:
+		return &QueryBinaryOp{Left: transformValue(v.Left, subject, op), Right: transformValue(v.Right, subject, op), Operator: v.Operator}
+	case *ValueUnaryOp:
+		return &QueryUnaryOp{Operand: transformValue(v.Operand, subject, op), Operator: v.Operator}
+	}
+	value := v.(*Value)
+	return &QueryCondition{Field: subject, Operator: op, Value: value.Value, Literal: valueLiteral(value)}
+}
+
+// valueLiteral returns the literal of a value token. Values the literal type
+// cannot hold, such as ints that do not fit in 64 bits, are left unparsed and
+// rejected as out of range when the query is used.
+func valueLiteral(v *Value) Literal {
+	kind := literalKindOf(v.Token.Kind)
+	if literal, err := ParseLiteral(kind, v.Value); err == nil {
+		return literal
 	}
-	return &QueryUnaryOp{Operand: operand, Operator: v.Operator}, nil
+	return unparsedLiteral(kind, v.Value)
 }
 
 func (p *Parser) match(t TokenType) bool {
@@ -120,11 +232,22 @@ func (p *Parser) advance() {
 }
 
 func (p *Parser) previous() Token {
+	if p.Pos == 0 || p.Pos > len(p.Tokens) {
+		return Token{}
+	}
 	return p.Tokens[p.Pos-1]
 }
 
+// NewParser creates a parser that resolves subjects and verbs against the
+// default schema.
 func NewParser(tokens []Token) *Parser {
-	return &Parser{Tokens: tokens, Pos: 0}
+	return NewParserWithSchema(DefaultSchema(), tokens)
+}
+
+// NewParserWithSchema creates a parser that resolves subjects and verbs against
+// schema.
+func NewParserWithSchema(schema *Schema, tokens []Token) *Parser {
+	return &Parser{Tokens: tokens, Pos: 0, schema: schema}
 }
 
 func (p *Parser) Query() (QueryExpr, error) {
@@ -185,13 +308,21 @@ func (p *Parser) Term() (QueryExpr, error) {
 			return nil, err
 		}
 		if !p.match(TokenRParen) {
-			return nil, NewParserError("Expected closing parenthesis", p.previous())
+			err := p.errorAtCurrent(CodeUnexpectedToken, "Expected closing parenthesis", TokenRParen)
+			if !p.recovering {
+				return nil, err
+			}
+			p.report(err.Diagnostic())
 		}
 		return expr, nil
 	} else {
+		start := p.Pos
 		funcCall, err := p.FunctionCall()
 		if err != nil {
-			return nil, err
+			if !p.recovering {
+				return nil, err
+			}
+			return p.recoverFrom(err, start), nil
 		}
 
 		return funcCall, nil
@@ -205,16 +336,20 @@ func (p *Parser) FunctionCall() (QueryExpr, error) {
 	}
 
 	if !p.match(TokenDot) {
-		return nil, NewParserError("Expected dot", p.previous())
+		return nil, p.errorAtCurrent(CodeUnexpectedToken, "Expected dot", TokenDot)
 	}
 
 	verb, err := p.Verb(subject)
 	if err != nil {
 		return nil, err
 	}
+	op, err := p.schema.verbOperator(verb)
+	if err != nil {
+		return nil, p.errorAtPrevious(CodeUnknownVerb, "Verb has no operator: "+verb, TokenVerb)
+	}
 
 	if !p.match(TokenLParen) {
-		return nil, NewParserError("Expected opening parenthesis", p.previous())
+		return nil, p.errorAtCurrent(CodeUnexpectedToken, "Expected opening parenthesis", TokenLParen)
 	}
 
 	valueExpr, err := p.ValueExpr()
@@ -223,10 +358,38 @@ func (p *Parser) FunctionCall() (QueryExpr, error) {
 	}
 
 	if !p.match(TokenRParen) {
-		return nil, NewParserError("Expected closing parenthesis", p.previous())
+		return nil, p.errorAtCurrent(CodeUnexpectedToken, "Expected closing parenthesis", TokenRParen)
 	}
 
-	return valueExpr.Transform(subject, verb)
+	if err := p.checkValues(subject, verb, valueExpr); err != nil {
+		return nil, err
+	}
+
+	return transformValue(valueExpr, subject, op), nil
+}
+
+// checkValues reports the first value in v whose type the subject does not
+// accept for verb, at the value's token.
+func (p *Parser) checkValues(subject string, verb string, v ValueExpr) error {
+	switch v := v.(type) {
+	case *Value:
+		s, err := p.schema.Subject(subject)
+		if err != nil {
+			return nil
+		}
+		c := &QueryCondition{Field: s.Name, Value: v.Value, Literal: valueLiteral(v)}
+		if _, err := p.schema.checkValue(s, verb, c); err != nil {
+			return &ParserError{Message: err.Error(), Token: v.Token, Code: CodeTypeMismatch}
+		}
+	case *ValueBinaryOp:
+		if err := p.checkValues(subject, verb, v.Left); err != nil {
+			return err
+		}
+		return p.checkValues(subject, verb, v.Right)
+	case *ValueUnaryOp:
+		return p.checkValues(subject, verb, v.Operand)
+	}
+	return nil
 }
 
 func toLowerCase(s string) string {
@@ -240,38 +403,31 @@ func toLowerCase(s string) string {
 func (p *Parser) Subject() (string, error) {
 	if p.match(TokenSubject) {
 		subject := p.previous().Literal
-		s, err := getSubject(subject)
+		s, err := p.schema.Subject(subject)
 		if err != nil {
-			return "", NewParserError("Invalid subject: "+subject, p.previous())
+			return "", p.errorAtPrevious(CodeUnknownSubject, "Invalid subject: "+subject, TokenSubject).suggest(p.schema.closestSubjects(subject))
 		}
 		return s.Name, nil
 	} else {
-		return "", NewParserError("Expected subject", p.previous())
+		return "", p.errorAtCurrent(CodeUnexpectedToken, "Expected subject", TokenSubject, TokenLParen, TokenBang)
 	}
 }
 
 func (p *Parser) Verb(subject string) (string, error) {
-	for _, s := range validSubjects {
+	for _, s := range p.schema.subjects {
 		if s.Name == subject {
 			if p.match(TokenVerb) {
 				verb := p.previous().Literal
-				for _, v := range s.ValidVerbs {
-					if toLowerCase(v.Name) == toLowerCase(verb) {
-						return v.Name, nil
-					}
-					for _, alias := range v.Aliases {
-						if toLowerCase(alias) == toLowerCase(verb) {
-							return v.Name, nil
-						}
-					}
+				if v, ok := s.LookupVerb(verb); ok {
+					return v.Name, nil
 				}
-				return "", NewParserError("Invalid verb: "+verb, p.previous())
+				return "", p.errorAtPrevious(CodeUnknownVerb, "Invalid verb: "+verb, TokenVerb).suggest(s.closestVerbs(verb))
 			} else {
-				return "", NewParserError("Expected verb", p.previous())
+				return "", p.errorAtCurrent(CodeUnexpectedToken, "Expected verb", TokenVerb)
 			}
 		}
 	}
-	return "", NewParserError("Invalid subject: "+subject, p.previous())
+	return "", p.errorAtPrevious(CodeUnknownSubject, "Invalid subject: "+subject, TokenSubject).suggest(p.schema.closestSubjects(subject))
 }
 
 func (p *Parser) ValueExpr() (ValueExpr, error) {
@@ -337,7 +493,7 @@ func (p *Parser) ValueTerm() (ValueExpr, error) {
 			return nil, err
 		}
 		if !p.match(TokenRParen) {
-			return nil, NewParserError("Expected closing parenthesis", p.Tokens[p.Pos])
+			return nil, p.errorAtCurrent(CodeUnexpectedToken, "Expected closing parenthesis", TokenRParen)
 		}
 		return valueExpr, nil
 	} else {
@@ -350,9 +506,12 @@ func (p *Parser) ValueTerm() (ValueExpr, error) {
 }
 
 func (p *Parser) ValueObject() (ValueExpr, error) {
-	if p.match(TokenString) || p.match(TokenDate) || p.match(TokenTag) || p.match(TokenInt) {
-		return &Value{Value: p.previous().Literal}, nil
+	if p.match(TokenString) || p.match(TokenDate) || p.match(TokenDateTime) || p.match(TokenRelativeDate) || p.match(TokenTag) || p.match(TokenInt) || p.match(TokenFloat) {
+		return &Value{Value: p.previous().Literal, Token: p.previous()}, nil
 	} else {
-		return nil, NewParserError("Expected value. Got: "+p.Tokens[p.Pos].Literal, p.Tokens[p.Pos])
+		if p.Pos >= len(p.Tokens) {
+			return nil, p.errorAtCurrent(CodeUnexpectedToken, "Expected value")
+		}
+		return nil, p.errorAtCurrent(CodeUnexpectedToken, "Expected value. Got: "+p.Tokens[p.Pos].Literal)
 	}
 }
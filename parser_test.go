@@ -600,3 +600,28 @@ func TestNestedNot2(t *testing.T) {
 		t.Errorf("Expected: %s\n Got: %s", expectedStr, qStr)
 	}
 }
+
+func TestParserVerbOperators(t *testing.T) {
+	cases := []struct {
+		query     string
+		operator  Operator
+		formatted string
+	}{
+		{`priority.gte(3)`, OperatorGte, "priority.greaterthanorequal(3)"},
+		{`priority.lte(3)`, OperatorLte, "priority.lessthanorequal(3)"},
+		{`title.endswith("x")`, OperatorEw, `title.endswith("x")`},
+		{`due.after(2024-01-08)`, OperatorGt, "due.after(2024-01-08)"},
+	}
+	for _, tc := range cases {
+		expr, err := parseQuery(tc.query)
+		if err != nil {
+			t.Fatalf("parsing %s failed: %v", tc.query, err)
+		}
+		if c := expr.(*QueryCondition); c.Operator != tc.operator {
+			t.Errorf("expected %s to have operator %s, got %s", tc.query, tc.operator, c.Operator)
+		}
+		if formatted, err := Format(expr); err != nil || formatted != tc.formatted {
+			t.Errorf("expected %s to format as %s, got %s, %v", tc.query, tc.formatted, formatted, err)
+		}
+	}
+}
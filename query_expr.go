@@ -1,10 +1,10 @@
 package ntql
 
 import (
+	"encoding/json"
 	"errors"
-	"regexp"
+	"fmt"
 	"slices"
-	"strconv"
 )
 
 type QueryExpr interface {
@@ -13,6 +13,18 @@ type QueryExpr interface {
 	String() string
 }
 
+// ToParameterizedSQL converts expr to a SQL string with ? placeholders in
+// place of values, and returns the values to bind to them in order.
+func ToParameterizedSQL(expr QueryExpr) (string, []any, error) {
+	return writeSQL(expr, SQLOptions{Parameterized: true})
+}
+
+// ToSQLWithOptions converts expr to SQL for the given dialect. The returned
+// arguments are empty unless opts.Parameterized is set.
+func ToSQLWithOptions(expr QueryExpr, opts SQLOptions) (string, []any, error) {
+	return writeSQL(expr, opts)
+}
+
 type Operator string // I chose string over iota to increase resilience to changes, especially for communication between the frontend and backend
 
 const (
@@ -38,36 +50,43 @@ const (
 	OperatorEw  Operator = "endsWith"
 )
 
+// comparisonNames maps the names NewOperator accepts for comparisons, in
+// lower case and without underscores, to their operators.
+var comparisonNames = map[string]Operator{
+	"equals":              OperatorEq,
+	"notequals":           OperatorNeq,
+	"greaterthan":         OperatorGt,
+	"after":               OperatorGt,
+	"lessthan":            OperatorLT,
+	"before":              OperatorLT,
+	"greaterthanorequal":  OperatorGte,
+	"greaterthanorequals": OperatorGte,
+	"lessthanorequal":     OperatorLte,
+	"lessthanorequals":    OperatorLte,
+	"contains":            OperatorCnt,
+	"startswith":          OperatorSW,
+	"endswith":            OperatorEw,
+}
+
+// NewOperator returns the operator named s. Logical operators are written in
+// upper case; comparison names ignore case and underscores, so greaterThan,
+// greaterthan and greater_than are the same. Schemas that declare their verbs
+// map them to operators themselves; see Schema.
 func NewOperator(s string) (Operator, error) {
-	if s == "AND" {
+	switch s {
+	case "AND":
 		return OperatorAnd, nil
-	} else if s == "OR" {
+	case "OR":
 		return OperatorOr, nil
-	} else if s == "XOR" {
+	case "XOR":
 		return OperatorXor, nil
-	} else if s == "NOT" {
+	case "NOT":
 		return OperatorNot, nil
-	} else if s == "equals" {
-		return OperatorEq, nil
-	} else if s == "notEquals" {
-		return OperatorNeq, nil
-	} else if s == "greaterThan" || s == "after" {
-		return OperatorGt, nil
-	} else if s == "lessThan" || s == "before" {
-		return OperatorLT, nil
-	} else if s == "greaterthanorequals" {
-		return OperatorGte, nil
-	} else if s == "lessthanorequals" {
-		return OperatorLte, nil
-	} else if s == "contains" {
-		return OperatorCnt, nil
-	} else if s == "startswith" {
-		return OperatorSW, nil
-	} else if s == "endsWith" {
-		return OperatorEw, nil
-	} else {
-		return "", errors.New("invalid operator: " + s)
 	}
+	if op, ok := comparisonNames[toLowerCase(s)]; ok {
+		return op, nil
+	}
+	return "", errors.New("invalid operator: " + s)
 }
 
 func (o Operator) ToStr() string {
@@ -86,11 +105,24 @@ func (q *QueryBinaryOp) String() string {
 }
 
 func (q *QueryBinaryOp) ToSQL() (string, error) {
-	left, err := q.Left.ToSQL()
+	sql, _, err := writeSQL(q, SQLOptions{})
+	return sql, err
+}
+
+func (q *QueryBinaryOp) ToParameterizedSQL() (string, []any, error) {
+	return writeSQL(q, SQLOptions{Parameterized: true})
+}
+
+func (q *QueryBinaryOp) ToSQLWithOptions(opts SQLOptions) (string, []any, error) {
+	return writeSQL(q, opts)
+}
+
+func (q *QueryBinaryOp) writeSQL(w *sqlWriter) (string, error) {
+	left, err := w.write(q.Left)
 	if err != nil {
 		return "", err
 	}
-	right, err := q.Right.ToSQL()
+	right, err := w.write(q.Right)
 	if err != nil {
 		return "", err
 	}
@@ -100,7 +132,7 @@ func (q *QueryBinaryOp) ToSQL() (string, error) {
 	case OperatorOr:
 		return "(" + left + " OR " + right + ")", nil
 	case OperatorXor:
-		return "(" + left + " XOR " + right + ")", nil
+		return w.dialect.Xor(left, right), nil
 	default:
 		return "", errors.New("invalid operator: " + q.Operator.ToStr())
 	}
@@ -113,7 +145,20 @@ type QueryUnaryOp struct {
 }
 
 func (q *QueryUnaryOp) ToSQL() (string, error) {
-	operand, err := q.Operand.ToSQL()
+	sql, _, err := writeSQL(q, SQLOptions{})
+	return sql, err
+}
+
+func (q *QueryUnaryOp) ToParameterizedSQL() (string, []any, error) {
+	return writeSQL(q, SQLOptions{Parameterized: true})
+}
+
+func (q *QueryUnaryOp) ToSQLWithOptions(opts SQLOptions) (string, []any, error) {
+	return writeSQL(q, opts)
+}
+
+func (q *QueryUnaryOp) writeSQL(w *sqlWriter) (string, error) {
+	operand, err := w.write(q.Operand)
 	if err != nil {
 		return "", err
 	}
@@ -129,43 +174,158 @@ func (q *QueryUnaryOp) String() string {
 	return q.Operator.ToStr() + " " + q.Operand.String()
 }
 
+// QueryError stands in for a part of a query that could not be parsed. It is
+// only produced by ParseWithRecovery, so the rest of the query can still be
+// inspected. Converting it to SQL or evaluating it fails with its diagnostic.
+type QueryError struct {
+	Diagnostic Diagnostic `json:"diagnostic"`
+}
+
+func (q *QueryError) String() string {
+	return "<error: " + q.Diagnostic.Message + ">"
+}
+
+func (q *QueryError) ToSQL() (string, error) {
+	sql, _, err := writeSQL(q, SQLOptions{})
+	return sql, err
+}
+
+func (q *QueryError) ToParameterizedSQL() (string, []any, error) {
+	return writeSQL(q, SQLOptions{Parameterized: true})
+}
+
+func (q *QueryError) ToSQLWithOptions(opts SQLOptions) (string, []any, error) {
+	return writeSQL(q, opts)
+}
+
+func (q *QueryError) writeSQL(w *sqlWriter) (string, error) {
+	return "", q.Diagnostic
+}
+
 // QueryCondition represents a condition in a query.
 type QueryCondition struct {
 	Field    string   `json:"field"`
 	Operator Operator `json:"operator"`
-	Value    string   `json:"value"`
+	// Value is the value as written in the query, without quotes or escapes.
+	Value string `json:"value"`
+	// Literal is the typed value, set by the parser from the kind of token
+	// the value was written as. It is the zero Literal for conditions built
+	// from Value alone; see TypedValue. A condition on a list matches if it
+	// matches any of the items, or for notEquals, none of them.
+	Literal Literal `json:"-"`
+}
+
+// NewTypedCondition returns a condition on a typed value.
+func NewTypedCondition(field string, op Operator, value Literal) *QueryCondition {
+	return &QueryCondition{Field: field, Operator: op, Value: value.String(), Literal: value}
+}
+
+// TypedValue returns the typed value of the condition: its Literal if it was
+// set for the current Value, or otherwise the type Value reads as when written
+// bare in a query, falling back to a string.
+func (q *QueryCondition) TypedValue() Literal {
+	if q.Literal.Kind() != LiteralInvalid && q.Literal.String() == q.Value {
+		return q.Literal
+	}
+	return inferLiteral(q.Value)
+}
+
+// expandList returns a condition on a list as the conditions on its items: an
+// OR chain, or an AND chain for notEquals.
+func expandList(c *QueryCondition) (QueryExpr, error) {
+	items := c.Literal.Items()
+	if len(items) == 0 {
+		return nil, errors.New("empty list for field: " + c.Field)
+	}
+	op := OperatorOr
+	if c.Operator == OperatorNeq {
+		op = OperatorAnd
+	}
+	var expanded QueryExpr
+	for _, item := range items {
+		var next QueryExpr = NewTypedCondition(c.Field, c.Operator, item)
+		if item.Kind() == LiteralList {
+			var err error
+			if next, err = expandList(next.(*QueryCondition)); err != nil {
+				return nil, err
+			}
+		}
+		if expanded == nil {
+			expanded = next
+		} else {
+			expanded = &QueryBinaryOp{Left: expanded, Right: next, Operator: op}
+		}
+	}
+	return expanded, nil
+}
+
+// expandLists replaces every condition on a list in expr with the conditions
+// on its items. See expandList.
+func expandLists(expr QueryExpr) (QueryExpr, error) {
+	return Rewrite(expr, RewriterFuncs{LeaveFunc: func(expr QueryExpr) (QueryExpr, error) {
+		if c, ok := expr.(*QueryCondition); ok && c.TypedValue().Kind() == LiteralList {
+			return expandList(c)
+		}
+		return expr, nil
+	}})
 }
 
 func (q *QueryCondition) String() string {
 	return q.Field + " " + q.Operator.ToStr() + " " + q.Value
 }
 
-// TODO: Input sanitization
 // Convert the query condition to a SQL string.
 func (c *QueryCondition) ToSQL() (string, error) {
-	if c.Field == "tag" {
-		_, err := strconv.Atoi(c.Value)
+	sql, _, err := writeSQL(c, SQLOptions{})
+	return sql, err
+}
+
+func (c *QueryCondition) ToParameterizedSQL() (string, []any, error) {
+	return writeSQL(c, SQLOptions{Parameterized: true})
+}
+
+func (c *QueryCondition) ToSQLWithOptions(opts SQLOptions) (string, []any, error) {
+	return writeSQL(c, opts)
+}
+
+func (c *QueryCondition) writeSQL(w *sqlWriter) (string, error) {
+	if c.TypedValue().Kind() == LiteralList {
+		expanded, err := expandList(c)
 		if err != nil {
+			return "", err
+		}
+		return w.write(expanded)
+	}
+	if c.Field == "tag" {
+		if _, ok := tagID(c); !ok {
 			switch c.Operator {
-			case OperatorEq:
-				return "tag_id = (SELECT id FROM atomic_tags WHERE title = '" + c.Value + "')", nil
-			case OperatorNeq:
-				return "tag_id = (SELECT id FROM atomic_tags WHERE title != '" + c.Value + "')", nil
-			case OperatorCnt:
-				return "tag_id = (SELECT id FROM atomic_tags WHERE title LIKE '%" + c.Value + "%')", nil
-			case OperatorSW:
-				return "tag_id = (SELECT id FROM atomic_tags WHERE title LIKE '" + c.Value + "%')", nil
-			case OperatorEw:
-				return "tag_id = (SELECT id FROM atomic_tags WHERE title LIKE '%" + c.Value + "')", nil
+			case OperatorEq, OperatorNeq:
+				value, err := w.stringValue(c)
+				if err != nil {
+					return "", err
+				}
+				cmp, err := writeComparison(c, w.ident("title"), value)
+				if err != nil {
+					return "", err
+				}
+				return w.tagSubquery(cmp), nil
+			case OperatorCnt, OperatorSW, OperatorEw:
+				cmp, err := writeTypedCondition(w, c, w.ident("title"), DTypeString)
+				if err != nil {
+					return "", err
+				}
+				return w.tagSubquery(cmp), nil
 			default:
 				return "", errors.New("invalid operator: " + c.Operator.ToStr() + " for field: " + c.Field)
 			}
 		} else {
 			switch c.Operator {
-			case OperatorEq:
-				return "tag_id = (SELECT id FROM atomic_tags WHERE id = " + c.Value + ")", nil
-			case OperatorNeq:
-				return "tag_id = (SELECT id FROM atomic_tags WHERE id != " + c.Value + ")", nil
+			case OperatorEq, OperatorNeq:
+				cmp, err := writeTypedCondition(w, c, w.ident("id"), DTypeInt)
+				if err != nil {
+					return "", err
+				}
+				return w.tagSubquery(cmp), nil
 			default:
 				return "", errors.New("invalid operator: " + c.Operator.ToStr() + " for field: " + c.Field)
 			}
@@ -175,88 +335,73 @@ func (c *QueryCondition) ToSQL() (string, error) {
 	if c.Field == "completed" {
 		if (c.Value == "true" && c.Operator == OperatorEq) || (c.Value == "false" && c.Operator == OperatorNeq) {
 			// return if completed_at before now
-			return "completed_at < NOW()", nil
+			return w.ident("completed_at") + " < " + w.dialect.Now(), nil
 		} else if (c.Value == "true" && c.Operator == OperatorNeq) || (c.Value == "false" && c.Operator == OperatorEq) {
 			// return if completed_at after now or NULL
-			return "completed_at > NOW() OR completed_at IS NULL", nil
+			return w.ident("completed_at") + " > " + w.dialect.Now() + " OR " + w.ident("completed_at") + " IS NULL", nil
 		} else {
 			return "", errors.New("invalid value: " + c.Value + " for field: " + c.Field)
 		}
 	}
-	if slices.Contains(date_types, c.Field) {
-		// check if datetime is in the ISO 8601 format
-		if !regexp.MustCompile(`^\d{4}-\d{2}-\d{2}(T\d{2}:\d{2}:\d{2}Z)?$`).MatchString(c.Value) {
-			return "", errors.New("invalid value: " + c.Value + " for field: " + c.Field)
-		}
-		switch c.Operator {
-		case OperatorEq:
-			return c.Field + " = '" + c.Value + "'", nil
-		case OperatorNeq:
-			return c.Field + " != '" + c.Value + "'", nil
-		case OperatorGt:
-			return c.Field + " > '" + c.Value + "'", nil
-		case OperatorLT:
-			return c.Field + " < '" + c.Value + "'", nil
-		case OperatorGte:
-			return c.Field + " >= '" + c.Value + "'", nil
-		case OperatorLte:
-			return c.Field + " <= '" + c.Value + "'", nil
-		default:
-			return "", errors.New("invalid operator: " + c.Operator.ToStr() + " for field: " + c.Field)
-		}
-	} else if slices.Contains(bool_types, c.Field) {
-		switch c.Operator {
-		case OperatorEq:
-			return c.Field + " = " + c.Value, nil
-		case OperatorNeq:
-			return c.Field + " != " + c.Value, nil
-		default:
-			return "", errors.New("invalid operator: " + c.Operator.ToStr() + " for field: " + c.Field)
-		}
-	} else if slices.Contains(string_types, c.Field) {
-		if !regexp.MustCompile(`^[a-zA-Z0-9\-/: ]+$`).MatchString(c.Value) {
-			return "", errors.New("invalid value: " + c.Value + " for field: " + c.Field)
+	schema := w.schema
+	column := c.Field
+	subject, err := schema.Subject(c.Field)
+	if err == nil && subject.Table != "" && subject.Table != schema.rootTable {
+		return "", fmt.Errorf("subject %s is in table %s, which a WHERE clause on %s cannot reach; build a join query instead", subject.Name, subject.Table, schema.rootTable)
+	}
+	if err == nil && subject.Column != "" {
+		column = subject.Column
+	}
+	field := w.ident(column)
+	if slices.Contains(schema.dateTypes, column) {
+		return writeTypedCondition(w, c, field, DTypeDate)
+	} else if slices.Contains(schema.boolTypes, column) {
+		value, err := w.boolValue(c)
+		if err != nil {
+			return "", err
 		}
 		switch c.Operator {
-		case OperatorEq:
-			return c.Field + " = '" + c.Value + "'", nil
-		case OperatorNeq:
-			return c.Field + " != '" + c.Value + "'", nil
-		case OperatorCnt:
-			return c.Field + " LIKE '%" + c.Value + "%'", nil
-		case OperatorSW:
-			return c.Field + " LIKE '" + c.Value + "%'", nil
-		case OperatorEw:
-			return c.Field + " LIKE '%" + c.Value + "'", nil
+		case OperatorEq, OperatorNeq:
+			return writeComparison(c, field, value)
 		default:
 			return "", errors.New("invalid operator: " + c.Operator.ToStr() + " for field: " + c.Field)
 		}
-	} else if slices.Contains(numeric_types, c.Field) {
-		_, err := strconv.Atoi(c.Value)
+	} else if slices.Contains(schema.stringTypes, column) {
+		return writeTypedCondition(w, c, field, DTypeString)
+	} else if slices.Contains(schema.numericTypes, column) {
+		return writeTypedCondition(w, c, field, numericType(c))
+	} else if subject != nil {
+		// Columns missing from the field type lists are compared with the
+		// subject's type, as in join queries.
+		verb, _ := schema.verbFor(subject, c.Operator)
+		dtype, err := schema.checkValue(subject, verb, c)
 		if err != nil {
-			return "", errors.New("invalid value: " + c.Value + " for field: " + c.Field)
-		}
-		switch c.Operator {
-		case OperatorEq:
-			return c.Field + " = " + c.Value, nil
-		case OperatorNeq:
-			return c.Field + " != " + c.Value, nil
-		case OperatorGt:
-			return c.Field + " > " + c.Value, nil
-		case OperatorLT:
-			return c.Field + " < " + c.Value, nil
-		case OperatorGte:
-			return c.Field + " >= " + c.Value, nil
-		case OperatorLte:
-			return c.Field + " <= " + c.Value, nil
-		default:
-			return "", errors.New("invalid operator: " + c.Operator.ToStr() + " for field: " + c.Field)
+			return "", err
 		}
+		return writeTypedCondition(w, c, field, dtype)
 	} else {
 		return "", errors.New("invalid field")
 	}
 }
 
+// numericType returns the type of a condition's value for a numericTypes
+// field: DTypeFloat for a decimal, DTypeInt otherwise.
+func numericType(c *QueryCondition) DType {
+	if c.TypedValue().Kind() == LiteralDecimal {
+		return DTypeFloat
+	}
+	return DTypeInt
+}
+
+// tagID returns the id of a tag referenced by number, as in tag.equals(12).
+func tagID(c *QueryCondition) (int64, bool) {
+	value := c.TypedValue()
+	if value.Kind() == LiteralTag {
+		value = inferLiteral(value.String())
+	}
+	return value.Int(), value.Kind() == LiteralInt
+}
+
 func NewQueryAnd(left QueryExpr, right QueryExpr) *QueryBinaryOp {
 	return &QueryBinaryOp{Left: left, Right: right, Operator: OperatorAnd}
 }
@@ -286,14 +431,25 @@ func buildQueryConditionFromMap(m map[string]interface{}) (*QueryCondition, erro
 	if !ok {
 		return nil, errors.New("invalid operator: " + operator + " for field: " + field)
 	}
+	op, err := parseOperator(operator)
+	if err != nil {
+		return nil, err
+	}
+	if typed, ok := m["value"].(map[string]interface{}); ok {
+		data, err := json.Marshal(typed)
+		if err != nil {
+			return nil, err
+		}
+		var literal Literal
+		if err := literal.UnmarshalJSON(data); err != nil {
+			return nil, err
+		}
+		return NewTypedCondition(field, op, literal), nil
+	}
 	value, ok := m["value"].(string)
 	if !ok {
 		return nil, errors.New("invalid value: " + value + " for field: " + field)
 	}
-	op, err := NewOperator(operator)
-	if err != nil {
-		return nil, err
-	}
 	return &QueryCondition{Field: field, Operator: op, Value: value}, nil
 }
 
@@ -319,7 +475,7 @@ func buildQueryBinaryOpFromMap(m map[string]interface{}) (*QueryBinaryOp, error)
 		return nil, errors.New("invalid operator")
 	}
 
-	operator, err := NewOperator(op)
+	operator, err := parseOperator(op)
 	if err != nil {
 		return nil, err
 	}
@@ -339,18 +495,45 @@ func buildQueryUnaryOpFromMap(m map[string]interface{}) (*QueryUnaryOp, error) {
 	if err != nil {
 		return nil, err
 	}
-	op, err := NewOperator(operator)
+	op, err := parseOperator(operator)
 	if err != nil {
 		return nil, err
 	}
 	return &QueryUnaryOp{Operator: op, Operand: operand_expr}, nil
 }
 
+// BuildQueryExprFromMap builds an expression from a decoded JSON object. Maps
+// in the format written by MarshalQuery, with a "type" field on every node,
+// are built by their type; older maps without one are recognised by their
+// fields. A document with a version is unwrapped, but not validated; use
+// UnmarshalQuery for that.
 func BuildQueryExprFromMap(m map[string]interface{}) (QueryExpr, error) {
 	if len(m) == 0 {
 		return nil, errors.New("empty query")
 	}
 
+	if query, ok := m["query"].(map[string]interface{}); ok && m["version"] != nil {
+		return BuildQueryExprFromMap(query)
+	}
+	if nodeType, ok := m["type"]; ok {
+		var expr QueryExpr
+		var err error
+		switch nodeType {
+		case jsonTypeCondition:
+			expr, err = buildQueryConditionFromMap(m)
+		case jsonTypeBinary:
+			expr, err = buildQueryBinaryOpFromMap(m)
+		case jsonTypeUnary:
+			expr, err = buildQueryUnaryOpFromMap(m)
+		default:
+			return nil, fmt.Errorf("unknown node type %v", nodeType)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return expr, nil
+	}
+
 	// check if it's a condition
 	condition, err := buildQueryConditionFromMap(m)
 	if err == nil {
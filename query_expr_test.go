@@ -2,6 +2,8 @@ package ntql
 
 import (
 	"encoding/json"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -61,3 +63,130 @@ func TestQueryExprTag2(t *testing.T) {
 		t.Fatalf("ToSQL() returned %q, expected %q", sql, expected)
 	}
 }
+
+func TestQueryExprParameterized(t *testing.T) {
+	q := NewQueryAnd(
+		NewQueryOr(
+			&QueryCondition{Field: "title", Value: "Bob's café ☕, v2", Operator: OperatorEq},
+			&QueryCondition{Field: "title", Value: "50%_done", Operator: OperatorCnt},
+		),
+		&QueryCondition{Field: "priority", Value: "3", Operator: OperatorGte},
+	)
+	sql, args, err := ToParameterizedSQL(q)
+	if err != nil {
+		t.Fatalf("ToParameterizedSQL() failed: %v", err)
+	}
+	expected := `((title = ? OR title LIKE ? ESCAPE '\') AND priority >= ?)`
+	if sql != expected {
+		t.Fatalf("ToParameterizedSQL() returned %q, expected %q", sql, expected)
+	}
+	expectedArgs := []any{"Bob's café ☕, v2", `%50\%\_done%`, int64(3)}
+	if !reflect.DeepEqual(args, expectedArgs) {
+		t.Fatalf("ToParameterizedSQL() returned args %#v, expected %#v", args, expectedArgs)
+	}
+}
+
+func TestQueryExprParameterizedUnicode(t *testing.T) {
+	expr, err := parseQuery(`title.contains("🎉") OR title.eq("Bob's café \"☕\"")`)
+	if err != nil {
+		t.Fatalf("parsing failed: %v", err)
+	}
+	sql, args, err := ToParameterizedSQL(expr)
+	if err != nil {
+		t.Fatalf("ToParameterizedSQL() failed: %v", err)
+	}
+	expected := `(title LIKE ? ESCAPE '\' OR title = ?)`
+	if sql != expected {
+		t.Fatalf("ToParameterizedSQL() returned %q, expected %q", sql, expected)
+	}
+	expectedArgs := []any{"%🎉%", `Bob's café "☕"`}
+	if !reflect.DeepEqual(args, expectedArgs) {
+		t.Fatalf("ToParameterizedSQL() returned args %#v, expected %#v", args, expectedArgs)
+	}
+}
+
+func TestQueryExprInlineRejectsUnsafeValue(t *testing.T) {
+	q := &QueryCondition{Field: "title", Value: "x' OR '1'='1", Operator: OperatorEq}
+	if _, err := q.ToSQL(); err == nil {
+		t.Fatalf("expected inline SQL to reject value with quotes")
+	}
+	sql, args, err := ToParameterizedSQL(q)
+	if err != nil {
+		t.Fatalf("ToParameterizedSQL() failed: %v", err)
+	}
+	if sql != "title = ?" || len(args) != 1 || args[0] != "x' OR '1'='1" {
+		t.Fatalf("unexpected parameterized output %q %#v", sql, args)
+	}
+}
+
+func TestQueryExprParameterizedTag(t *testing.T) {
+	q := &QueryCondition{Field: "tag", Value: "1", Operator: OperatorEq}
+	sql, args, err := ToParameterizedSQL(q)
+	if err != nil {
+		t.Fatalf("ToParameterizedSQL() failed: %v", err)
+	}
+	expected := "tag_id = (SELECT id FROM atomic_tags WHERE id = ?)"
+	if sql != expected || !reflect.DeepEqual(args, []any{int64(1)}) {
+		t.Fatalf("ToParameterizedSQL() returned %q %#v, expected %q [1]", sql, args, expected)
+	}
+}
+
+func TestQueryExprNumericValues(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected string
+		args     []any
+	}{
+		{"10", "priority >= 10", []any{int64(10)}},
+		{"-3", "priority >= -3", []any{int64(-3)}},
+		{"2.50", "priority >= 2.5", []any{2.5}},
+	}
+	for _, tc := range cases {
+		q := &QueryCondition{Field: "priority", Value: tc.value, Operator: OperatorGte}
+		sql, err := q.ToSQL()
+		if err != nil {
+			t.Fatalf("ToSQL() failed for %s: %v", tc.value, err)
+		}
+		if sql != tc.expected {
+			t.Fatalf("ToSQL() returned %q, expected %q", sql, tc.expected)
+		}
+		_, args, err := ToParameterizedSQL(q)
+		if err != nil {
+			t.Fatalf("ToParameterizedSQL() failed for %s: %v", tc.value, err)
+		}
+		if !reflect.DeepEqual(args, tc.args) {
+			t.Fatalf("ToParameterizedSQL() returned args %#v, expected %#v", args, tc.args)
+		}
+	}
+}
+
+func TestQueryExprNumericRange(t *testing.T) {
+	for _, value := range []string{"9223372036854775808", "1e400", "NaN", "Inf", "0x10"} {
+		q := &QueryCondition{Field: "priority", Value: value, Operator: OperatorEq}
+		if _, err := q.ToSQL(); err == nil {
+			t.Fatalf("expected ToSQL() to reject %s", value)
+		}
+	}
+}
+
+// rawExpr is a QueryExpr defined outside the package's own node types.
+type rawExpr struct{}
+
+func (rawExpr) ToSQL() (string, error) { return "1 = 1", nil }
+func (rawExpr) String() string         { return "raw" }
+
+func TestQueryExprOtherImplementations(t *testing.T) {
+	var expr QueryExpr = NewQueryAnd(rawExpr{}, &QueryCondition{Field: "title", Operator: OperatorEq, Value: "a"})
+	sql, err := expr.ToSQL()
+	if expected := "(1 = 1 AND title = 'a')"; err != nil || sql != expected {
+		t.Fatalf("expected %s, got %s, %v", expected, sql, err)
+	}
+	sql, args, err := ToParameterizedSQL(expr)
+	if expected := "(1 = 1 AND title = ?)"; err != nil || sql != expected || !reflect.DeepEqual(args, []any{"a"}) {
+		t.Fatalf("expected %s with args [a], got %s, %#v, %v", expected, sql, args, err)
+	}
+	sql, err = BuildSQLJoinQuery(expr, JoinQueryOptions{})
+	if err != nil || !strings.Contains(sql, "(1 = 1 AND t0.title = 'a')") {
+		t.Fatalf("expected the node in the join query, got %s, %v", sql, err)
+	}
+}
@@ -3,52 +3,112 @@ package ntql
 import (
 	"errors"
 	"fmt"
-	"regexp"
-	"strconv"
 	"strings"
 )
 
 type JoinQueryOptions struct {
 	Distinct bool
+	// Schema resolves subjects to tables and join paths. The default schema is
+	// used when it is nil.
+	Schema *Schema
+	// Dialect selects the database engine to generate SQL for. GenericDialect
+	// is used when it is nil.
+	Dialect Dialect
+	// BaseTable overrides the schema's root table as the table to select from.
+	BaseTable string
+	// Clock resolves relative dates such as today or startOfWeek.
+	Clock Clock
 }
 
+// BuildSQLJoinQuery builds a complete SELECT statement for expr, joining every
+// table the query's subjects live in. Values are written into the SQL text.
 func BuildSQLJoinQuery(expr QueryExpr, opts JoinQueryOptions) (string, error) {
+	sql, _, err := buildSQLJoinQuery(expr, opts, newSQLWriter(SQLOptions{Dialect: opts.Dialect, Clock: opts.Clock, Schema: opts.Schema}))
+	return sql, err
+}
+
+// BuildParameterizedSQLJoinQuery is like BuildSQLJoinQuery, but writes ?
+// placeholders in place of values and returns the values to bind to them in
+// order.
+func BuildParameterizedSQLJoinQuery(expr QueryExpr, opts JoinQueryOptions) (string, []any, error) {
+	w := newSQLWriter(SQLOptions{Dialect: opts.Dialect, Parameterized: true, Clock: opts.Clock, Schema: opts.Schema})
+	sql, _, err := buildSQLJoinQuery(expr, opts, w)
+	if err != nil {
+		return "", nil, err
+	}
+	return sql, w.args, nil
+}
+
+// buildSQLJoinQuery builds the join query for expr and returns it with the
+// schema joins it uses, either as LEFT JOINs or inside EXISTS subqueries.
+func buildSQLJoinQuery(expr QueryExpr, opts JoinQueryOptions, w *sqlWriter) (string, []SchemaJoin, error) {
 	if expr == nil {
-		return "", errors.New("query expression cannot be nil")
+		return "", nil, errors.New("query expression cannot be nil")
+	}
+	schema := opts.Schema
+	if schema == nil {
+		schema = DefaultSchema()
 	}
-	if len(schemaTables) == 0 {
-		return "", errors.New("schema does not define any tables")
+	if len(schema.tables) == 0 {
+		return "", nil, errors.New("schema does not define any tables")
+	}
+	expr, err := expandLists(expr)
+	if err != nil {
+		return "", nil, err
 	}
 
 	usedTables := map[string]struct{}{}
 	conditionFieldMeta := map[*QueryCondition]subjectFieldMeta{}
-	if err := collectJoinMetadata(expr, usedTables, conditionFieldMeta); err != nil {
-		return "", err
+	if err := collectJoinMetadata(schema, expr, usedTables, conditionFieldMeta); err != nil {
+		return "", nil, err
 	}
 
-	baseTable := selectBaseTable(usedTables)
+	baseTable, err := schema.selectBaseTable(opts.BaseTable)
+	if err != nil {
+		return "", nil, err
+	}
 	aliasByTable := map[string]string{baseTable: "t0"}
 	joinedTables := map[string]struct{}{baseTable: {}}
 	joinClauses := make([]string, 0)
 	nextAliasIndex := 1
 
 	orderedTables := make([]string, 0, len(usedTables))
-	for _, table := range schemaTables {
+	for _, table := range schema.tables {
 		if _, ok := usedTables[table.Name]; ok {
 			orderedTables = append(orderedTables, table.Name)
 		}
 	}
 
 	joinedEdges := map[string]struct{}{}
+	existsPaths := map[string][]joinStep{}
+	usedJoins := []SchemaJoin{}
+	seenJoins := map[string]struct{}{}
 	for _, table := range orderedTables {
 		if table == baseTable {
 			continue
 		}
-		path, err := resolveJoinPath(baseTable, table)
+		path, err := schema.resolveJoinPath(baseTable, table)
 		if err != nil {
-			return "", err
+			return "", nil, err
 		}
+		// Everything from the first to-many step onwards is evaluated in a
+		// correlated EXISTS subquery instead of being joined, so that it cannot
+		// multiply the base rows.
 		for _, step := range path {
+			if _, seen := seenJoins[step.edgeKey()]; !seen {
+				seenJoins[step.edgeKey()] = struct{}{}
+				usedJoins = append(usedJoins, step.join)
+			}
+		}
+		outerPath := path
+		for i, step := range path {
+			if step.toMany {
+				outerPath = path[:i]
+				existsPaths[table] = path[i:]
+				break
+			}
+		}
+		for _, step := range outerPath {
 			if _, ok := aliasByTable[step.leftTable]; !ok {
 				aliasByTable[step.leftTable] = fmt.Sprintf("t%d", nextAliasIndex)
 				nextAliasIndex++
@@ -66,12 +126,12 @@ func BuildSQLJoinQuery(expr QueryExpr, opts JoinQueryOptions) (string, error) {
 			if _, ok := joinedTables[step.rightTable]; !ok {
 				joinClauses = append(joinClauses, fmt.Sprintf(
 					"LEFT JOIN %s %s ON %s.%s = %s.%s",
-					step.rightTable,
+					w.ident(step.rightTable),
 					aliasByTable[step.rightTable],
 					aliasByTable[step.leftTable],
-					step.leftKey,
+					w.ident(step.leftKey),
 					aliasByTable[step.rightTable],
-					step.rightKey,
+					w.ident(step.rightKey),
 				))
 				joinedTables[step.rightTable] = struct{}{}
 				joinedEdges[edgeKey] = struct{}{}
@@ -81,12 +141,12 @@ func BuildSQLJoinQuery(expr QueryExpr, opts JoinQueryOptions) (string, error) {
 			if _, ok := joinedTables[step.leftTable]; !ok {
 				joinClauses = append(joinClauses, fmt.Sprintf(
 					"LEFT JOIN %s %s ON %s.%s = %s.%s",
-					step.leftTable,
+					w.ident(step.leftTable),
 					aliasByTable[step.leftTable],
 					aliasByTable[step.rightTable],
-					step.rightKey,
+					w.ident(step.rightKey),
 					aliasByTable[step.leftTable],
-					step.leftKey,
+					w.ident(step.leftKey),
 				))
 				joinedTables[step.leftTable] = struct{}{}
 			}
@@ -94,22 +154,28 @@ func BuildSQLJoinQuery(expr QueryExpr, opts JoinQueryOptions) (string, error) {
 		}
 	}
 
-	whereSQL, err := buildJoinWhereSQL(expr, conditionFieldMeta, aliasByTable)
+	where := &joinWhereBuilder{
+		w:                  w,
+		conditionFieldMeta: conditionFieldMeta,
+		aliasByTable:       aliasByTable,
+		existsPaths:        existsPaths,
+	}
+	whereSQL, err := where.build(expr)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
 	distinctClause := ""
 	if opts.Distinct {
 		distinctClause = "DISTINCT "
 	}
-	sql := fmt.Sprintf("SELECT %s%s.* FROM %s %s", distinctClause, aliasByTable[baseTable], baseTable, aliasByTable[baseTable])
+	sql := fmt.Sprintf("SELECT %s%s.* FROM %s %s", distinctClause, aliasByTable[baseTable], w.ident(baseTable), aliasByTable[baseTable])
 	if len(joinClauses) > 0 {
 		sql += " " + strings.Join(joinClauses, " ")
 	}
 	sql += " WHERE " + whereSQL
 
-	return sql, nil
+	return sql, usedJoins, nil
 }
 
 type subjectFieldMeta struct {
@@ -118,46 +184,76 @@ type subjectFieldMeta struct {
 	dtype DType
 }
 
-func collectJoinMetadata(expr QueryExpr, usedTables map[string]struct{}, conditionFieldMeta map[*QueryCondition]subjectFieldMeta) error {
-	switch node := expr.(type) {
-	case *QueryCondition:
-		meta, err := resolveSubjectFieldMeta(node.Field)
-		if err != nil {
-			return err
+func collectJoinMetadata(schema *Schema, expr QueryExpr, usedTables map[string]struct{}, conditionFieldMeta map[*QueryCondition]subjectFieldMeta) error {
+	return Walk(expr, VisitorFuncs{EnterFunc: func(expr QueryExpr) error {
+		switch node := expr.(type) {
+		case *QueryCondition:
+			meta, err := schema.resolveSubjectFieldMeta(node)
+			if err != nil {
+				return err
+			}
+			usedTables[meta.table] = struct{}{}
+			conditionFieldMeta[node] = meta
+			return nil
+		case *QueryError:
+			return node.Diagnostic
+		case nil:
+			return errors.New("query expression cannot be nil")
 		}
-		usedTables[meta.table] = struct{}{}
-		conditionFieldMeta[node] = meta
+		// Other nodes, including those defined outside the package, use no
+		// tables.
 		return nil
-	case *QueryBinaryOp:
-		if err := collectJoinMetadata(node.Left, usedTables, conditionFieldMeta); err != nil {
-			return err
+	}})
+}
+
+// selectBaseTable returns the table a join query selects from: the requested
+// table if one is given, otherwise the schema's root table.
+func (s *Schema) selectBaseTable(requested string) (string, error) {
+	if requested == "" {
+		return s.rootTable, nil
+	}
+	for _, table := range s.tables {
+		if toLowerCase(table.Name) == toLowerCase(requested) {
+			return table.Name, nil
 		}
-		return collectJoinMetadata(node.Right, usedTables, conditionFieldMeta)
-	case *QueryUnaryOp:
-		return collectJoinMetadata(node.Operand, usedTables, conditionFieldMeta)
-	default:
-		return errors.New("unsupported query expression node")
 	}
+	return "", fmt.Errorf("base table %s is not defined in schema tables", requested)
 }
 
-func selectBaseTable(usedTables map[string]struct{}) string {
-	for _, table := range schemaTables {
-		if table.Name == "tasks" {
-			return table.Name
-		}
+// toMany reports whether the subject named field lives behind a to-many join
+// from baseTable, or from the root table if baseTable is empty. The join query
+// matches such a subject with an EXISTS subquery for each condition, so NOT
+// tag.equals(a) means no related row is a, while tag.notEquals(a) means some
+// related row is not.
+func (s *Schema) toMany(baseTable, field string) bool {
+	subject, err := s.Subject(field)
+	if err != nil || subject.Table == "" {
+		return false
 	}
-	for _, table := range schemaTables {
-		if _, ok := usedTables[table.Name]; ok {
-			return table.Name
+	base, err := s.selectBaseTable(baseTable)
+	if err != nil {
+		return false
+	}
+	path, err := s.resolveJoinPath(base, subject.Table)
+	if err != nil {
+		return false
+	}
+	for _, step := range path {
+		if step.toMany {
+			return true
 		}
 	}
-	return schemaTables[0].Name
+	return false
 }
 
-func resolveSubjectFieldMeta(field string) (subjectFieldMeta, error) {
-	subject, err := getSubject(field)
+// resolveSubjectFieldMeta returns the column a condition compares against. Its
+// type is the one of the subject's types that the condition's value has, so a
+// subject that takes a date or a date-time is compared as whichever it is given.
+// Tags given by number are compared with the tag table's primary key.
+func (s *Schema) resolveSubjectFieldMeta(c *QueryCondition) (subjectFieldMeta, error) {
+	subject, err := s.Subject(c.Field)
 	if err != nil {
-		return subjectFieldMeta{}, fmt.Errorf("field %s is not defined in schema subjects", field)
+		return subjectFieldMeta{}, fmt.Errorf("field %s is not defined in schema subjects", c.Field)
 	}
 	if subject.Table == "" {
 		return subjectFieldMeta{}, fmt.Errorf("subject %s is missing a table mapping", subject.Name)
@@ -169,10 +265,24 @@ func resolveSubjectFieldMeta(field string) (subjectFieldMeta, error) {
 	if len(subject.ValidTypes) == 0 {
 		return subjectFieldMeta{}, fmt.Errorf("subject %s has no valid types", subject.Name)
 	}
+	// Operators without a verb are checked against the subject's types alone.
+	verb, _ := s.verbFor(subject, c.Operator)
+	dtype, err := s.checkValue(subject, verb, c)
+	if err != nil {
+		return subjectFieldMeta{}, err
+	}
+	// A tag given by number is matched by id, as in where mode.
+	if _, ok := tagID(c); ok && dtype == DTypeTag && (c.Operator == OperatorEq || c.Operator == OperatorNeq) {
+		for _, table := range s.tables {
+			if table.Name == subject.Table {
+				column, dtype = table.PrimaryKey, DTypeInt
+			}
+		}
+	}
 	return subjectFieldMeta{
 		table: subject.Table,
 		field: column,
-		dtype: subject.ValidTypes[0],
+		dtype: dtype,
 	}, nil
 }
 
@@ -181,6 +291,10 @@ type joinStep struct {
 	rightTable string
 	leftKey    string
 	rightKey   string
+	// toMany is set when a single left row can match several right rows.
+	toMany bool
+	// join is the schema join the step traverses.
+	join SchemaJoin
 }
 
 func (s joinStep) edgeKey() string {
@@ -190,7 +304,7 @@ func (s joinStep) edgeKey() string {
 	return fmt.Sprintf("%s.%s:%s.%s", s.rightTable, s.rightKey, s.leftTable, s.leftKey)
 }
 
-func resolveJoinPath(baseTable, targetTable string) ([]joinStep, error) {
+func (s *Schema) resolveJoinPath(baseTable, targetTable string) ([]joinStep, error) {
 	if baseTable == targetTable {
 		return nil, nil
 	}
@@ -206,7 +320,7 @@ func resolveJoinPath(baseTable, targetTable string) ([]joinStep, error) {
 		node := queue[0]
 		queue = queue[1:]
 
-		for _, join := range schemaJoins {
+		for _, join := range s.joins {
 			var next string
 			var step joinStep
 			switch {
@@ -217,6 +331,8 @@ func resolveJoinPath(baseTable, targetTable string) ([]joinStep, error) {
 					rightTable: join.ToTable,
 					leftKey:    join.FromKey,
 					rightKey:   join.ToKey,
+					toMany:     join.Cardinality == CardinalityOneToMany || join.Cardinality == CardinalityManyToMany,
+					join:       join,
 				}
 			case join.ToTable == node.table:
 				next = join.FromTable
@@ -225,6 +341,8 @@ func resolveJoinPath(baseTable, targetTable string) ([]joinStep, error) {
 					rightTable: join.FromTable,
 					leftKey:    join.ToKey,
 					rightKey:   join.FromKey,
+					toMany:     join.Cardinality == CardinalityManyToOne || join.Cardinality == CardinalityManyToMany,
+					join:       join,
 				}
 			default:
 				continue
@@ -245,24 +363,38 @@ func resolveJoinPath(baseTable, targetTable string) ([]joinStep, error) {
 	return nil, fmt.Errorf("no join path found from %s to %s", baseTable, targetTable)
 }
 
-func buildJoinWhereSQL(expr QueryExpr, conditionFieldMeta map[*QueryCondition]subjectFieldMeta, aliasByTable map[string]string) (string, error) {
+// joinWhereBuilder renders the WHERE clause of a join query.
+type joinWhereBuilder struct {
+	w                  *sqlWriter
+	conditionFieldMeta map[*QueryCondition]subjectFieldMeta
+	aliasByTable       map[string]string
+	// existsPaths holds, for tables behind a to-many join, the join steps
+	// that are evaluated inside an EXISTS subquery.
+	existsPaths       map[string][]joinStep
+	nextSubqueryAlias int
+}
+
+func (b *joinWhereBuilder) build(expr QueryExpr) (string, error) {
 	switch node := expr.(type) {
 	case *QueryCondition:
-		meta, ok := conditionFieldMeta[node]
+		meta, ok := b.conditionFieldMeta[node]
 		if !ok {
 			return "", fmt.Errorf("missing metadata for field %s", node.Field)
 		}
-		alias, ok := aliasByTable[meta.table]
+		if steps, ok := b.existsPaths[meta.table]; ok {
+			return b.buildExists(node, meta, steps)
+		}
+		alias, ok := b.aliasByTable[meta.table]
 		if !ok {
 			return "", fmt.Errorf("missing alias for table %s", meta.table)
 		}
-		return joinConditionToSQL(node, fmt.Sprintf("%s.%s", alias, meta.field), meta.dtype)
+		return writeTypedCondition(b.w, node, alias+"."+b.w.ident(meta.field), meta.dtype)
 	case *QueryBinaryOp:
-		left, err := buildJoinWhereSQL(node.Left, conditionFieldMeta, aliasByTable)
+		left, err := b.build(node.Left)
 		if err != nil {
 			return "", err
 		}
-		right, err := buildJoinWhereSQL(node.Right, conditionFieldMeta, aliasByTable)
+		right, err := b.build(node.Right)
 		if err != nil {
 			return "", err
 		}
@@ -272,12 +404,12 @@ func buildJoinWhereSQL(expr QueryExpr, conditionFieldMeta map[*QueryCondition]su
 		case OperatorOr:
 			return "(" + left + " OR " + right + ")", nil
 		case OperatorXor:
-			return "(" + left + " XOR " + right + ")", nil
+			return b.w.dialect.Xor(left, right), nil
 		default:
 			return "", errors.New("invalid operator: " + node.Operator.ToStr())
 		}
 	case *QueryUnaryOp:
-		operand, err := buildJoinWhereSQL(node.Operand, conditionFieldMeta, aliasByTable)
+		operand, err := b.build(node.Operand)
 		if err != nil {
 			return "", err
 		}
@@ -287,70 +419,40 @@ func buildJoinWhereSQL(expr QueryExpr, conditionFieldMeta map[*QueryCondition]su
 		default:
 			return "", errors.New("invalid operator: " + node.Operator.ToStr())
 		}
+	case nil:
+		return "", errors.New("query expression cannot be nil")
 	default:
-		return "", errors.New("unsupported query expression node")
+		// Nodes defined outside the package are written with their own ToSQL.
+		return node.ToSQL()
 	}
 }
 
-func joinConditionToSQL(c *QueryCondition, fieldRef string, dtype DType) (string, error) {
-	switch dtype {
-	case DTypeDate, DTypeDateTime:
-		if !regexp.MustCompile(`^\d{4}-\d{2}-\d{2}(T\d{2}:\d{2}:\d{2}Z)?$`).MatchString(c.Value) {
-			return "", errors.New("invalid value: " + c.Value + " for field: " + c.Field)
-		}
-		switch c.Operator {
-		case OperatorEq:
-			return fieldRef + " = '" + c.Value + "'", nil
-		case OperatorNeq:
-			return fieldRef + " != '" + c.Value + "'", nil
-		case OperatorGt:
-			return fieldRef + " > '" + c.Value + "'", nil
-		case OperatorLT:
-			return fieldRef + " < '" + c.Value + "'", nil
-		case OperatorGte:
-			return fieldRef + " >= '" + c.Value + "'", nil
-		case OperatorLte:
-			return fieldRef + " <= '" + c.Value + "'", nil
-		default:
-			return "", errors.New("invalid operator: " + c.Operator.ToStr() + " for field: " + c.Field)
-		}
-	case DTypeInt:
-		if _, err := strconv.Atoi(c.Value); err != nil {
-			return "", errors.New("invalid value: " + c.Value + " for field: " + c.Field)
-		}
-		switch c.Operator {
-		case OperatorEq:
-			return fieldRef + " = " + c.Value, nil
-		case OperatorNeq:
-			return fieldRef + " != " + c.Value, nil
-		case OperatorGt:
-			return fieldRef + " > " + c.Value, nil
-		case OperatorLT:
-			return fieldRef + " < " + c.Value, nil
-		case OperatorGte:
-			return fieldRef + " >= " + c.Value, nil
-		case OperatorLte:
-			return fieldRef + " <= " + c.Value, nil
-		default:
-			return "", errors.New("invalid operator: " + c.Operator.ToStr() + " for field: " + c.Field)
-		}
-	default:
-		if !regexp.MustCompile(`^[a-zA-Z0-9\-/: ]+$`).MatchString(c.Value) {
-			return "", errors.New("invalid value: " + c.Value + " for field: " + c.Field)
-		}
-		switch c.Operator {
-		case OperatorEq:
-			return fieldRef + " = '" + c.Value + "'", nil
-		case OperatorNeq:
-			return fieldRef + " != '" + c.Value + "'", nil
-		case OperatorCnt:
-			return fieldRef + " LIKE '%" + c.Value + "%'", nil
-		case OperatorSW:
-			return fieldRef + " LIKE '" + c.Value + "%'", nil
-		case OperatorEw:
-			return fieldRef + " LIKE '%" + c.Value + "'", nil
-		default:
-			return "", errors.New("invalid operator: " + c.Operator.ToStr() + " for field: " + c.Field)
-		}
+// buildExists renders a condition on a table behind a to-many join as a
+// correlated EXISTS subquery over the given join steps, so every condition is
+// matched against the related rows independently.
+func (b *joinWhereBuilder) buildExists(c *QueryCondition, meta subjectFieldMeta, steps []joinStep) (string, error) {
+	outerAlias, ok := b.aliasByTable[steps[0].leftTable]
+	if !ok {
+		return "", fmt.Errorf("missing alias for table %s", steps[0].leftTable)
 	}
+
+	alias := b.subqueryAlias()
+	sql := "EXISTS (SELECT 1 FROM " + b.w.ident(steps[0].rightTable) + " " + alias
+	correlation := fmt.Sprintf("%s.%s = %s.%s", alias, b.w.ident(steps[0].rightKey), outerAlias, b.w.ident(steps[0].leftKey))
+	for _, step := range steps[1:] {
+		next := b.subqueryAlias()
+		sql += fmt.Sprintf(" JOIN %s %s ON %s.%s = %s.%s", b.w.ident(step.rightTable), next, alias, b.w.ident(step.leftKey), next, b.w.ident(step.rightKey))
+		alias = next
+	}
+
+	cond, err := writeTypedCondition(b.w, c, alias+"."+b.w.ident(meta.field), meta.dtype)
+	if err != nil {
+		return "", err
+	}
+	return sql + " WHERE " + correlation + " AND " + cond + ")", nil
+}
+
+func (b *joinWhereBuilder) subqueryAlias() string {
+	b.nextSubqueryAlias++
+	return fmt.Sprintf("s%d", b.nextSubqueryAlias)
 }
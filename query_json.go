@@ -0,0 +1,366 @@
+package ntql
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// QueryJSONVersion is the version of the JSON encoding written by
+// MarshalQuery. It changes only if the encoding of a node changes in a way
+// older readers would misread. Version 2 added typed values; UnmarshalQuery
+// still reads version 1 documents.
+const QueryJSONVersion = 2
+
+// Node types written in the "type" field of every encoded QueryExpr.
+const (
+	jsonTypeCondition = "condition"
+	jsonTypeBinary    = "binary"
+	jsonTypeUnary     = "unary"
+	jsonTypeError     = "error"
+)
+
+//go:embed query.schema.json
+var queryJSONSchema []byte
+
+// QueryJSONSchema returns the JSON Schema document describing the encoding
+// written by MarshalQuery.
+func QueryJSONSchema() []byte {
+	return bytes.Clone(queryJSONSchema)
+}
+
+// queryDocument is the envelope written by MarshalQuery.
+type queryDocument struct {
+	Version int             `json:"version"`
+	Query   json.RawMessage `json:"query"`
+}
+
+// MarshalQuery encodes expr as a versioned JSON document:
+//
+//	{"version": 2, "query": {"type": "condition", "field": "priority", "operator": "equals", "value": {"kind": "int", "value": 3}}}
+//
+// Every node has a "type" field: condition, binary, unary or error. The value
+// of a condition with a Literal is encoded as one; see Literal.MarshalJSON.
+// Other values are encoded as plain strings. The format is described by
+// QueryJSONSchema.
+func MarshalQuery(expr QueryExpr) ([]byte, error) {
+	if expr == nil {
+		return nil, errors.New("empty query")
+	}
+	query, err := json.Marshal(expr)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(queryDocument{Version: QueryJSONVersion, Query: query})
+}
+
+// UnmarshalQuery decodes a document written by MarshalQuery and validates it
+// against schema: every field must be a subject, every operator must be one of
+// the subject's verbs, and every value must be one the subject accepts.
+// Aliases are replaced with canonical subject names. Unknown fields, missing
+// fields, error nodes and unsupported versions are rejected.
+func UnmarshalQuery(schema *Schema, data []byte) (QueryExpr, error) {
+	var doc queryDocument
+	if err := decodeStrict(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Version < 1 || doc.Version > QueryJSONVersion {
+		return nil, fmt.Errorf("unsupported query version %d, expected %d", doc.Version, QueryJSONVersion)
+	}
+	if doc.Query == nil {
+		return nil, errors.New("missing query")
+	}
+	expr, err := unmarshalExpr(doc.Query)
+	if err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	if err := validateExpr(schema, expr, "query"); err != nil {
+		return nil, err
+	}
+	return expr, nil
+}
+
+// validateExpr checks the conditions of expr against schema, and replaces
+// subject aliases with canonical names. path locates expr in the document for
+// error messages.
+func validateExpr(schema *Schema, expr QueryExpr, path string) error {
+	switch e := expr.(type) {
+	case *QueryCondition:
+		subject, err := schema.Subject(e.Field)
+		if err != nil {
+			return fmt.Errorf("%s: unknown subject: %s%s", path, e.Field, didYouMean(schema.closestSubjects(e.Field)))
+		}
+		e.Field = subject.Name
+		verb, _, ok := schema.conditionVerb(subject, e.Operator)
+		if !ok {
+			return fmt.Errorf("%s: subject %s has no verb for operator %s", path, subject.Name, e.Operator)
+		}
+		if e.TypedValue().Kind() == LiteralList {
+			expanded, err := expandList(e)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			return validateExpr(schema, expanded, path+".value")
+		}
+		f := &formatter{schema: schema}
+		if _, err := f.value(e); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if _, err := schema.checkValue(subject, verb, e); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		return nil
+	case *QueryBinaryOp:
+		if err := validateExpr(schema, e.Left, path+".left"); err != nil {
+			return err
+		}
+		return validateExpr(schema, e.Right, path+".right")
+	case *QueryUnaryOp:
+		return validateExpr(schema, e.Operand, path+".operand")
+	case *QueryError:
+		return fmt.Errorf("%s: %w", path, e.Diagnostic)
+	}
+	return fmt.Errorf("%s: unknown node %T", path, expr)
+}
+
+// decodeStrict decodes data into v, rejecting unknown fields and trailing
+// data.
+func decodeStrict(data []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if decoder.More() {
+		return errors.New("unexpected data after the JSON value")
+	}
+	return nil
+}
+
+// unmarshalExpr decodes a node of any type, using its "type" field.
+func unmarshalExpr(data []byte) (QueryExpr, error) {
+	var node struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &node); err != nil {
+		return nil, err
+	}
+	var expr interface {
+		QueryExpr
+		json.Unmarshaler
+	}
+	switch node.Type {
+	case jsonTypeCondition:
+		expr = &QueryCondition{}
+	case jsonTypeBinary:
+		expr = &QueryBinaryOp{}
+	case jsonTypeUnary:
+		expr = &QueryUnaryOp{}
+	case jsonTypeError:
+		expr = &QueryError{}
+	case "":
+		return nil, errors.New("missing node type")
+	default:
+		return nil, fmt.Errorf("unknown node type %q", node.Type)
+	}
+	if err := expr.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+	return expr, nil
+}
+
+// checkType reports an error if a node has the wrong type.
+func checkType(got, expected string) error {
+	if got != expected {
+		return fmt.Errorf("expected node type %q, got %q", expected, got)
+	}
+	return nil
+}
+
+type jsonCondition struct {
+	Type     string          `json:"type"`
+	Field    string          `json:"field"`
+	Operator Operator        `json:"operator"`
+	Value    json.RawMessage `json:"value"`
+}
+
+func (q *QueryCondition) MarshalJSON() ([]byte, error) {
+	var value any = q.Value
+	if q.Literal.Kind() != LiteralInvalid && q.Literal.String() == q.Value {
+		value = q.Literal
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonCondition{Type: jsonTypeCondition, Field: q.Field, Operator: q.Operator, Value: data})
+}
+
+func (q *QueryCondition) UnmarshalJSON(data []byte) error {
+	var node jsonCondition
+	if err := decodeStrict(data, &node); err != nil {
+		return err
+	}
+	if err := checkType(node.Type, jsonTypeCondition); err != nil {
+		return err
+	}
+	if node.Field == "" {
+		return errors.New("invalid field")
+	}
+	if !slices.Contains(comparisonOperators, node.Operator) {
+		return errors.New("invalid operator: " + node.Operator.ToStr() + " for field: " + node.Field)
+	}
+	if node.Value == nil || string(node.Value) == "null" {
+		return errors.New("missing value for field: " + node.Field)
+	}
+	var value string
+	if err := json.Unmarshal(node.Value, &value); err == nil {
+		*q = QueryCondition{Field: node.Field, Operator: node.Operator, Value: value}
+		return nil
+	}
+	var literal Literal
+	if err := literal.UnmarshalJSON(node.Value); err != nil {
+		return fmt.Errorf("invalid value for field: %s: %w", node.Field, err)
+	}
+	*q = *NewTypedCondition(node.Field, node.Operator, literal)
+	return nil
+}
+
+type jsonBinaryOp struct {
+	Type     string          `json:"type"`
+	Operator Operator        `json:"op"`
+	Left     json.RawMessage `json:"left"`
+	Right    json.RawMessage `json:"right"`
+}
+
+func (q *QueryBinaryOp) MarshalJSON() ([]byte, error) {
+	left, err := marshalOperand(q.Left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := marshalOperand(q.Right)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonBinaryOp{Type: jsonTypeBinary, Operator: q.Operator, Left: left, Right: right})
+}
+
+func (q *QueryBinaryOp) UnmarshalJSON(data []byte) error {
+	var node jsonBinaryOp
+	if err := decodeStrict(data, &node); err != nil {
+		return err
+	}
+	if err := checkType(node.Type, jsonTypeBinary); err != nil {
+		return err
+	}
+	if !slices.Contains(binaryOperators, node.Operator) {
+		return errors.New("invalid operator: " + node.Operator.ToStr())
+	}
+	if node.Left == nil {
+		return errors.New("invalid left operand")
+	}
+	if node.Right == nil {
+		return errors.New("invalid right operand")
+	}
+	left, err := unmarshalExpr(node.Left)
+	if err != nil {
+		return fmt.Errorf("left: %w", err)
+	}
+	right, err := unmarshalExpr(node.Right)
+	if err != nil {
+		return fmt.Errorf("right: %w", err)
+	}
+	*q = QueryBinaryOp{Left: left, Right: right, Operator: node.Operator}
+	return nil
+}
+
+type jsonUnaryOp struct {
+	Type     string          `json:"type"`
+	Operator Operator        `json:"operator"`
+	Operand  json.RawMessage `json:"operand"`
+}
+
+func (q *QueryUnaryOp) MarshalJSON() ([]byte, error) {
+	operand, err := marshalOperand(q.Operand)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonUnaryOp{Type: jsonTypeUnary, Operator: q.Operator, Operand: operand})
+}
+
+func (q *QueryUnaryOp) UnmarshalJSON(data []byte) error {
+	var node jsonUnaryOp
+	if err := decodeStrict(data, &node); err != nil {
+		return err
+	}
+	if err := checkType(node.Type, jsonTypeUnary); err != nil {
+		return err
+	}
+	if node.Operator != OperatorNot {
+		return errors.New("invalid operator: " + node.Operator.ToStr())
+	}
+	if node.Operand == nil {
+		return errors.New("invalid operand")
+	}
+	operand, err := unmarshalExpr(node.Operand)
+	if err != nil {
+		return fmt.Errorf("operand: %w", err)
+	}
+	*q = QueryUnaryOp{Operand: operand, Operator: node.Operator}
+	return nil
+}
+
+type jsonError struct {
+	Type    string `json:"type"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Start   int    `json:"start"`
+	End     int    `json:"end"`
+}
+
+func (q *QueryError) MarshalJSON() ([]byte, error) {
+	d := q.Diagnostic
+	return json.Marshal(jsonError{Type: jsonTypeError, Code: d.Code.String(), Message: d.Message, Start: d.Start, End: d.End})
+}
+
+func (q *QueryError) UnmarshalJSON(data []byte) error {
+	var node jsonError
+	if err := decodeStrict(data, &node); err != nil {
+		return err
+	}
+	if err := checkType(node.Type, jsonTypeError); err != nil {
+		return err
+	}
+	var code int
+	if _, err := fmt.Sscanf(node.Code, "NTQL%03d", &code); err != nil {
+		return fmt.Errorf("invalid diagnostic code %q", node.Code)
+	}
+	*q = QueryError{Diagnostic: Diagnostic{Code: DiagnosticCode(code), Message: node.Message, Start: node.Start, End: node.End}}
+	return nil
+}
+
+// marshalOperand encodes an operand, which must not be nil.
+func marshalOperand(expr QueryExpr) (json.RawMessage, error) {
+	if expr == nil {
+		return nil, errors.New("invalid operand")
+	}
+	return json.Marshal(expr)
+}
+
+var (
+	binaryOperators     = []Operator{OperatorAnd, OperatorOr, OperatorXor}
+	comparisonOperators = []Operator{OperatorEq, OperatorNeq, OperatorGt, OperatorLT, OperatorGte, OperatorLte, OperatorCnt, OperatorSW, OperatorEw}
+)
+
+// parseOperator returns the operator named s, as written by MarshalJSON or
+// accepted by NewOperator.
+func parseOperator(s string) (Operator, error) {
+	op := Operator(s)
+	if slices.Contains(binaryOperators, op) || slices.Contains(comparisonOperators, op) || op == OperatorNot {
+		return op, nil
+	}
+	return NewOperator(s)
+}
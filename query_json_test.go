@@ -0,0 +1,232 @@
+package ntql
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMarshalQuery(t *testing.T) {
+	expr := NewQueryAnd(
+		&QueryCondition{Field: "title", Operator: OperatorCnt, Value: "foo"},
+		NewQueryNot(&QueryCondition{Field: "status", Operator: OperatorEq, Value: "done"}),
+	)
+	data, err := MarshalQuery(expr)
+	if err != nil {
+		t.Fatalf("MarshalQuery() failed: %v", err)
+	}
+	expected := `{"version":2,"query":{"type":"binary","op":"AND",` +
+		`"left":{"type":"condition","field":"title","operator":"contains","value":"foo"},` +
+		`"right":{"type":"unary","operator":"NOT","operand":{"type":"condition","field":"status","operator":"equals","value":"done"}}}}`
+	if string(data) != expected {
+		t.Fatalf("expected %s, got %s", expected, data)
+	}
+
+	decoded, err := UnmarshalQuery(DefaultSchema(), data)
+	if err != nil {
+		t.Fatalf("UnmarshalQuery() failed: %v", err)
+	}
+	if decoded.String() != expr.String() {
+		t.Fatalf("expected %s, got %s", expr, decoded)
+	}
+}
+
+func TestMarshalQueryRoundTrip(t *testing.T) {
+	queries := []string{
+		`title.startswith("a" AND !("b" OR "c"))`,
+		`(priority.eq(1) OR tag.eq(school)) AND due.before(today)`,
+		`title.eq("c\"\\runch")`,
+	}
+	for _, query := range queries {
+		expr, err := parseQuery(query)
+		if err != nil {
+			t.Fatalf("failed to parse %s: %v", query, err)
+		}
+		data, err := MarshalQuery(expr)
+		if err != nil {
+			t.Fatalf("MarshalQuery(%s) failed: %v", query, err)
+		}
+		decoded, err := UnmarshalQuery(DefaultSchema(), data)
+		if err != nil {
+			t.Fatalf("UnmarshalQuery(%s) failed: %v", data, err)
+		}
+		if decoded.String() != expr.String() {
+			t.Errorf("expected %s, got %s", expr, decoded)
+		}
+	}
+
+	// Error nodes are encoded but not accepted as a valid query.
+	expr, _ := ParseWithRecovery(DefaultSchema(), `titel.eq("a") OR title.eq("b")`)
+	data, err := MarshalQuery(expr)
+	if err != nil {
+		t.Fatalf("MarshalQuery() failed: %v", err)
+	}
+	if !strings.Contains(string(data), `{"type":"error","code":"NTQL003","message":"Invalid subject: titel (did you mean title?)","start":0,"end":5}`) {
+		t.Fatalf("unexpected encoding of an error node: %s", data)
+	}
+	var node QueryBinaryOp
+	if err := json.Unmarshal(data[len(`{"version":2,"query":`):len(data)-1], &node); err != nil {
+		t.Fatalf("json.Unmarshal() failed: %v", err)
+	}
+	if e, ok := node.Left.(*QueryError); !ok || e.Diagnostic.Code != CodeUnknownSubject {
+		t.Fatalf("expected the error node to be decoded, got %#v", node.Left)
+	}
+	if _, err := UnmarshalQuery(DefaultSchema(), data); err == nil {
+		t.Fatalf("expected UnmarshalQuery to reject error nodes")
+	}
+}
+
+func TestUnmarshalQueryValidation(t *testing.T) {
+	condition := func(field, operator, value string) string {
+		return `{"version":1,"query":{"type":"condition","field":"` + field + `","operator":"` + operator + `","value":"` + value + `"}}`
+	}
+	cases := []struct {
+		data  string
+		error string
+	}{
+		{`{"version":3,"query":{"type":"condition","field":"title","operator":"equals","value":"a"}}`, "unsupported query version 3"},
+		{`{"query":{"type":"condition","field":"title","operator":"equals","value":"a"}}`, "unsupported query version 0"},
+		{`{"version":1}`, "missing query"},
+		{`{"version":1,"query":{"field":"title","operator":"equals","value":"a"}}`, "missing node type"},
+		{`{"version":1,"query":{"type":"leaf","field":"title","operator":"equals","value":"a"}}`, `unknown node type "leaf"`},
+		{`{"version":1,"query":{"type":"condition","field":"title","operator":"equals","value":"a","extra":1}}`, `unknown field "extra"`},
+		{`{"version":1,"query":{"type":"condition","field":"title","operator":"equals"}}`, "missing value"},
+		{`{"version":1,"query":{"type":"binary","op":"AND","left":{"type":"condition","field":"title","operator":"equals","value":"a"}}}`, "invalid right operand"},
+		{`{"version":1,"query":{"type":"unary","operator":"AND","operand":{"type":"condition","field":"title","operator":"equals","value":"a"}}}`, "invalid operator: AND"},
+		{condition("title", "like", "a"), "invalid operator: like"},
+		{condition("titel", "equals", "a"), "query: unknown subject: titel (did you mean title?)"},
+		{condition("due", "contains", "a"), "subject due has no verb for operator contains"},
+		{condition("priority", "equals", "high"), "invalid value: high for field: priority"},
+		{`{"version":1,"query":{"type":"binary","op":"OR","left":` +
+			`{"type":"condition","field":"title","operator":"equals","value":"a"},"right":` +
+			`{"type":"condition","field":"color","operator":"equals","value":"red"}}}`, "query.right: unknown subject: color"},
+		{condition("title", "equals", "a") + `{}`, "unexpected data"},
+	}
+	for _, tc := range cases {
+		_, err := UnmarshalQuery(DefaultSchema(), []byte(tc.data))
+		if err == nil || !strings.Contains(err.Error(), tc.error) {
+			t.Errorf("expected an error containing %q for %s, got %v", tc.error, tc.data, err)
+		}
+	}
+
+	// Aliases are replaced with the canonical subject name.
+	expr, err := UnmarshalQuery(DefaultSchema(), []byte(condition("name", "equals", "a")))
+	if err != nil {
+		t.Fatalf("UnmarshalQuery() failed: %v", err)
+	}
+	if c := expr.(*QueryCondition); c.Field != "title" {
+		t.Fatalf("expected the alias to be replaced, got %s", c.Field)
+	}
+}
+
+func TestMarshalTypedQuery(t *testing.T) {
+	expr, err := parseQuery(`priority.eq(3) AND due.before(2024-01-08) AND title.eq("42")`)
+	if err != nil {
+		t.Fatalf("parsing failed: %v", err)
+	}
+	data, err := MarshalQuery(expr)
+	if err != nil {
+		t.Fatalf("MarshalQuery() failed: %v", err)
+	}
+	for _, value := range []string{`{"kind":"int","value":3}`, `{"kind":"date","value":"2024-01-08"}`, `{"kind":"string","value":"42"}`} {
+		if !strings.Contains(string(data), value) {
+			t.Fatalf("expected %s in %s", value, data)
+		}
+	}
+	decoded, err := UnmarshalQuery(DefaultSchema(), data)
+	if err != nil {
+		t.Fatalf("UnmarshalQuery() failed: %v", err)
+	}
+	var kinds []LiteralKind
+	Inspect(decoded, func(expr QueryExpr) bool {
+		if c, ok := expr.(*QueryCondition); ok {
+			kinds = append(kinds, c.Literal.Kind())
+		}
+		return true
+	})
+	if expected := []LiteralKind{LiteralInt, LiteralDate, LiteralString}; !slices.Equal(kinds, expected) {
+		t.Fatalf("expected the kinds %v, got %v", expected, kinds)
+	}
+
+	condition := func(field, operator, value string) string {
+		return `{"version":2,"query":{"type":"condition","field":"` + field + `","operator":"` + operator + `","value":` + value + `}}`
+	}
+	cases := []struct {
+		data  string
+		error string
+	}{
+		{condition("priority", "equals", `{"kind":"string","value":"3"}`), "invalid value: 3 for field: priority"},
+		{condition("priority", "equals", `{"kind":"int","value":"3"}`), "invalid value for field: priority"},
+		{condition("priority", "equals", `{"kind":"list","value":[{"kind":"int","value":1},{"kind":"string","value":"a"}]}`), "query.value.right: invalid value: a"},
+		{condition("priority", "equals", `{"kind":"list","value":[]}`), "empty list"},
+		{condition("priority", "equals", `null`), "missing value"},
+	}
+	for _, tc := range cases {
+		_, err := UnmarshalQuery(DefaultSchema(), []byte(tc.data))
+		if err == nil || !strings.Contains(err.Error(), tc.error) {
+			t.Errorf("expected an error containing %q for %s, got %v", tc.error, tc.data, err)
+		}
+	}
+	list, err := UnmarshalQuery(DefaultSchema(), []byte(condition("priority", "equals", `{"kind":"list","value":[{"kind":"int","value":1},{"kind":"int","value":2}]}`)))
+	if err != nil {
+		t.Fatalf("UnmarshalQuery() failed for a list: %v", err)
+	}
+	if c := list.(*QueryCondition); c.Literal.Kind() != LiteralList || len(c.Literal.Items()) != 2 {
+		t.Fatalf("expected a list of two items, got %#v", c.Literal)
+	}
+}
+
+func TestBuildQueryExprFromTypedMap(t *testing.T) {
+	data, err := MarshalQuery(NewQueryOr(
+		&QueryCondition{Field: "title", Operator: OperatorSW, Value: "a"},
+		NewQueryNot(&QueryCondition{Field: "priority", Operator: OperatorLT, Value: "3"}),
+	))
+	if err != nil {
+		t.Fatalf("MarshalQuery() failed: %v", err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatalf("json.Unmarshal() failed: %v", err)
+	}
+	expr, err := BuildQueryExprFromMap(m)
+	if err != nil {
+		t.Fatalf("BuildQueryExprFromMap() failed: %v", err)
+	}
+	if expected := "title startsWith a OR NOT priority lessThan 3"; expr.String() != expected {
+		t.Fatalf("expected %s, got %s", expected, expr)
+	}
+
+	if _, err := BuildQueryExprFromMap(map[string]interface{}{"type": "leaf"}); err == nil {
+		t.Fatalf("expected an error for an unknown node type")
+	}
+	typed := map[string]interface{}{"type": "condition", "field": "priority", "operator": "equals", "value": map[string]interface{}{"kind": "int", "value": 3.0}}
+	if expr, err := BuildQueryExprFromMap(typed); err != nil || expr.(*QueryCondition).Literal.Int() != 3 {
+		t.Fatalf("unexpected result for a typed value: %v, %v", expr, err)
+	}
+	// Maps without types are still recognised by their fields.
+	legacy := map[string]interface{}{"field": "title", "operator": "equals", "value": "a"}
+	if expr, err := BuildQueryExprFromMap(legacy); err != nil || expr.String() != "title equals a" {
+		t.Fatalf("unexpected result for a legacy map: %v, %v", expr, err)
+	}
+}
+
+func TestQueryJSONSchema(t *testing.T) {
+	var schema struct {
+		Defs struct {
+			Condition struct {
+				Properties struct {
+					Operator struct {
+						Enum []Operator `json:"enum"`
+					} `json:"operator"`
+				} `json:"properties"`
+			} `json:"condition"`
+		} `json:"$defs"`
+	}
+	if err := json.Unmarshal(QueryJSONSchema(), &schema); err != nil {
+		t.Fatalf("invalid JSON Schema: %v", err)
+	}
+	if !slices.Equal(schema.Defs.Condition.Properties.Operator.Enum, comparisonOperators) {
+		t.Fatalf("expected the operators %v, got %v", comparisonOperators, schema.Defs.Condition.Properties.Operator.Enum)
+	}
+}
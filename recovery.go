@@ -0,0 +1,176 @@
+package ntql
+
+import (
+	"slices"
+)
+
+// recoveryTokenTypes are expected after a lexeme that could not be lexed, so
+// lexing resumes at the next connector, parenthesis or subject.
+var recoveryTokenTypes = []TokenType{TokenAnd, TokenOr, TokenRParen, TokenLParen, TokenBang, TokenDot, TokenSubject}
+
+// allDTypes lets the arguments of an unknown subject lex as any value.
+var allDTypes = []DType{DTypeString, DTypeInt, DTypeFloat, DTypeDateTime, DTypeDate, DTypeTag}
+
+// ParseWithRecovery lexes and parses query against schema without stopping at
+// the first error. It returns the parts of the query that could be parsed,
+// with a QueryError node in place of each function call that could not, and
+// every problem found, ordered by position. The expression is nil if the
+// query contains no tokens.
+func ParseWithRecovery(schema *Schema, query string) (QueryExpr, []Diagnostic) {
+	tokens, lexDiagnostics := NewLexerWithSchema(schema, query).LexWithRecovery()
+	if len(tokens) == 0 {
+		return nil, lexDiagnostics
+	}
+	expr, parseDiagnostics := NewParserWithSchema(schema, tokens).ParseWithRecovery()
+	return expr, mergeDiagnostics(lexDiagnostics, parseDiagnostics)
+}
+
+// LexWithRecovery lexes the whole input, skipping lexemes that cannot be
+// lexed instead of stopping at them. Skipped lexemes are kept as TokenInvalid
+// tokens, and a diagnostic is returned for each problem.
+func (t *Lexer) LexWithRecovery() ([]Token, []Diagnostic) {
+	var diagnostics []Diagnostic
+	for !t.atEnd() {
+		err := t.ScanToken()
+		if err == nil {
+			continue
+		}
+		if _, ok := err.(ErrEndOfInput); ok {
+			break
+		}
+		d, ok := t.recoverFrom(err)
+		diagnostics = append(diagnostics, d)
+		if !ok {
+			break
+		}
+	}
+	return t.Tokens, diagnostics
+}
+
+// recoverFrom moves the lexer past the input that caused err, so lexing can
+// continue, and returns the diagnostic for it. It reports false for errors it
+// cannot recover from.
+func (t *Lexer) recoverFrom(err error) (Diagnostic, bool) {
+	switch e := err.(type) {
+	case ErrInvalidLexeme:
+		t.Scanner.Pos = e.End
+		if err := t.Scanner.skipWhitespace(); err != nil {
+			return e.Diagnostic(), false
+		}
+		return e.Diagnostic(), true
+	case ErrInvalidSubject:
+		// The subject token has been added, so only its values are unknown.
+		t.ExpectedDataTypes = allDTypes
+		return e.Diagnostic(), true
+	case ErrInvalidToken:
+		t.Tokens = append(t.Tokens, createToken(string(e.Lexeme), TokenInvalid, e.Position, e.End))
+		t.ExpectedTokens = recoveryTokenTypes
+		return e.Diagnostic(), true
+	}
+	pos := t.Scanner.Pos
+	return Diagnostic{Code: CodeUnknown, Message: err.Error(), Start: pos, End: pos}, false
+}
+
+// ParseWithRecovery parses the tokens without stopping at the first error.
+// Each function call that cannot be parsed is replaced with a QueryError node,
+// and parsing resumes at the next AND, OR or closing parenthesis outside the
+// call. Errors at TokenInvalid tokens are not reported again, since the lexer
+// already reported them.
+func (p *Parser) ParseWithRecovery() (QueryExpr, []Diagnostic) {
+	p.recovering = true
+	p.diagnostics = nil
+	defer func() { p.recovering = false }()
+
+	expr, err := p.Query()
+	if err != nil {
+		expr = p.recoverFrom(err, 0)
+	}
+	for p.Pos < len(p.Tokens) {
+		if p.Tokens[p.Pos].Kind != TokenInvalid {
+			p.report(p.errorAtCurrent(CodeUnexpectedToken, "Unexpected token: "+p.Tokens[p.Pos].String(), TokenAnd, TokenOr).Diagnostic())
+		}
+		p.advance()
+		if p.match(TokenAnd) || p.match(TokenOr) {
+			op := p.previous().Kind
+			right, err := p.Query()
+			if err != nil {
+				right = p.recoverFrom(err, p.Pos)
+			}
+			if op == TokenAnd {
+				expr = NewQueryAnd(expr, right)
+			} else {
+				expr = NewQueryOr(expr, right)
+			}
+		}
+	}
+	return expr, p.diagnostics
+}
+
+func (p *Parser) report(d Diagnostic) {
+	p.diagnostics = append(p.diagnostics, d)
+}
+
+// recoverFrom records err for the function call that started at token start
+// and skips the rest of the call. It stops at the next AND or OR outside the
+// call's parentheses, or at a closing parenthesis that belongs to an
+// enclosing group.
+func (p *Parser) recoverFrom(err error, start int) *QueryError {
+	d, ok := DiagnosticOf(err)
+	if !ok {
+		d = Diagnostic{Code: CodeUnknown, Message: err.Error()}
+		if start < len(p.Tokens) {
+			d.Start = p.Tokens[start].Position
+			d.End = p.Tokens[start].End
+			if p.Pos > start {
+				d.End = p.previous().End
+			}
+		}
+	}
+	if p.Pos >= len(p.Tokens) || p.Tokens[p.Pos].Kind != TokenInvalid {
+		p.report(d)
+	}
+
+	depth := 0
+	for _, tok := range p.Tokens[start:min(p.Pos, len(p.Tokens))] {
+		switch tok.Kind {
+		case TokenLParen:
+			depth++
+		case TokenRParen:
+			depth--
+		}
+	}
+	for p.Pos < len(p.Tokens) {
+		switch p.Tokens[p.Pos].Kind {
+		case TokenLParen:
+			depth++
+		case TokenRParen:
+			if depth <= 0 {
+				return &QueryError{Diagnostic: d}
+			}
+			depth--
+		case TokenAnd, TokenOr:
+			if depth <= 0 {
+				return &QueryError{Diagnostic: d}
+			}
+		}
+		p.advance()
+	}
+	return &QueryError{Diagnostic: d}
+}
+
+// mergeDiagnostics combines lexer and parser diagnostics, ordered by position,
+// dropping parser diagnostics that repeat one from the lexer.
+func mergeDiagnostics(lexed, parsed []Diagnostic) []Diagnostic {
+	merged := append([]Diagnostic{}, lexed...)
+	for _, d := range parsed {
+		if !slices.ContainsFunc(lexed, func(l Diagnostic) bool {
+			return l.Code == d.Code && l.Start == d.Start && l.End == d.End
+		}) {
+			merged = append(merged, d)
+		}
+	}
+	slices.SortStableFunc(merged, func(a, b Diagnostic) int {
+		return a.Start - b.Start
+	})
+	return merged
+}
@@ -0,0 +1,89 @@
+package ntql
+
+import (
+	"testing"
+)
+
+func TestParseWithRecoveryReportsAllErrors(t *testing.T) {
+	query := `titel.contains("a") AND due.before(today) OR staus.equals("open")`
+	expr, diagnostics := ParseWithRecovery(DefaultSchema(), query)
+	if len(diagnostics) != 2 {
+		t.Fatalf("expected 2 diagnostics, got %v", diagnostics)
+	}
+	for i, span := range []string{"titel", "staus"} {
+		d := diagnostics[i]
+		if d.Code != CodeUnknownSubject || query[d.Start:d.End] != span {
+			t.Errorf("expected unknown subject %q, got %s %q", span, d.Code, query[d.Start:d.End])
+		}
+	}
+
+	or, ok := expr.(*QueryBinaryOp)
+	if !ok || or.Operator != OperatorOr {
+		t.Fatalf("expected an OR at the root, got %s", expr)
+	}
+	and, ok := or.Left.(*QueryBinaryOp)
+	if !ok || and.Operator != OperatorAnd {
+		t.Fatalf("expected an AND on the left, got %s", or.Left)
+	}
+	if _, ok := and.Left.(*QueryError); !ok {
+		t.Fatalf("expected an error node for titel, got %s", and.Left)
+	}
+	if cond, ok := and.Right.(*QueryCondition); !ok || cond.Field != "due" || cond.Value != "today" {
+		t.Fatalf("expected the due condition to be parsed, got %s", and.Right)
+	}
+	if _, ok := or.Right.(*QueryError); !ok {
+		t.Fatalf("expected an error node for staus, got %s", or.Right)
+	}
+	if _, err := expr.ToSQL(); err == nil {
+		t.Fatalf("expected ToSQL() to fail on a query with error nodes")
+	}
+}
+
+func TestParseWithRecoverySkipsInsideCalls(t *testing.T) {
+	query := `(priority.gte(1.5) AND title.contains("x")) AND title.contans("y" OR "z")`
+	expr, diagnostics := ParseWithRecovery(DefaultSchema(), query)
+	if len(diagnostics) != 2 {
+		t.Fatalf("expected 2 diagnostics, got %v", diagnostics)
+	}
+	if d := diagnostics[0]; d.Code != CodeTypeMismatch || query[d.Start:d.End] != "1.5" {
+		t.Errorf("expected a type mismatch at 1.5, got %s %q", d.Code, query[d.Start:d.End])
+	}
+	if d := diagnostics[1]; d.Code != CodeUnknownVerb || query[d.Start:d.End] != "contans" {
+		t.Errorf("expected unknown verb contans, got %s %q", d.Code, query[d.Start:d.End])
+	}
+
+	and, ok := expr.(*QueryBinaryOp)
+	if !ok {
+		t.Fatalf("expected a binary operation at the root, got %s", expr)
+	}
+	group, ok := and.Left.(*QueryBinaryOp)
+	if !ok {
+		t.Fatalf("expected the group to be parsed, got %s", and.Left)
+	}
+	if cond, ok := group.Right.(*QueryCondition); !ok || cond.Value != "x" {
+		t.Fatalf("expected the title condition inside the group, got %s", group.Right)
+	}
+}
+
+func TestParseWithRecoveryIncompleteQuery(t *testing.T) {
+	for _, query := range []string{`title.contains("a") AND`, `(title.contains("a")`, `title.contains("a"))`, `&`} {
+		_, diagnostics := ParseWithRecovery(DefaultSchema(), query)
+		if len(diagnostics) != 1 {
+			t.Errorf("expected 1 diagnostic for %s, got %v", query, diagnostics)
+		}
+	}
+	if expr, diagnostics := ParseWithRecovery(DefaultSchema(), "  "); expr != nil || diagnostics != nil {
+		t.Fatalf("expected nothing for an empty query, got %v %v", expr, diagnostics)
+	}
+}
+
+func TestCompletionAfterEarlierError(t *testing.T) {
+	engine := NewCompletionEngine([]string{"school", "work"})
+	suggestions, err := engine.Suggest(`titel.contains("a") AND tag.equals(sch`)
+	if err != nil {
+		t.Fatalf("Suggest() failed: %v", err)
+	}
+	if len(suggestions) == 0 || suggestions[0] != "school" {
+		t.Fatalf("expected school to be suggested, got %v", suggestions)
+	}
+}
@@ -0,0 +1,118 @@
+package ntql
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// relativeDateRegexp matches relative date values such as today, now-12h,
+// startOfWeek or endOfMonth+1d. Keywords are matched ignoring case.
+var relativeDateRegexp = regexp.MustCompile(`^(?i)(today|now|yesterday|tomorrow|startofweek|endofweek|startofmonth|endofmonth|startofyear|endofyear)(([+-])([0-9]+)([hdwmy]))?$`)
+
+// Clock supplies the current time and time zone used to resolve relative
+// dates such as today or startOfWeek. The zero value uses time.Now and the
+// location of the time it returns.
+type Clock struct {
+	// Now returns the current time. time.Now is used when it is nil.
+	Now func() time.Time
+	// Location is the time zone that decides when a day, week, month or year
+	// starts. The location of Now's result is used when it is nil.
+	Location *time.Location
+}
+
+func (c Clock) now() time.Time {
+	now := time.Now
+	if c.Now != nil {
+		now = c.Now
+	}
+	t := now()
+	if c.Location != nil {
+		t = t.In(c.Location)
+	}
+	return t
+}
+
+// isRelativeDate reports whether s is a relative date value.
+func isRelativeDate(s string) bool {
+	return relativeDateRegexp.MatchString(s)
+}
+
+// resolveRelativeDate resolves a relative date value against clock. now and
+// values with an hour offset resolve to an instant of type DTypeDateTime; all
+// other values resolve to midnight of a calendar day in the clock's time zone,
+// with type DTypeDate. Weeks start on Monday, and endOf values name the last
+// day of the period.
+func resolveRelativeDate(s string, clock Clock) (time.Time, DType, error) {
+	m := relativeDateRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return time.Time{}, DTypeDate, errors.New("invalid relative date: " + s)
+	}
+
+	now := clock.now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	var t time.Time
+	dtype := DTypeDate
+	switch strings.ToLower(m[1]) {
+	case "now":
+		t, dtype = now, DTypeDateTime
+	case "today":
+		t = today
+	case "yesterday":
+		t = today.AddDate(0, 0, -1)
+	case "tomorrow":
+		t = today.AddDate(0, 0, 1)
+	case "startofweek":
+		t = today.AddDate(0, 0, -daysSinceMonday(today))
+	case "endofweek":
+		t = today.AddDate(0, 0, 6-daysSinceMonday(today))
+	case "startofmonth":
+		t = time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, today.Location())
+	case "endofmonth":
+		t = time.Date(today.Year(), today.Month()+1, 0, 0, 0, 0, 0, today.Location())
+	case "startofyear":
+		t = time.Date(today.Year(), time.January, 1, 0, 0, 0, 0, today.Location())
+	case "endofyear":
+		t = time.Date(today.Year(), time.December, 31, 0, 0, 0, 0, today.Location())
+	}
+
+	if m[2] == "" {
+		return t, dtype, nil
+	}
+	n, err := strconv.Atoi(m[4])
+	if err != nil {
+		return time.Time{}, DTypeDate, errors.New("invalid relative date: " + s)
+	}
+	if m[3] == "-" {
+		n = -n
+	}
+	switch m[5] {
+	case "h":
+		t, dtype = t.Add(time.Duration(n)*time.Hour), DTypeDateTime
+	case "d":
+		t = t.AddDate(0, 0, n)
+	case "w":
+		t = t.AddDate(0, 0, 7*n)
+	case "m":
+		t = t.AddDate(0, n, 0)
+	case "y":
+		t = t.AddDate(n, 0, 0)
+	}
+	return t, dtype, nil
+}
+
+func daysSinceMonday(t time.Time) int {
+	return (int(t.Weekday()) + 6) % 7
+}
+
+// formatResolvedDate formats a resolved relative date the way a literal of
+// the same type is written: dates as YYYY-MM-DD in the clock's time zone and
+// date-times as UTC.
+func formatResolvedDate(t time.Time, dtype DType) string {
+	if dtype == DTypeDateTime {
+		return t.UTC().Format("2006-01-02T15:04:05Z")
+	}
+	return t.Format(time.DateOnly)
+}
@@ -0,0 +1,99 @@
+package ntql
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fixedClock returns a clock set to Wednesday 2026-01-14 10:30 in New York.
+func fixedClock(t *testing.T) Clock {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone data not available: %v", err)
+	}
+	now := time.Date(2026, 1, 14, 10, 30, 0, 0, loc)
+	return Clock{Now: func() time.Time { return now }, Location: loc}
+}
+
+func TestResolveRelativeDate(t *testing.T) {
+	clock := fixedClock(t)
+	cases := []struct {
+		value    string
+		expected string
+	}{
+		{"today", "2026-01-14"},
+		{"Yesterday", "2026-01-13"},
+		{"tomorrow", "2026-01-15"},
+		{"today-7d", "2026-01-07"},
+		{"startOfWeek", "2026-01-12"},
+		{"endOfWeek", "2026-01-18"},
+		{"startOfMonth+1w", "2026-01-08"},
+		{"endOfMonth+1d", "2026-02-01"},
+		{"startOfYear-1y", "2025-01-01"},
+		{"endOfYear", "2026-12-31"},
+		{"now", "2026-01-14T15:30:00Z"},
+		{"now-2h", "2026-01-14T13:30:00Z"},
+	}
+	for _, tc := range cases {
+		resolved, dtype, err := resolveRelativeDate(tc.value, clock)
+		if err != nil {
+			t.Fatalf("resolveRelativeDate(%s) failed: %v", tc.value, err)
+		}
+		if got := formatResolvedDate(resolved, dtype); got != tc.expected {
+			t.Fatalf("resolveRelativeDate(%s) returned %s, expected %s", tc.value, got, tc.expected)
+		}
+	}
+}
+
+func TestRelativeDateUsesClockLocation(t *testing.T) {
+	// 03:00 UTC on the 14th is still the 13th in New York.
+	clock := fixedClock(t)
+	clock.Now = func() time.Time { return time.Date(2026, 1, 14, 3, 0, 0, 0, time.UTC) }
+	resolved, dtype, err := resolveRelativeDate("today", clock)
+	if err != nil {
+		t.Fatalf("resolveRelativeDate() failed: %v", err)
+	}
+	if got := formatResolvedDate(resolved, dtype); got != "2026-01-13" {
+		t.Fatalf("expected today to be 2026-01-13 in New York, got %s", got)
+	}
+}
+
+func TestRelativeDateQuery(t *testing.T) {
+	tokens, err := NewLexer("due.before(endOfWeek+1d) AND createdAt.after(now-24h)").Lex()
+	if err != nil {
+		t.Fatalf("Lex() failed: %v", err)
+	}
+	if tokens[4].Kind != TokenRelativeDate || tokens[4].Literal != "endOfWeek+1d" {
+		t.Fatalf("expected a relative date token, got %s", tokens[4])
+	}
+	expr, err := NewParser(tokens).Parse()
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	sql, args, err := BuildParameterizedSQLJoinQuery(expr, JoinQueryOptions{Clock: fixedClock(t)})
+	if err != nil {
+		t.Fatalf("BuildParameterizedSQLJoinQuery() failed: %v", err)
+	}
+	expected := "SELECT t0.* FROM tasks t0 WHERE (t0.due_date < ? AND t0.created_at > ?)"
+	if sql != expected {
+		t.Fatalf("expected %q, got %q", expected, sql)
+	}
+	if !reflect.DeepEqual(args, []any{"2026-01-19", "2026-01-13T15:30:00Z"}) {
+		t.Fatalf("unexpected args: %#v", args)
+	}
+}
+
+func TestRelativeDateEvaluator(t *testing.T) {
+	e := NewEvaluator(DefaultSchema())
+	e.Clock = fixedClock(t)
+	q := &QueryCondition{Field: "due", Value: "startOfWeek", Operator: OperatorEq}
+	ok, err := e.Evaluate(q, MapRecord{"due": time.Date(2026, 1, 12, 18, 0, 0, 0, time.UTC)})
+	if err != nil {
+		t.Fatalf("Evaluate() failed: %v", err)
+	}
+	if !ok {
+		t.Fatalf("expected a due date on Monday to equal startOfWeek")
+	}
+}
@@ -1,11 +1,19 @@
 package ntql
 
+import (
+	"strings"
+	"unicode/utf8"
+)
+
 type Lexeme string
 
 type Scanner struct {
 	Lexemes []Lexeme
 	Pos     int
 	S       string
+	// start and end are the byte offsets of the last scanned lexeme.
+	start int
+	end   int
 }
 
 func NewScanner(s string) *Scanner {
@@ -14,15 +22,21 @@ func NewScanner(s string) *Scanner {
 
 func (s *Scanner) ScanLexeme() (Lexeme, error) {
 
+	if err := s.skipWhitespace(); err != nil {
+		return "", err
+	}
+
 	if s.atEnd() {
 		return "", ErrEndOfInput{}
 	}
 
+	s.start = s.Pos
 	l, err := s.match()
 
 	if err != nil {
 		return "", err
 	}
+	s.end = s.Pos
 
 	if err := s.skipWhitespace(); err != nil {
 		return "", err
@@ -39,6 +53,12 @@ func (s *Scanner) LastLexeme() (Lexeme, error) {
 	return s.Lexemes[len(s.Lexemes)-1], nil
 }
 
+// LastSpan returns the byte offsets of the start and end of the last scanned
+// lexeme, including the quotes of a string.
+func (s *Scanner) LastSpan() (int, int) {
+	return s.start, s.end
+}
+
 func (s *Scanner) HasNext() bool {
 	return !s.atEnd()
 }
@@ -97,10 +117,11 @@ func (s *Scanner) match() (Lexeme, error) {
 			return "", err
 		}
 		return s.ScanLexeme()
-	} else if s.matchAlphaNum() {
+	} else if s.matchAlphaNum() || s.matchNegativeNumber() {
 		return s.consumeAlphaNum(), nil
 	} else {
-		return "", ErrInvalidLexeme{Input: s.S[s.Pos]}
+		r, size := utf8.DecodeRuneInString(s.S[s.Pos:])
+		return "", ErrInvalidLexeme{Input: s.S[s.Pos], Char: r, Position: s.Pos, End: s.Pos + size}
 	}
 }
 
@@ -129,7 +150,8 @@ func (s *Scanner) matchQuote() bool {
 }
 
 func (s *Scanner) consumeQuote() Lexeme {
-	var l string
+	// Bytes are written as they are, so multi-byte UTF-8 characters are kept.
+	var l strings.Builder
 	escaped := false
 
 	c, err := s.advance()
@@ -137,7 +159,7 @@ func (s *Scanner) consumeQuote() Lexeme {
 		panic(err)
 	}
 
-	l += string(c)
+	l.WriteByte(c)
 
 	for !s.atEnd() {
 		c, err := s.advance()
@@ -151,16 +173,16 @@ func (s *Scanner) consumeQuote() Lexeme {
 		}
 
 		if c == '"' && !escaped {
-			l += string(c)
+			l.WriteByte(c)
 			break
 		}
 
 		escaped = false
 
-		l += string(c)
+		l.WriteByte(c)
 	}
 
-	return Lexeme(s.appendLexeme(l))
+	return Lexeme(s.appendLexeme(l.String()))
 }
 
 func (s *Scanner) matchWhitespace() bool {
@@ -170,7 +192,7 @@ func (s *Scanner) matchWhitespace() bool {
 		panic(err)
 	}
 
-	return c == ' '
+	return isWhitespace(c)
 }
 
 func (s *Scanner) matchAlphaNum() bool {
@@ -182,20 +204,41 @@ func (s *Scanner) matchAlphaNum() bool {
 	return alphaNumRegexp.MatchString(string(c))
 }
 
+// matchNegativeNumber reports whether the scanner is at a minus sign followed
+// by a digit.
+func (s *Scanner) matchNegativeNumber() bool {
+	c, err := s.current()
+	if err != nil {
+		panic(err)
+	}
+
+	return c == '-' && s.Pos+1 < len(s.S) && isDigit(s.S[s.Pos+1])
+}
+
+// matchDecimalPoint reports whether the scanner is at a dot between the digits
+// of the number l and further digits, so 1.5 is scanned as one lexeme while
+// the dot in priority.equals is a symbol.
+func (s *Scanner) matchDecimalPoint(l string) bool {
+	c, err := s.current()
+	if err != nil {
+		panic(err)
+	}
+
+	return c == '.' && numRegexp.MatchString(l) && s.Pos+1 < len(s.S) && isDigit(s.S[s.Pos+1])
+}
+
 func (s *Scanner) consumeAlphaNum() Lexeme {
-	var l string
+	start := s.Pos
 
 	for !s.atEnd() {
-		if s.matchWhitespace() || s.matchSymbol() {
+		if s.matchWhitespace() || (s.matchSymbol() && !s.matchDecimalPoint(s.S[start:s.Pos])) {
 			break
 		}
 
-		c, err := s.advance()
-		if err != nil {
+		if _, err := s.advance(); err != nil {
 			panic(err)
 		}
-		l += string(c)
 	}
 
-	return Lexeme(s.appendLexeme(l))
+	return Lexeme(s.appendLexeme(s.S[start:s.Pos]))
 }
@@ -51,3 +51,35 @@ func TestScanEscapedString(t *testing.T) {
 		}
 	}
 }
+
+func TestScanNumbers(t *testing.T) {
+	s := NewScanner("priority.gte(10) AND estimate.lt(-2.75) AND score.gt(-3) ")
+
+	expected := []Lexeme{"priority", ".", "gte", "(", "10", ")", "AND", "estimate", ".", "lt", "(", "-2.75", ")", "AND", "score", ".", "gt", "(", "-3", ")"}
+
+	for _, e := range expected {
+		v, err := s.ScanLexeme()
+		if err != nil {
+			t.Fatalf("ScanLexeme() failed: %v", err)
+		}
+		if v != e {
+			t.Errorf("Expected %s, got %s", e, v)
+		}
+	}
+}
+
+func TestScanMultipleLines(t *testing.T) {
+	s := NewScanner("title.eq(\"a\")\r\n\tAND\ndue.before(today)")
+
+	expected := []Lexeme{"title", ".", "eq", "(", `"a"`, ")", "AND", "due", ".", "before", "(", "today", ")"}
+
+	for _, e := range expected {
+		v, err := s.ScanLexeme()
+		if err != nil {
+			t.Fatalf("ScanLexeme() failed: %v", err)
+		}
+		if v != e {
+			t.Errorf("Expected %s, got %s", e, v)
+		}
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"strings"
+	"sync/atomic"
 
 	"gopkg.in/yaml.v3"
 )
@@ -12,36 +14,45 @@ import (
 //go:embed schema.yaml
 var schemaFS embed.FS
 
-var (
-	validSubjects = []Subject{}
+// defaultSchema is the schema used by the package-level helpers and by
+// constructors that do not take a schema explicitly. It starts out as the
+// embedded schema.yaml.
+var defaultSchema atomic.Pointer[Schema]
 
-	date_types    = []string{}
-	bool_types    = []string{}
-	numeric_types = []string{}
-	string_types  = []string{}
-	schemaTables  = []SchemaTable{}
-	schemaJoins   = []SchemaJoin{}
-)
+// embeddedSchemaErr records why the embedded schema could not be loaded, if it
+// could not. The default schema is left empty in that case.
+var embeddedSchemaErr error
 
 type schemaConfig struct {
-	Subjects   []schemaSubject  `yaml:"subjects"`
-	FieldTypes schemaFieldTypes `yaml:"fieldTypes"`
-	Tables     []schemaTable    `yaml:"tables"`
-	Joins      []schemaJoin     `yaml:"joins"`
+	Subjects   []schemaSubject        `yaml:"subjects"`
+	Verbs      []schemaVerbDefinition `yaml:"verbs"`
+	FieldTypes schemaFieldTypes       `yaml:"fieldTypes"`
+	Tables     []schemaTable          `yaml:"tables"`
+	RootTable  string                 `yaml:"rootTable"`
+	Joins      []schemaJoin           `yaml:"joins"`
 }
 
 type schemaSubject struct {
-	Name       string       `yaml:"name"`
-	Aliases    []string     `yaml:"aliases"`
-	ValidVerbs []schemaVerb `yaml:"validVerbs"`
-	ValidTypes []string     `yaml:"validTypes"`
-	Table      string       `yaml:"table"`
-	Column     string       `yaml:"column"`
+	Name        string       `yaml:"name"`
+	Aliases     []string     `yaml:"aliases"`
+	ValidVerbs  []schemaVerb `yaml:"validVerbs"`
+	ValidTypes  []string     `yaml:"validTypes"`
+	Table       string       `yaml:"table"`
+	Column      string       `yaml:"column"`
+	Description string       `yaml:"description"`
 }
 
 type schemaVerb struct {
-	Name    string   `yaml:"name"`
-	Aliases []string `yaml:"aliases"`
+	Name        string   `yaml:"name"`
+	Aliases     []string `yaml:"aliases"`
+	Description string   `yaml:"description"`
+}
+
+type schemaVerbDefinition struct {
+	Name     string   `yaml:"name"`
+	Types    []string `yaml:"types"`
+	Operator string   `yaml:"operator"`
+	SQL      string   `yaml:"sql"`
 }
 
 type schemaFieldTypes struct {
@@ -57,10 +68,26 @@ type schemaTable struct {
 }
 
 type schemaJoin struct {
-	FromTable string `yaml:"fromTable"`
-	ToTable   string `yaml:"toTable"`
-	FromKey   string `yaml:"fromKey"`
-	ToKey     string `yaml:"toKey"`
+	FromTable   string      `yaml:"fromTable"`
+	ToTable     string      `yaml:"toTable"`
+	FromKey     string      `yaml:"fromKey"`
+	ToKey       string      `yaml:"toKey"`
+	Cardinality Cardinality `yaml:"cardinality"`
+}
+
+// SchemaVerb declares the types of value a verb takes, for every subject that
+// has the verb, and what the verb means.
+type SchemaVerb struct {
+	Name  string
+	Types []DType
+	// Operator is the comparison the verb maps to. If it is empty, the verb
+	// maps to the operator NewOperator gives for its name.
+	Operator Operator
+	// SQL is a template for the SQL of a verb with no operator of its own,
+	// such as "{field} ILIKE {value}". {field} is replaced with the column and
+	// {value} with the value. Conditions on such a verb have the verb's name
+	// as their operator, and can only be converted to SQL.
+	SQL string
 }
 
 type SchemaTable struct {
@@ -73,64 +100,267 @@ type SchemaJoin struct {
 	ToTable   string
 	FromKey   string
 	ToKey     string
+	// Cardinality describes how many ToTable rows match one FromTable row and
+	// the other way round. It defaults to many-to-one.
+	Cardinality Cardinality
 }
 
+// Cardinality describes a join between two tables, read from the join's
+// fromTable to its toTable.
+type Cardinality string
+
+const (
+	CardinalityOneToOne   Cardinality = "one-to-one"
+	CardinalityManyToOne  Cardinality = "many-to-one"
+	CardinalityOneToMany  Cardinality = "one-to-many"
+	CardinalityManyToMany Cardinality = "many-to-many"
+)
+
+// LoadedSchema is the plain-data form of a Schema. It is returned by
+// Schema.Definition and accepted by NewSchema for schemas built in Go code.
 type LoadedSchema struct {
 	ValidSubjects []Subject
-	DateTypes     []string
-	BoolTypes     []string
-	NumericTypes  []string
-	StringTypes   []string
-	Tables        []SchemaTable
-	Joins         []SchemaJoin
+	// Verbs declares the types each verb takes. If it is empty, verbs take
+	// any type their subject accepts.
+	Verbs        []SchemaVerb
+	DateTypes    []string
+	BoolTypes    []string
+	NumericTypes []string
+	StringTypes  []string
+	Tables       []SchemaTable
+	// RootTable is the table join queries select from. It defaults to the
+	// first table.
+	RootTable string
+	Joins     []SchemaJoin
+}
+
+// Schema holds the subjects, field types, tables and joins that queries are
+// lexed, parsed and compiled against. A Schema is never modified after it is
+// built, so a single instance can be shared between goroutines, and several
+// instances can live side by side in one process.
+type Schema struct {
+	subjects     []Subject
+	verbs        []SchemaVerb
+	dateTypes    []string
+	boolTypes    []string
+	numericTypes []string
+	stringTypes  []string
+	tables       []SchemaTable
+	rootTable    string
+	joins        []SchemaJoin
 }
 
 func init() {
-	if err := LoadEmbeddedSchema(); err != nil {
-		panic(fmt.Sprintf("failed to load schema.yaml: %v", err))
+	schema, err := EmbeddedSchema()
+	if err != nil {
+		embeddedSchemaErr = err
+		schema = &Schema{}
 	}
+	defaultSchema.Store(schema)
 }
 
-func LoadEmbeddedSchema() error {
-	schemaData, err := schemaFS.ReadFile("schema.yaml")
+// NewSchema builds a schema from a definition written in Go. The definition
+// goes through the same validation as a schema.yaml file.
+func NewSchema(def LoadedSchema) (*Schema, error) {
+	return newSchemaFromConfig(schemaConfigFromDefinition(def))
+}
+
+// NewSchemaFromYAML builds a schema from the contents of a schema.yaml file.
+func NewSchemaFromYAML(data []byte) (*Schema, error) {
+	cfg, err := loadSchemaConfigFromYAML(data)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return buildSchema(cfg)
+}
 
-	cfg, err := loadSchemaConfigFromYAML(schemaData)
+// NewSchemaFromFile builds a schema from the schema.yaml file at path.
+func NewSchemaFromFile(path string) (*Schema, error) {
+	schemaData, err := os.ReadFile(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return NewSchemaFromYAML(schemaData)
+}
 
-	return applySchemaConfig(cfg)
+// EmbeddedSchema builds a fresh copy of the schema.yaml embedded in the package.
+func EmbeddedSchema() (*Schema, error) {
+	schemaData, err := schemaFS.ReadFile("schema.yaml")
+	if err != nil {
+		return nil, err
+	}
+	return NewSchemaFromYAML(schemaData)
 }
 
-func LoadSchemaFromFile(path string) error {
-	schemaData, err := os.ReadFile(path)
+// DefaultSchema returns the schema used when no schema is passed explicitly.
+func DefaultSchema() *Schema {
+	return defaultSchema.Load()
+}
+
+// SetDefaultSchema replaces the schema used when no schema is passed
+// explicitly. Prefer passing a *Schema to the constructors that accept one;
+// this exists for programs that only ever serve a single schema.
+func SetDefaultSchema(schema *Schema) {
+	if schema == nil {
+		return
+	}
+	defaultSchema.Store(schema)
+}
+
+// LoadEmbeddedSchema resets the default schema to the embedded schema.yaml.
+func LoadEmbeddedSchema() error {
+	if embeddedSchemaErr != nil {
+		return embeddedSchemaErr
+	}
+	schema, err := EmbeddedSchema()
 	if err != nil {
 		return err
 	}
+	SetDefaultSchema(schema)
+	return nil
+}
 
-	cfg, err := loadSchemaConfigFromYAML(schemaData)
+// LoadSchemaFromFile replaces the default schema with the schema.yaml file at
+// path.
+func LoadSchemaFromFile(path string) error {
+	schema, err := NewSchemaFromFile(path)
 	if err != nil {
 		return err
 	}
-
-	return applySchemaConfig(cfg)
+	SetDefaultSchema(schema)
+	return nil
 }
 
+// GetLoadedSchema returns a copy of the default schema's definition.
 func GetLoadedSchema() LoadedSchema {
+	return DefaultSchema().Definition()
+}
+
+// Definition returns a copy of the schema's definition. Modifying the copy does
+// not affect the schema.
+func (s *Schema) Definition() LoadedSchema {
 	return LoadedSchema{
-		ValidSubjects: copySubjects(validSubjects),
-		DateTypes:     append([]string{}, date_types...),
-		BoolTypes:     append([]string{}, bool_types...),
-		NumericTypes:  append([]string{}, numeric_types...),
-		StringTypes:   append([]string{}, string_types...),
-		Tables:        append([]SchemaTable{}, schemaTables...),
-		Joins:         append([]SchemaJoin{}, schemaJoins...),
+		ValidSubjects: copySubjects(s.subjects),
+		Verbs:         copyVerbs(s.verbs),
+		DateTypes:     append([]string{}, s.dateTypes...),
+		BoolTypes:     append([]string{}, s.boolTypes...),
+		NumericTypes:  append([]string{}, s.numericTypes...),
+		StringTypes:   append([]string{}, s.stringTypes...),
+		Tables:        append([]SchemaTable{}, s.tables...),
+		RootTable:     s.rootTable,
+		Joins:         append([]SchemaJoin{}, s.joins...),
 	}
 }
 
+// Subject looks up a subject by name or alias, ignoring case and underscores.
+func (s *Schema) Subject(name string) (*Subject, error) {
+	for _, subject := range s.subjects {
+		if toLowerCase(subject.Name) == toLowerCase(name) {
+			return &subject, nil
+		}
+		for _, alias := range subject.Aliases {
+			if toLowerCase(name) == toLowerCase(alias) {
+				return &subject, nil
+			}
+		}
+	}
+
+	return nil, ErrInvalidToken{}
+}
+
+// verb returns the declared verb named name, ignoring case and underscores.
+func (s *Schema) verb(name string) (*SchemaVerb, bool) {
+	for _, verb := range s.verbs {
+		if toLowerCase(verb.Name) == toLowerCase(name) {
+			return &verb, true
+		}
+	}
+	return nil, false
+}
+
+// verbTypes returns the types declared for the verb named name, and false if
+// the schema declares none.
+func (s *Schema) verbTypes(name string) ([]DType, bool) {
+	if verb, ok := s.verb(name); ok {
+		return verb.Types, true
+	}
+	return nil, false
+}
+
+// verbOperator returns the operator of the verb named name, as declared in the
+// schema's verbs. Verbs the schema does not declare map to the comparison
+// NewOperator gives for their name.
+func (s *Schema) verbOperator(name string) (Operator, error) {
+	if verb, ok := s.verb(name); ok {
+		return verb.Operator, nil
+	}
+	if op, ok := comparisonNames[toLowerCase(name)]; ok {
+		return op, nil
+	}
+	return "", errors.New("invalid operator: " + name)
+}
+
+// verbSQL returns the SQL template of the verb whose conditions have operator
+// op, and false if op is not a verb with a template.
+func (s *Schema) verbSQL(op Operator) (string, bool) {
+	for _, verb := range s.verbs {
+		if verb.SQL != "" && verb.Operator == op {
+			return verb.SQL, true
+		}
+	}
+	return "", false
+}
+
+// verbFor returns the name of the subject's verb for op. Schemas without
+// declared verbs also match the verb's aliases against operator names.
+func (s *Schema) verbFor(subject *Subject, op Operator) (string, bool) {
+	for _, verb := range subject.ValidVerbs {
+		names := []string{verb.Name}
+		if len(s.verbs) == 0 {
+			names = append(names, verb.Aliases...)
+		}
+		for _, name := range names {
+			if parsed, err := s.verbOperator(name); err == nil && parsed == op {
+				return verb.Name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// conditionVerb returns the name of the subject's verb for op. An operator the
+// subject has no verb for, but whose negation it has, such as the notEquals
+// that Normalize writes for NOT equals, is written as NOT of that verb, and
+// negated is set.
+func (s *Schema) conditionVerb(subject *Subject, op Operator) (verb string, negated bool, ok bool) {
+	if verb, ok := s.verbFor(subject, op); ok {
+		return verb, false, true
+	}
+	if opposite, ok := negatedOperators[op]; ok {
+		if verb, ok := s.verbFor(subject, opposite); ok {
+			return verb, true, true
+		}
+	}
+	return "", false, false
+}
+
+// RootTable returns the table join queries select from, or "" if the schema
+// defines no tables.
+func (s *Schema) RootTable() string {
+	return s.rootTable
+}
+
+// SubjectNames returns every subject name followed by its aliases, in schema
+// order.
+func (s *Schema) SubjectNames() []string {
+	subjects := make([]string, 0)
+	for _, subject := range s.subjects {
+		subjects = append(subjects, subject.Name)
+		subjects = append(subjects, subject.Aliases...)
+	}
+	return subjects
+}
+
 func loadSchemaConfigFromYAML(data []byte) (*schemaConfig, error) {
 	var cfg schemaConfig
 	if err := yaml.Unmarshal(data, &cfg); err != nil {
@@ -142,6 +372,18 @@ func loadSchemaConfigFromYAML(data []byte) (*schemaConfig, error) {
 	return &cfg, nil
 }
 
+// schemaTypeNames maps the lower-cased validTypes names accepted in a schema to
+// their data types.
+var schemaTypeNames = map[string]DType{
+	"string":   DTypeString,
+	"int":      DTypeInt,
+	"float":    DTypeFloat,
+	"decimal":  DTypeFloat,
+	"date":     DTypeDate,
+	"tag":      DTypeTag,
+	"datetime": DTypeDateTime,
+}
+
 func validateSchemaConfig(cfg *schemaConfig) error {
 	if cfg == nil {
 		return errors.New("schema config cannot be nil")
@@ -150,12 +392,33 @@ func validateSchemaConfig(cfg *schemaConfig) error {
 		return errors.New("schema must define at least one subject")
 	}
 
-	dtypeMap := map[string]DType{
-		"string":   DTypeString,
-		"int":      DTypeInt,
-		"date":     DTypeDate,
-		"tag":      DTypeTag,
-		"datetime": DTypeDateTime,
+	declaredVerbs := map[string][]string{}
+	for _, verb := range cfg.Verbs {
+		if verb.Name == "" {
+			return errors.New("verb name cannot be empty")
+		}
+		key := toLowerCase(verb.Name)
+		if _, exists := declaredVerbs[key]; exists {
+			return fmt.Errorf("duplicate verb: %s", verb.Name)
+		}
+		if len(verb.Types) == 0 {
+			return fmt.Errorf("verb %s must define at least one type", verb.Name)
+		}
+		for _, dtype := range verb.Types {
+			if _, ok := schemaTypeNames[toLowerCase(dtype)]; !ok {
+				return fmt.Errorf("verb %s contains unknown type: %s", verb.Name, dtype)
+			}
+		}
+		op, err := verb.operator()
+		if err != nil {
+			return err
+		}
+		for _, dtype := range verb.Types {
+			if !operatorTakes(op, schemaTypeNames[toLowerCase(dtype)]) {
+				return fmt.Errorf("verb %s maps to %s, which cannot compare %s values", verb.Name, op, dtype)
+			}
+		}
+		declaredVerbs[key] = verb.Types
 	}
 
 	seenSubjects := map[string]struct{}{}
@@ -210,10 +473,29 @@ func validateSchemaConfig(cfg *schemaConfig) error {
 		}
 
 		for _, dtype := range subject.ValidTypes {
-			if _, ok := dtypeMap[toLowerCase(dtype)]; !ok {
+			if _, ok := schemaTypeNames[toLowerCase(dtype)]; !ok {
 				return fmt.Errorf("subject %s contains unknown valid type: %s", subject.Name, dtype)
 			}
 		}
+
+		if len(cfg.Verbs) == 0 {
+			// Without a verbs section, verbs map to the operator of their name.
+			for _, verb := range subject.ValidVerbs {
+				if _, ok := comparisonNames[toLowerCase(verb.Name)]; !ok {
+					return fmt.Errorf("subject %s verb %s is not an operator name and is not declared in verbs", subject.Name, verb.Name)
+				}
+			}
+			continue
+		}
+		for _, verb := range subject.ValidVerbs {
+			types, ok := declaredVerbs[toLowerCase(verb.Name)]
+			if !ok {
+				return fmt.Errorf("subject %s verb %s is not declared in verbs", subject.Name, verb.Name)
+			}
+			if !sharesType(subject.ValidTypes, types) {
+				return fmt.Errorf("subject %s verb %s takes %s, but the subject only accepts %s", subject.Name, verb.Name, strings.Join(types, "|"), strings.Join(subject.ValidTypes, "|"))
+			}
+		}
 	}
 
 	if err := validateFieldTypeArray("dateTypes", cfg.FieldTypes.DateTypes); err != nil {
@@ -265,11 +547,119 @@ func validateSchemaConfig(cfg *schemaConfig) error {
 		if _, exists := seenTables[toLowerCase(join.ToTable)]; !exists {
 			return fmt.Errorf("join references unknown table: %s", join.ToTable)
 		}
+		switch join.Cardinality {
+		case "", CardinalityOneToOne, CardinalityManyToOne, CardinalityOneToMany, CardinalityManyToMany:
+		default:
+			return fmt.Errorf("join from %s to %s has unknown cardinality: %s", join.FromTable, join.ToTable, join.Cardinality)
+		}
+	}
+
+	if cfg.RootTable != "" {
+		if _, exists := seenTables[toLowerCase(cfg.RootTable)]; !exists {
+			return fmt.Errorf("root table %s is not defined in tables", cfg.RootTable)
+		}
+	}
+
+	if len(cfg.Tables) > 0 {
+		rootTable := cfg.RootTable
+		if rootTable == "" {
+			rootTable = cfg.Tables[0].Name
+		}
+		reachable := reachableTables(rootTable, cfg.Joins)
+		for _, subject := range cfg.Subjects {
+			if _, ok := reachable[toLowerCase(subject.Table)]; !ok {
+				return fmt.Errorf("subject %s maps to table %s, which cannot be reached from root table %s", subject.Name, subject.Table, rootTable)
+			}
+		}
 	}
 
 	return nil
 }
 
+// reachableTables returns the lower-cased names of every table that can be
+// joined to from root, including root itself. Joins can be followed in either
+// direction.
+func reachableTables(root string, joins []schemaJoin) map[string]struct{} {
+	reachable := map[string]struct{}{toLowerCase(root): {}}
+	queue := []string{toLowerCase(root)}
+	for len(queue) > 0 {
+		table := queue[0]
+		queue = queue[1:]
+		for _, join := range joins {
+			var next string
+			switch table {
+			case toLowerCase(join.FromTable):
+				next = toLowerCase(join.ToTable)
+			case toLowerCase(join.ToTable):
+				next = toLowerCase(join.FromTable)
+			default:
+				continue
+			}
+			if _, seen := reachable[next]; seen {
+				continue
+			}
+			reachable[next] = struct{}{}
+			queue = append(queue, next)
+		}
+	}
+	return reachable
+}
+
+// operator returns the operator a declared verb maps to: its own name for a SQL
+// template, the named comparison, or otherwise the comparison NewOperator gives
+// for the verb's name.
+func (v schemaVerbDefinition) operator() (Operator, error) {
+	switch {
+	case v.SQL != "" && v.Operator != "":
+		return "", fmt.Errorf("verb %s declares both an operator and a sql template", v.Name)
+	case v.SQL != "":
+		if !strings.Contains(v.SQL, "{field}") || !strings.Contains(v.SQL, "{value}") {
+			return "", fmt.Errorf("verb %s sql template must contain {field} and {value}", v.Name)
+		}
+		if _, ok := comparisonNames[toLowerCase(v.Name)]; ok {
+			return "", fmt.Errorf("verb %s with a sql template cannot be named after an operator", v.Name)
+		}
+		return Operator(v.Name), nil
+	case v.Operator != "":
+		for _, op := range comparisonOperators {
+			if toLowerCase(string(op)) == toLowerCase(v.Operator) {
+				return op, nil
+			}
+		}
+		return "", fmt.Errorf("verb %s maps to unknown operator: %s", v.Name, v.Operator)
+	}
+	if op, ok := comparisonNames[toLowerCase(v.Name)]; ok {
+		return op, nil
+	}
+	return "", fmt.Errorf("verb %s does not name an operator, declare its operator or sql", v.Name)
+}
+
+// operatorTakes reports whether the SQL for op can compare values of type
+// dtype: ordering needs numbers or dates, and pattern matching needs text.
+// Verbs with a SQL template take any type.
+func operatorTakes(op Operator, dtype DType) bool {
+	switch op {
+	case OperatorGt, OperatorLT, OperatorGte, OperatorLte:
+		return dtype != DTypeString && dtype != DTypeTag
+	case OperatorCnt, OperatorSW, OperatorEw:
+		return dtype == DTypeString || dtype == DTypeTag
+	}
+	return true
+}
+
+// sharesType reports whether two lists of schema type names have a type in
+// common.
+func sharesType(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if schemaTypeNames[toLowerCase(x)] == schemaTypeNames[toLowerCase(y)] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func validateFieldTypeArray(name string, values []string) error {
 	if len(values) == 0 {
 		return fmt.Errorf("%s must define at least one value", name)
@@ -287,41 +677,44 @@ func validateFieldTypeArray(name string, values []string) error {
 	return nil
 }
 
-func applySchemaConfig(cfg *schemaConfig) error {
-	dtypeMap := map[string]DType{
-		"string":   DTypeString,
-		"int":      DTypeInt,
-		"date":     DTypeDate,
-		"tag":      DTypeTag,
-		"datetime": DTypeDateTime,
-	}
-
+func buildSchema(cfg *schemaConfig) (*Schema, error) {
 	subjects := make([]Subject, 0, len(cfg.Subjects))
 	for _, subject := range cfg.Subjects {
 		validTypes := make([]DType, 0, len(subject.ValidTypes))
 		for _, dtype := range subject.ValidTypes {
-			mapped, ok := dtypeMap[toLowerCase(dtype)]
+			mapped, ok := schemaTypeNames[toLowerCase(dtype)]
 			if !ok {
-				return fmt.Errorf("subject %s contains unknown valid type: %s", subject.Name, dtype)
+				return nil, fmt.Errorf("subject %s contains unknown valid type: %s", subject.Name, dtype)
 			}
 			validTypes = append(validTypes, mapped)
 		}
 
 		validVerbs := make([]Verb, 0, len(subject.ValidVerbs))
 		for _, verb := range subject.ValidVerbs {
-			validVerbs = append(validVerbs, Verb{Name: verb.Name, Aliases: append([]string{}, verb.Aliases...)})
+			validVerbs = append(validVerbs, Verb{Name: verb.Name, Aliases: append([]string{}, verb.Aliases...), Description: verb.Description})
 		}
 
 		subjects = append(subjects, Subject{
-			Name:       subject.Name,
-			Aliases:    append([]string{}, subject.Aliases...),
-			ValidVerbs: validVerbs,
-			ValidTypes: validTypes,
-			Table:      subject.Table,
-			Column:     subject.Column,
+			Name:        subject.Name,
+			Aliases:     append([]string{}, subject.Aliases...),
+			ValidVerbs:  validVerbs,
+			ValidTypes:  validTypes,
+			Table:       subject.Table,
+			Column:      subject.Column,
+			Description: subject.Description,
 		})
 	}
 
+	verbs := make([]SchemaVerb, 0, len(cfg.Verbs))
+	for _, verb := range cfg.Verbs {
+		types := make([]DType, 0, len(verb.Types))
+		for _, dtype := range verb.Types {
+			types = append(types, schemaTypeNames[toLowerCase(dtype)])
+		}
+		op, _ := verb.operator()
+		verbs = append(verbs, SchemaVerb{Name: verb.Name, Types: types, Operator: op, SQL: verb.SQL})
+	}
+
 	tables := make([]SchemaTable, 0, len(cfg.Tables))
 	for _, table := range cfg.Tables {
 		tables = append(tables, SchemaTable(table))
@@ -329,18 +722,87 @@ func applySchemaConfig(cfg *schemaConfig) error {
 
 	joins := make([]SchemaJoin, 0, len(cfg.Joins))
 	for _, join := range cfg.Joins {
+		if join.Cardinality == "" {
+			join.Cardinality = CardinalityManyToOne
+		}
 		joins = append(joins, SchemaJoin(join))
 	}
 
-	validSubjects = subjects
-	date_types = append([]string{}, cfg.FieldTypes.DateTypes...)
-	bool_types = append([]string{}, cfg.FieldTypes.BoolTypes...)
-	numeric_types = append([]string{}, cfg.FieldTypes.NumericTypes...)
-	string_types = append([]string{}, cfg.FieldTypes.StringTypes...)
-	schemaTables = tables
-	schemaJoins = joins
+	rootTable := ""
+	for _, table := range tables {
+		if cfg.RootTable == "" || toLowerCase(table.Name) == toLowerCase(cfg.RootTable) {
+			rootTable = table.Name
+			break
+		}
+	}
 
-	return nil
+	return &Schema{
+		subjects:     subjects,
+		verbs:        verbs,
+		dateTypes:    append([]string{}, cfg.FieldTypes.DateTypes...),
+		boolTypes:    append([]string{}, cfg.FieldTypes.BoolTypes...),
+		numericTypes: append([]string{}, cfg.FieldTypes.NumericTypes...),
+		stringTypes:  append([]string{}, cfg.FieldTypes.StringTypes...),
+		tables:       tables,
+		rootTable:    rootTable,
+		joins:        joins,
+	}, nil
+}
+
+func newSchemaFromConfig(cfg *schemaConfig) (*Schema, error) {
+	if err := validateSchemaConfig(cfg); err != nil {
+		return nil, err
+	}
+	return buildSchema(cfg)
+}
+
+func schemaConfigFromDefinition(def LoadedSchema) *schemaConfig {
+	cfg := &schemaConfig{
+		FieldTypes: schemaFieldTypes{
+			DateTypes:    append([]string{}, def.DateTypes...),
+			BoolTypes:    append([]string{}, def.BoolTypes...),
+			NumericTypes: append([]string{}, def.NumericTypes...),
+			StringTypes:  append([]string{}, def.StringTypes...),
+		},
+	}
+	for _, subject := range def.ValidSubjects {
+		verbs := make([]schemaVerb, 0, len(subject.ValidVerbs))
+		for _, verb := range subject.ValidVerbs {
+			verbs = append(verbs, schemaVerb{Name: verb.Name, Aliases: append([]string{}, verb.Aliases...), Description: verb.Description})
+		}
+		types := make([]string, 0, len(subject.ValidTypes))
+		for _, dtype := range subject.ValidTypes {
+			types = append(types, dtype.String())
+		}
+		cfg.Subjects = append(cfg.Subjects, schemaSubject{
+			Name:        subject.Name,
+			Aliases:     append([]string{}, subject.Aliases...),
+			ValidVerbs:  verbs,
+			ValidTypes:  types,
+			Table:       subject.Table,
+			Column:      subject.Column,
+			Description: subject.Description,
+		})
+	}
+	for _, verb := range def.Verbs {
+		types := make([]string, 0, len(verb.Types))
+		for _, dtype := range verb.Types {
+			types = append(types, dtype.String())
+		}
+		def := schemaVerbDefinition{Name: verb.Name, Types: types, SQL: verb.SQL}
+		if verb.SQL == "" || verb.Operator != Operator(verb.Name) {
+			def.Operator = string(verb.Operator)
+		}
+		cfg.Verbs = append(cfg.Verbs, def)
+	}
+	for _, table := range def.Tables {
+		cfg.Tables = append(cfg.Tables, schemaTable(table))
+	}
+	cfg.RootTable = def.RootTable
+	for _, join := range def.Joins {
+		cfg.Joins = append(cfg.Joins, schemaJoin(join))
+	}
+	return cfg
 }
 
 func copySubjects(subjects []Subject) []Subject {
@@ -348,16 +810,26 @@ func copySubjects(subjects []Subject) []Subject {
 	for _, subject := range subjects {
 		validVerbs := make([]Verb, 0, len(subject.ValidVerbs))
 		for _, verb := range subject.ValidVerbs {
-			validVerbs = append(validVerbs, Verb{Name: verb.Name, Aliases: append([]string{}, verb.Aliases...)})
+			validVerbs = append(validVerbs, Verb{Name: verb.Name, Aliases: append([]string{}, verb.Aliases...), Description: verb.Description})
 		}
 		copied = append(copied, Subject{
-			Name:       subject.Name,
-			Aliases:    append([]string{}, subject.Aliases...),
-			ValidVerbs: validVerbs,
-			ValidTypes: append([]DType{}, subject.ValidTypes...),
-			Table:      subject.Table,
-			Column:     subject.Column,
+			Name:        subject.Name,
+			Aliases:     append([]string{}, subject.Aliases...),
+			ValidVerbs:  validVerbs,
+			ValidTypes:  append([]DType{}, subject.ValidTypes...),
+			Table:       subject.Table,
+			Column:      subject.Column,
+			Description: subject.Description,
 		})
 	}
 	return copied
 }
+
+func copyVerbs(verbs []SchemaVerb) []SchemaVerb {
+	copied := make([]SchemaVerb, 0, len(verbs))
+	for _, verb := range verbs {
+		verb.Types = append([]DType{}, verb.Types...)
+		copied = append(copied, verb)
+	}
+	return copied
+}
@@ -1,6 +1,7 @@
 package ntql
 
 import (
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -53,6 +54,50 @@ func TestCrossTableJoinTagEqualsNumericID(t *testing.T) {
 	}
 }
 
+func TestJoinTagNumericIDMatchesWhereMode(t *testing.T) {
+	expr, err := parseQuery(`tag.equals(5)`)
+	if err != nil {
+		t.Fatalf("parsing failed: %v", err)
+	}
+	_, whereArgs, err := ToParameterizedSQL(expr)
+	if err != nil {
+		t.Fatalf("ToParameterizedSQL() failed: %v", err)
+	}
+	sql, selectArgs, err := BuildParameterizedSQLJoinQuery(expr, JoinQueryOptions{})
+	if err != nil {
+		t.Fatalf("BuildParameterizedSQLJoinQuery() failed: %v", err)
+	}
+	if !reflect.DeepEqual(whereArgs, []any{int64(5)}) || !reflect.DeepEqual(selectArgs, whereArgs) {
+		t.Fatalf("expected both modes to bind int64(5), got %#v and %#v", whereArgs, selectArgs)
+	}
+	if !strings.Contains(sql, "s2.id = ?") {
+		t.Fatalf("expected the tag to be matched by id, got %s", sql)
+	}
+
+	sql, err = BuildSQLJoinQuery(&QueryCondition{Field: "tag", Operator: OperatorEq, Value: "work"}, JoinQueryOptions{})
+	if err != nil || !strings.Contains(sql, "s2.name = 'work'") {
+		t.Fatalf("expected a named tag to be matched by name, got %s, %v", sql, err)
+	}
+}
+
+func TestJoinQuotedTag(t *testing.T) {
+	expr, err := parseQuery(`tag.equals("backend")`)
+	if err != nil {
+		t.Fatalf("parsing failed: %v", err)
+	}
+	sql, err := BuildSQLJoinQuery(expr, JoinQueryOptions{})
+	if err != nil || !strings.Contains(sql, "s2.name = 'backend'") {
+		t.Fatalf("expected the quotes to be removed from the tag, got %s, %v", sql, err)
+	}
+	_, args, err := BuildParameterizedSQLJoinQuery(expr, JoinQueryOptions{})
+	if err != nil || !reflect.DeepEqual(args, []any{"backend"}) {
+		t.Fatalf("expected the tag to be bound without quotes, got %#v, %v", args, err)
+	}
+	if ok, err := Evaluate(expr, MapRecord{"tag": []string{"backend"}}); err != nil || !ok {
+		t.Fatalf("expected a task tagged backend to match, got %v, %v", ok, err)
+	}
+}
+
 func TestCrossTableJoinComposesWithMainTableFilter(t *testing.T) {
 	lexer := NewLexer(`tag.equals(work) AND title.contains("roadmap")`)
 	tokens, err := lexer.Lex()
@@ -166,25 +211,18 @@ joins:
     toKey: id
 `
 
-func loadJoinTestSchema(t *testing.T) {
+func loadJoinTestSchema(t *testing.T) *Schema {
 	t.Helper()
-	cfg, err := loadSchemaConfigFromYAML([]byte(joinTestSchemaYAML))
+	schema, err := NewSchemaFromYAML([]byte(joinTestSchemaYAML))
 	if err != nil {
 		t.Fatalf("failed to load join test schema: %v", err)
 	}
-	if err := applySchemaConfig(cfg); err != nil {
-		t.Fatalf("failed to apply join test schema: %v", err)
-	}
-	t.Cleanup(func() {
-		if err := LoadEmbeddedSchema(); err != nil {
-			t.Fatalf("failed to restore embedded schema: %v", err)
-		}
-	})
+	return schema
 }
 
-func mustBuildJoinSQL(t *testing.T, expr QueryExpr) string {
+func mustBuildJoinSQL(t *testing.T, schema *Schema, expr QueryExpr) string {
 	t.Helper()
-	sql, err := BuildSQLJoinQuery(expr, JoinQueryOptions{})
+	sql, err := BuildSQLJoinQuery(expr, JoinQueryOptions{Schema: schema})
 	if err != nil {
 		t.Fatalf("BuildSQLJoinQuery failed: %v", err)
 	}
@@ -192,9 +230,9 @@ func mustBuildJoinSQL(t *testing.T, expr QueryExpr) string {
 }
 
 func TestJoinSimpleCrossTable(t *testing.T) {
-	loadJoinTestSchema(t)
+	schema := loadJoinTestSchema(t)
 
-	sql := mustBuildJoinSQL(t, NewQueryAnd(
+	sql := mustBuildJoinSQL(t, schema, NewQueryAnd(
 		&QueryCondition{Field: "title", Operator: OperatorEq, Value: "Bug"},
 		&QueryCondition{Field: "assignee", Operator: OperatorEq, Value: "Alice"},
 	))
@@ -207,9 +245,9 @@ func TestJoinSimpleCrossTable(t *testing.T) {
 }
 
 func TestJoinMultipleFields(t *testing.T) {
-	loadJoinTestSchema(t)
+	schema := loadJoinTestSchema(t)
 
-	sql := mustBuildJoinSQL(t, NewQueryAnd(
+	sql := mustBuildJoinSQL(t, schema, NewQueryAnd(
 		NewQueryAnd(
 			&QueryCondition{Field: "title", Operator: OperatorEq, Value: "Issue"},
 			&QueryCondition{Field: "assignee", Operator: OperatorEq, Value: "Bob"},
@@ -226,9 +264,9 @@ func TestJoinMultipleFields(t *testing.T) {
 }
 
 func TestJoinWithOR(t *testing.T) {
-	loadJoinTestSchema(t)
+	schema := loadJoinTestSchema(t)
 
-	sql := mustBuildJoinSQL(t, NewQueryOr(
+	sql := mustBuildJoinSQL(t, schema, NewQueryOr(
 		&QueryCondition{Field: "title", Operator: OperatorEq, Value: "Hotfix"},
 		&QueryCondition{Field: "assignee", Operator: OperatorEq, Value: "Cara"},
 	))
@@ -237,16 +275,16 @@ func TestJoinWithOR(t *testing.T) {
 }
 
 func TestJoinWithNegation(t *testing.T) {
-	loadJoinTestSchema(t)
+	schema := loadJoinTestSchema(t)
 
-	sql := mustBuildJoinSQL(t, NewQueryNot(&QueryCondition{Field: "assignee", Operator: OperatorEq, Value: "Dave"}))
+	sql := mustBuildJoinSQL(t, schema, NewQueryNot(&QueryCondition{Field: "assignee", Operator: OperatorEq, Value: "Dave"}))
 	assertStringContainsAll(t, sql, "(NOT (t1.assignee_name = 'Dave'))")
 }
 
 func TestJoinDeduplication(t *testing.T) {
-	loadJoinTestSchema(t)
+	schema := loadJoinTestSchema(t)
 
-	sql := mustBuildJoinSQL(t, NewQueryAnd(
+	sql := mustBuildJoinSQL(t, schema, NewQueryAnd(
 		&QueryCondition{Field: "assignee", Operator: OperatorEq, Value: "Eve"},
 		&QueryCondition{Field: "assignmentStatus", Operator: OperatorEq, Value: "pending"},
 	))
@@ -257,9 +295,9 @@ func TestJoinDeduplication(t *testing.T) {
 }
 
 func TestJoinThreeTables(t *testing.T) {
-	loadJoinTestSchema(t)
+	schema := loadJoinTestSchema(t)
 
-	sql := mustBuildJoinSQL(t, NewQueryAnd(
+	sql := mustBuildJoinSQL(t, schema, NewQueryAnd(
 		&QueryCondition{Field: "assignee", Operator: OperatorEq, Value: "Frank"},
 		&QueryCondition{Field: "project", Operator: OperatorEq, Value: "Apollo"},
 	))
@@ -272,9 +310,9 @@ func TestJoinThreeTables(t *testing.T) {
 }
 
 func TestJoinAliasing(t *testing.T) {
-	loadJoinTestSchema(t)
+	schema := loadJoinTestSchema(t)
 
-	sql := mustBuildJoinSQL(t, NewQueryAnd(
+	sql := mustBuildJoinSQL(t, schema, NewQueryAnd(
 		&QueryCondition{Field: "title", Operator: OperatorEq, Value: "Roadmap"},
 		&QueryCondition{Field: "project", Operator: OperatorEq, Value: "Platform"},
 	))
@@ -283,9 +321,9 @@ func TestJoinAliasing(t *testing.T) {
 }
 
 func TestJoinComplexExpression(t *testing.T) {
-	loadJoinTestSchema(t)
+	schema := loadJoinTestSchema(t)
 
-	sql := mustBuildJoinSQL(t, NewQueryAnd(
+	sql := mustBuildJoinSQL(t, schema, NewQueryAnd(
 		NewQueryOr(
 			&QueryCondition{Field: "title", Operator: OperatorCnt, Value: "bug"},
 			&QueryCondition{Field: "assignee", Operator: OperatorEq, Value: "Grace"},
@@ -299,11 +337,11 @@ func TestJoinComplexExpression(t *testing.T) {
 }
 
 func TestJoinSelectDistinct(t *testing.T) {
-	loadJoinTestSchema(t)
+	schema := loadJoinTestSchema(t)
 
 	sql, err := BuildSQLJoinQuery(
 		&QueryCondition{Field: "assignee", Operator: OperatorEq, Value: "Hank"},
-		JoinQueryOptions{Distinct: true},
+		JoinQueryOptions{Distinct: true, Schema: schema},
 	)
 	if err != nil {
 		t.Fatalf("BuildSQLJoinQuery failed: %v", err)
@@ -312,9 +350,9 @@ func TestJoinSelectDistinct(t *testing.T) {
 }
 
 func TestJoinDateRangeAcrossTables(t *testing.T) {
-	loadJoinTestSchema(t)
+	schema := loadJoinTestSchema(t)
 
-	sql := mustBuildJoinSQL(t, NewQueryAnd(
+	sql := mustBuildJoinSQL(t, schema, NewQueryAnd(
 		&QueryCondition{Field: "due", Operator: OperatorGte, Value: "2026-01-01"},
 		&QueryCondition{Field: "assignedAt", Operator: OperatorLte, Value: "2026-12-31"},
 	))
@@ -337,3 +375,222 @@ func assertStringContainsAll(t *testing.T, input string, expected ...string) {
 func countOccurrences(input, fragment string) int {
 	return strings.Count(input, fragment)
 }
+
+func TestJoinParameterized(t *testing.T) {
+	schema := loadJoinTestSchema(t)
+
+	sql, args, err := BuildParameterizedSQLJoinQuery(NewQueryAnd(
+		&QueryCondition{Field: "assignee", Operator: OperatorEq, Value: "O'Brien"},
+		&QueryCondition{Field: "due", Operator: OperatorLT, Value: "2026-12-31"},
+	), JoinQueryOptions{Schema: schema})
+	if err != nil {
+		t.Fatalf("BuildParameterizedSQLJoinQuery failed: %v", err)
+	}
+
+	assertStringContainsAll(t, sql, "(t1.assignee_name = ? AND t0.due_date < ?)")
+	if len(args) != 2 || args[0] != "O'Brien" || args[1] != "2026-12-31" {
+		t.Fatalf("unexpected args: %#v", args)
+	}
+}
+
+func TestJoinPostgresDialect(t *testing.T) {
+	schema := loadJoinTestSchema(t)
+
+	sql, args, err := BuildParameterizedSQLJoinQuery(NewQueryAnd(
+		&QueryCondition{Field: "title", Operator: OperatorCnt, Value: "bug"},
+		&QueryCondition{Field: "project", Operator: OperatorEq, Value: "Apollo"},
+	), JoinQueryOptions{Schema: schema, Dialect: PostgresDialect{}})
+	if err != nil {
+		t.Fatalf("BuildParameterizedSQLJoinQuery failed: %v", err)
+	}
+
+	assertStringContainsAll(t, sql,
+		`FROM "tasks" t0`,
+		`LEFT JOIN "projects" t1 ON t0."project_id" = t1."id"`,
+		`(t0."title" ILIKE $1 ESCAPE '\' AND t1."name" = $2)`,
+	)
+	if len(args) != 2 || args[0] != "%bug%" || args[1] != "Apollo" {
+		t.Fatalf("unexpected args: %#v", args)
+	}
+}
+
+const ticketSchemaYAML = `
+subjects:
+  - name: subject
+    aliases: []
+    validVerbs:
+      - name: contains
+        aliases: []
+    validTypes: [string]
+    table: tickets
+    column: subject
+  - name: customer
+    aliases: []
+    validVerbs:
+      - name: equals
+        aliases: [eq]
+    validTypes: [string]
+    table: customers
+    column: name
+fieldTypes:
+  dateTypes: [opened_at]
+  boolTypes: [closed]
+  numericTypes: [severity]
+  stringTypes: [subject, name]
+rootTable: tickets
+tables:
+  - name: customers
+    primaryKey: id
+  - name: tickets
+    primaryKey: id
+joins:
+  - fromTable: tickets
+    toTable: customers
+    fromKey: customer_id
+    toKey: id
+`
+
+func TestJoinUsesSchemaRootTable(t *testing.T) {
+	schema, err := NewSchemaFromYAML([]byte(ticketSchemaYAML))
+	if err != nil {
+		t.Fatalf("failed to load ticket schema: %v", err)
+	}
+	if schema.RootTable() != "tickets" {
+		t.Fatalf("expected root table tickets, got %s", schema.RootTable())
+	}
+
+	sql := mustBuildJoinSQL(t, schema, &QueryCondition{Field: "customer", Operator: OperatorEq, Value: "Acme"})
+	assertStringContainsAll(t, sql,
+		"SELECT t0.* FROM tickets t0",
+		"LEFT JOIN customers t1 ON t0.customer_id = t1.id",
+		"t1.name = 'Acme'",
+	)
+}
+
+func TestJoinBaseTableOverride(t *testing.T) {
+	schema, err := NewSchemaFromYAML([]byte(ticketSchemaYAML))
+	if err != nil {
+		t.Fatalf("failed to load ticket schema: %v", err)
+	}
+
+	sql, err := BuildSQLJoinQuery(
+		&QueryCondition{Field: "subject", Operator: OperatorCnt, Value: "refund"},
+		JoinQueryOptions{Schema: schema, BaseTable: "customers"},
+	)
+	if err != nil {
+		t.Fatalf("BuildSQLJoinQuery failed: %v", err)
+	}
+	assertStringContainsAll(t, sql,
+		"SELECT t0.* FROM customers t0",
+		"EXISTS (SELECT 1 FROM tickets s1 WHERE s1.customer_id = t0.id AND s1.subject LIKE '%refund%')",
+	)
+
+	if _, err := BuildSQLJoinQuery(
+		&QueryCondition{Field: "subject", Operator: OperatorCnt, Value: "refund"},
+		JoinQueryOptions{Schema: schema, BaseTable: "invoices"},
+	); err == nil {
+		t.Fatalf("expected unknown base table to be rejected")
+	}
+}
+
+const cardinalityTestSchemaYAML = `
+subjects:
+  - name: title
+    aliases: []
+    validVerbs:
+      - name: equals
+        aliases: [eq]
+    validTypes: [string]
+    table: tasks
+    column: title
+  - name: project
+    aliases: []
+    validVerbs:
+      - name: equals
+        aliases: [eq]
+    validTypes: [string]
+    table: projects
+    column: name
+  - name: tag
+    aliases: []
+    validVerbs:
+      - name: equals
+        aliases: [eq]
+    validTypes: [tag]
+    table: tags
+    column: name
+fieldTypes:
+  dateTypes: [due_date]
+  boolTypes: [completed]
+  numericTypes: [priority]
+  stringTypes: [title, name]
+rootTable: tasks
+tables:
+  - name: tasks
+    primaryKey: id
+  - name: projects
+    primaryKey: id
+  - name: tags
+    primaryKey: id
+  - name: task_tags
+    primaryKey: task_id
+joins:
+  - fromTable: tasks
+    toTable: projects
+    fromKey: project_id
+    toKey: id
+    cardinality: many-to-one
+  - fromTable: tasks
+    toTable: task_tags
+    fromKey: id
+    toKey: task_id
+    cardinality: one-to-many
+  - fromTable: task_tags
+    toTable: tags
+    fromKey: tag_id
+    toKey: id
+    cardinality: many-to-one
+`
+
+func TestJoinToManyUsesExists(t *testing.T) {
+	schema, err := NewSchemaFromYAML([]byte(cardinalityTestSchemaYAML))
+	if err != nil {
+		t.Fatalf("failed to load cardinality schema: %v", err)
+	}
+
+	sql := mustBuildJoinSQL(t, schema, NewQueryAnd(
+		NewQueryAnd(
+			&QueryCondition{Field: "tag", Operator: OperatorEq, Value: "a"},
+			&QueryCondition{Field: "tag", Operator: OperatorEq, Value: "b"},
+		),
+		&QueryCondition{Field: "project", Operator: OperatorEq, Value: "Core"},
+	))
+
+	expected := "SELECT t0.* FROM tasks t0 LEFT JOIN projects t1 ON t0.project_id = t1.id WHERE " +
+		"((EXISTS (SELECT 1 FROM task_tags s1 JOIN tags s2 ON s1.tag_id = s2.id WHERE s1.task_id = t0.id AND s2.name = 'a') AND " +
+		"EXISTS (SELECT 1 FROM task_tags s3 JOIN tags s4 ON s3.tag_id = s4.id WHERE s3.task_id = t0.id AND s4.name = 'b')) AND " +
+		"t1.name = 'Core')"
+	if sql != expected {
+		t.Fatalf("BuildSQLJoinQuery returned %q, expected %q", sql, expected)
+	}
+	if strings.Contains(sql, "task_tags t") {
+		t.Fatalf("expected to-many tables not to be joined in the outer query, got: %s", sql)
+	}
+}
+
+func TestJoinToManyNegation(t *testing.T) {
+	schema, err := NewSchemaFromYAML([]byte(cardinalityTestSchemaYAML))
+	if err != nil {
+		t.Fatalf("failed to load cardinality schema: %v", err)
+	}
+
+	sql := mustBuildJoinSQL(t, schema, NewQueryNot(&QueryCondition{Field: "tag", Operator: OperatorEq, Value: "stale"}))
+	assertStringContainsAll(t, sql, "(NOT (EXISTS (SELECT 1 FROM task_tags s1 JOIN tags s2 ON s1.tag_id = s2.id WHERE s1.task_id = t0.id AND s2.name = 'stale')))")
+}
+
+func TestSchemaRejectsUnknownCardinality(t *testing.T) {
+	_, err := loadSchemaConfigFromYAML([]byte(strings.Replace(cardinalityTestSchemaYAML, "cardinality: one-to-many", "cardinality: some", 1)))
+	if err == nil {
+		t.Fatalf("expected unknown cardinality to be rejected")
+	}
+}
@@ -3,6 +3,7 @@ package ntql
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -73,6 +74,54 @@ fieldTypes:
 	}
 }
 
+func TestLoadSchemaConfigFromYAMLVerbValidation(t *testing.T) {
+	subjects := `
+subjects:
+  - name: priority
+    aliases: []
+    validVerbs:
+      - name: startswith
+        aliases: []
+    validTypes: [int]
+fieldTypes:
+  dateTypes: [due_date]
+  boolTypes: [completed]
+  numericTypes: [priority]
+  stringTypes: [title]
+`
+	cases := []struct {
+		verbs string
+		error string
+	}{
+		{"verbs:\n  - name: startswith\n    types: [string]\n", "subject priority verb startswith takes string, but the subject only accepts int"},
+		{"verbs:\n  - name: equals\n    types: [int]\n", "subject priority verb startswith is not declared in verbs"},
+		{"verbs:\n  - name: startswith\n    types: [color]\n", "verb startswith contains unknown type: color"},
+		{"verbs:\n  - name: startswith\n    types: []\n", "verb startswith must define at least one type"},
+		{"verbs:\n  - name: startswith\n    types: [string]\n  - name: startsWith\n    types: [string]\n", "duplicate verb: startsWith"},
+		{"verbs:\n  - name: startswith\n    types: [int]\n", "verb startswith maps to startsWith, which cannot compare int values"},
+		{"verbs:\n  - name: startswith\n    types: [string]\n    operator: like\n", "verb startswith maps to unknown operator: like"},
+		{"verbs:\n  - name: startswith\n    types: [string]\n    operator: startsWith\n    sql: \"{field} LIKE {value}\"\n", "verb startswith declares both an operator and a sql template"},
+		{"verbs:\n  - name: startswith\n    types: [string]\n    sql: \"{field} LIKE 'x'\"\n", "verb startswith sql template must contain {field} and {value}"},
+		{"verbs:\n  - name: startswith\n    types: [string]\n    sql: \"{field} LIKE {value}\"\n", "verb startswith with a sql template cannot be named after an operator"},
+		{"verbs:\n  - name: prefix\n    types: [string]\n", "verb prefix does not name an operator"},
+	}
+	for _, tc := range cases {
+		_, err := loadSchemaConfigFromYAML([]byte(subjects + tc.verbs))
+		if err == nil || !strings.Contains(err.Error(), tc.error) {
+			t.Errorf("expected an error containing %q, got %v", tc.error, err)
+		}
+	}
+
+	if _, err := loadSchemaConfigFromYAML([]byte(subjects)); err != nil {
+		t.Fatalf("expected a schema without verbs to load, got %v", err)
+	}
+	// Without a verbs section, every verb must be named after an operator.
+	_, err := loadSchemaConfigFromYAML([]byte(strings.Replace(subjects, "name: startswith", "name: matches", 1)))
+	if expected := "subject priority verb matches is not an operator name and is not declared in verbs"; err == nil || err.Error() != expected {
+		t.Fatalf("expected %q, got %v", expected, err)
+	}
+}
+
 func TestLoadSchemaFromFile(t *testing.T) {
 	tempDir := t.TempDir()
 	path := filepath.Join(tempDir, "schema.yaml")
@@ -111,3 +160,154 @@ fieldTypes:
 		t.Fatalf("expected alias to map to loaded subject, got %s", subject.Name)
 	}
 }
+
+func TestSchemaInstancesAreIndependent(t *testing.T) {
+	other, err := NewSchemaFromYAML([]byte(`
+subjects:
+  - name: only
+    aliases: [single]
+    validVerbs:
+      - name: equals
+        aliases: [eq]
+    validTypes: [string]
+fieldTypes:
+  dateTypes: [due_date]
+  boolTypes: [completed]
+  numericTypes: [priority]
+  stringTypes: [title]
+`))
+	if err != nil {
+		t.Fatalf("expected schema to build, got error: %v", err)
+	}
+
+	if _, err := NewLexerWithSchema(other, `single.eq("x")`).Lex(); err != nil {
+		t.Fatalf("expected query to lex against its own schema, got error: %v", err)
+	}
+	if _, err := NewLexerWithSchema(other, `title.eq("x")`).Lex(); err == nil {
+		t.Fatalf("expected subject from another schema to be rejected")
+	}
+	if _, err := NewLexer(`title.eq("x")`).Lex(); err != nil {
+		t.Fatalf("expected default schema to be unaffected, got error: %v", err)
+	}
+	if _, err := DefaultSchema().Subject("single"); err == nil {
+		t.Fatalf("expected default schema not to know subjects of another schema")
+	}
+}
+
+func TestNewSchemaFromDefinition(t *testing.T) {
+	def := DefaultSchema().Definition()
+	def.ValidSubjects = append(def.ValidSubjects, Subject{
+		Name:       "estimate",
+		ValidVerbs: []Verb{{Name: "equals", Aliases: []string{"eq"}}},
+		ValidTypes: []DType{DTypeInt},
+		Table:      "tasks",
+		Column:     "estimate",
+	})
+
+	schema, err := NewSchema(def)
+	if err != nil {
+		t.Fatalf("expected schema to build from definition, got error: %v", err)
+	}
+	if _, err := schema.Subject("estimate"); err != nil {
+		t.Fatalf("expected new subject to resolve, got error: %v", err)
+	}
+	if types, ok := schema.verbTypes("contains"); !ok || joinDTypes(types) != "string" {
+		t.Fatalf("expected the verb types to be kept, got %v", types)
+	}
+	if _, err := DefaultSchema().Subject("estimate"); err == nil {
+		t.Fatalf("expected modifying a definition not to affect the schema it came from")
+	}
+
+	def.ValidSubjects = append(def.ValidSubjects, Subject{Name: "estimate"})
+	if _, err := NewSchema(def); err == nil {
+		t.Fatalf("expected definition with duplicate subject to fail validation")
+	}
+}
+
+func TestSchemaAcceptsFloatTypes(t *testing.T) {
+	schema, err := NewSchemaFromYAML([]byte(numericSchemaYAML))
+	if err != nil {
+		t.Fatalf("NewSchemaFromYAML() failed: %v", err)
+	}
+	subject, err := schema.Subject("estimate")
+	if err != nil {
+		t.Fatalf("Subject() failed: %v", err)
+	}
+	if len(subject.ValidTypes) != 1 || subject.ValidTypes[0] != DTypeFloat {
+		t.Fatalf("expected decimal to map to DTypeFloat, got %v", subject.ValidTypes)
+	}
+	// The definition writes the type back as float, which must load again.
+	if _, err := NewSchema(schema.Definition()); err != nil {
+		t.Fatalf("NewSchema() failed for float subject: %v", err)
+	}
+}
+
+func TestSchemaVerbSQLTemplate(t *testing.T) {
+	schema, err := NewSchemaFromYAML([]byte(`
+subjects:
+  - name: title
+    aliases: []
+    validVerbs:
+      - name: equals
+        aliases: [eq]
+      - name: like
+        aliases: []
+    validTypes: [string]
+    table: tasks
+    column: title
+verbs:
+  - name: equals
+    operator: equals
+    types: [string]
+  - name: like
+    sql: "lower({field}) LIKE lower({value})"
+    types: [string]
+fieldTypes:
+  dateTypes: [due_date]
+  boolTypes: [completed]
+  numericTypes: [priority]
+  stringTypes: [title]
+rootTable: tasks
+tables:
+  - name: tasks
+    primaryKey: id
+`))
+	if err != nil {
+		t.Fatalf("failed to load schema: %v", err)
+	}
+
+	expr, diagnostics := ParseWithRecovery(schema, `title.like("Abc") AND title.eq("b")`)
+	if len(diagnostics) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", diagnostics)
+	}
+	sql, _, err := ToSQLWithOptions(expr, SQLOptions{Schema: schema})
+	if expected := "(lower(title) LIKE lower('Abc') AND title = 'b')"; err != nil || sql != expected {
+		t.Fatalf("expected %s, got %s, %v", expected, sql, err)
+	}
+	sql, err = BuildSQLJoinQuery(expr, JoinQueryOptions{Schema: schema})
+	if err != nil || !strings.Contains(sql, "lower(t0.title) LIKE lower('Abc')") {
+		t.Fatalf("unexpected join query: %s, %v", sql, err)
+	}
+	if formatted, err := FormatWithSchema(schema, expr); err != nil || formatted != `title.like("Abc") AND title.equals("b")` {
+		t.Fatalf("unexpected format: %s, %v", formatted, err)
+	}
+	if _, err := Evaluate(expr, MapRecord{"title": "abc"}); err == nil {
+		t.Fatalf("expected a verb with a SQL template not to be evaluated in memory")
+	}
+
+	value := &ValueBinaryOp{Left: &Value{Value: "a"}, Right: &Value{Value: "b"}, Operator: OperatorOr}
+	transformed, err := TransformWithSchema(schema, value, "title", "like")
+	if err != nil {
+		t.Fatalf("Transform() failed: %v", err)
+	}
+	sql, _, err = ToSQLWithOptions(transformed, SQLOptions{Schema: schema})
+	if expected := "(lower(title) LIKE lower('a') OR lower(title) LIKE lower('b'))"; err != nil || sql != expected {
+		t.Fatalf("expected %s, got %s, %v", expected, sql, err)
+	}
+	if _, err := value.Transform("title", "like"); err == nil {
+		t.Fatalf("expected the default schema not to have the verb like")
+	}
+	if _, err := TransformWithSchema(nil, value, "title", "like"); err == nil {
+		t.Fatalf("expected a nil schema to mean the default schema")
+	}
+}
@@ -168,16 +168,12 @@ func TestSchemaTableMapping(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed loading schema config: %v", err)
 	}
-	if err := applySchemaConfig(cfg); err != nil {
+	schema, err := newSchemaFromConfig(cfg)
+	if err != nil {
 		t.Fatalf("failed applying schema config: %v", err)
 	}
-	t.Cleanup(func() {
-		if err := LoadEmbeddedSchema(); err != nil {
-			t.Fatalf("failed to restore embedded schema: %v", err)
-		}
-	})
 
-	subject, err := getSubject("owner")
+	subject, err := schema.Subject("owner")
 	if err != nil {
 		t.Fatalf("expected owner alias to resolve, got: %v", err)
 	}
@@ -197,16 +193,12 @@ func TestSchemaFieldTypeConsistency(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed loading schema config: %v", err)
 	}
-	if err := applySchemaConfig(cfg); err != nil {
+	schema, err := newSchemaFromConfig(cfg)
+	if err != nil {
 		t.Fatalf("failed applying schema config: %v", err)
 	}
-	t.Cleanup(func() {
-		if err := LoadEmbeddedSchema(); err != nil {
-			t.Fatalf("failed to restore embedded schema: %v", err)
-		}
-	})
 
-	loaded := GetLoadedSchema()
+	loaded := schema.Definition()
 	if len(loaded.DateTypes) == 0 || len(loaded.BoolTypes) == 0 || len(loaded.NumericTypes) == 0 || len(loaded.StringTypes) == 0 {
 		t.Fatalf("expected all field type arrays to be populated")
 	}
@@ -217,16 +209,12 @@ func TestSchemaSubjectAliases(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed loading schema config: %v", err)
 	}
-	if err := applySchemaConfig(cfg); err != nil {
+	schema, err := newSchemaFromConfig(cfg)
+	if err != nil {
 		t.Fatalf("failed applying schema config: %v", err)
 	}
-	t.Cleanup(func() {
-		if err := LoadEmbeddedSchema(); err != nil {
-			t.Fatalf("failed to restore embedded schema: %v", err)
-		}
-	})
 
-	subject, err := getSubject("deadline")
+	subject, err := schema.Subject("deadline")
 	if err != nil {
 		t.Fatalf("expected alias deadline to resolve, got: %v", err)
 	}
@@ -240,16 +228,12 @@ func TestSchemaVerbAliases(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed loading schema config: %v", err)
 	}
-	if err := applySchemaConfig(cfg); err != nil {
+	schema, err := newSchemaFromConfig(cfg)
+	if err != nil {
 		t.Fatalf("failed applying schema config: %v", err)
 	}
-	t.Cleanup(func() {
-		if err := LoadEmbeddedSchema(); err != nil {
-			t.Fatalf("failed to restore embedded schema: %v", err)
-		}
-	})
 
-	parser := NewParser([]Token{
+	parser := NewParserWithSchema(schema, []Token{
 		{Kind: TokenSubject, Literal: "due"},
 		{Kind: TokenDot, Literal: ""},
 		{Kind: TokenVerb, Literal: "eq"},
@@ -345,3 +329,32 @@ func TestSchemaCircularDependencies(t *testing.T) {
 func TestSchemaMultipleJoinPaths(t *testing.T) {
 	t.Skip("placeholder for future multiple join path tests")
 }
+
+func TestSchemaRejectsUnknownRootTable(t *testing.T) {
+	_, err := loadSchemaConfigFromYAML([]byte(validationTestSchemaYAML + "rootTable: documents\n"))
+	if err == nil {
+		t.Fatalf("expected unknown root table to be rejected")
+	}
+}
+
+func TestSchemaRejectsUnreachableSubjectTable(t *testing.T) {
+	cfg, err := loadSchemaConfigFromYAML([]byte(validationTestSchemaYAML))
+	if err != nil {
+		t.Fatalf("failed loading schema config: %v", err)
+	}
+	cfg.Joins = nil
+
+	if err := validateSchemaConfig(cfg); err == nil {
+		t.Fatalf("expected subject on a table without a join path from the root to be rejected")
+	}
+}
+
+func TestSchemaRootTableDefaultsToFirstTable(t *testing.T) {
+	schema, err := NewSchemaFromYAML([]byte(validationTestSchemaYAML))
+	if err != nil {
+		t.Fatalf("failed loading schema: %v", err)
+	}
+	if schema.RootTable() != "tasks" {
+		t.Fatalf("expected root table to default to tasks, got %s", schema.RootTable())
+	}
+}
@@ -0,0 +1,278 @@
+package ntql
+
+import (
+	"errors"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var sqlInlineStringRegexp = regexp.MustCompile(`^[a-zA-Z0-9\-/: ]+$`)
+
+// SQLOptions controls how a query expression is converted to SQL.
+type SQLOptions struct {
+	// Dialect selects the database engine to generate SQL for. GenericDialect
+	// is used when it is nil.
+	Dialect Dialect
+	// Parameterized replaces every value with a placeholder and returns the
+	// values as bind arguments instead of writing them into the SQL text.
+	Parameterized bool
+	// Clock resolves relative dates such as today or startOfWeek.
+	Clock Clock
+	// Schema decides the type of each field. The default schema is used when
+	// it is nil.
+	Schema *Schema
+}
+
+// sqlWriter renders values while a query expression is converted to SQL.
+// When parameterized is false, values are written into the SQL text and
+// strings are restricted to a conservative character set. When it is true,
+// every value is replaced with a placeholder and collected in args, so any
+// string can be passed through safely.
+type sqlWriter struct {
+	dialect       Dialect
+	parameterized bool
+	clock         Clock
+	schema        *Schema
+	args          []any
+}
+
+func newSQLWriter(opts SQLOptions) *sqlWriter {
+	dialect := opts.Dialect
+	if dialect == nil {
+		dialect = GenericDialect{}
+	}
+	schema := opts.Schema
+	if schema == nil {
+		schema = DefaultSchema()
+	}
+	return &sqlWriter{dialect: dialect, parameterized: opts.Parameterized, clock: opts.Clock, schema: schema}
+}
+
+// writeSQL converts expr to SQL with the given options.
+func writeSQL(expr QueryExpr, opts SQLOptions) (string, []any, error) {
+	w := newSQLWriter(opts)
+	sql, err := w.write(expr)
+	if err != nil {
+		return "", nil, err
+	}
+	return sql, w.args, nil
+}
+
+// write converts a node of any type to SQL. Nodes defined outside the package
+// are written with their own ToSQL.
+func (w *sqlWriter) write(expr QueryExpr) (string, error) {
+	switch e := expr.(type) {
+	case *QueryCondition:
+		return e.writeSQL(w)
+	case *QueryBinaryOp:
+		return e.writeSQL(w)
+	case *QueryUnaryOp:
+		return e.writeSQL(w)
+	case *QueryError:
+		return e.writeSQL(w)
+	case nil:
+		return "", errors.New("query expression cannot be nil")
+	}
+	return expr.ToSQL()
+}
+
+func (w *sqlWriter) bind(value any) string {
+	w.args = append(w.args, value)
+	return w.dialect.Placeholder(len(w.args))
+}
+
+// ident quotes a table or column name for the dialect.
+func (w *sqlWriter) ident(name string) string {
+	return w.dialect.QuoteIdentifier(name)
+}
+
+// stringValue renders a string value for an equality comparison.
+func (w *sqlWriter) stringValue(c *QueryCondition) (string, error) {
+	if w.parameterized {
+		return w.bind(c.Value), nil
+	}
+	if !sqlInlineStringRegexp.MatchString(c.Value) {
+		return "", errors.New("invalid value: " + c.Value + " for field: " + c.Field)
+	}
+	return "'" + c.Value + "'", nil
+}
+
+// like renders a LIKE comparison. The value is matched literally, surrounded by
+// the given wildcard prefix and suffix.
+func (w *sqlWriter) like(fieldRef string, c *QueryCondition, prefix, suffix string) (string, error) {
+	if w.parameterized {
+		return w.dialect.Like(fieldRef, w.bind(prefix+escapeLike(c.Value)+suffix)) + " " + w.dialect.LikeEscape(), nil
+	}
+	if !sqlInlineStringRegexp.MatchString(c.Value) {
+		return "", errors.New("invalid value: " + c.Value + " for field: " + c.Field)
+	}
+	return w.dialect.Like(fieldRef, "'"+prefix+c.Value+suffix+"'"), nil
+}
+
+// dateValue renders a date or date-time value in ISO 8601 format. Relative
+// dates are resolved against the writer's clock first.
+func (w *sqlWriter) dateValue(c *QueryCondition) (string, error) {
+	literal := c.TypedValue()
+	value := literal.String()
+	var dtype DType
+	switch literal.Kind() {
+	case LiteralRelativeDate:
+		t, resolved, err := resolveRelativeDate(value, w.clock)
+		if err != nil {
+			return "", err
+		}
+		value, dtype = formatResolvedDate(t, resolved), resolved
+	case LiteralDate:
+		dtype = DTypeDate
+	case LiteralDateTime:
+		dtype = DTypeDateTime
+	default:
+		return "", errors.New("invalid value: " + c.Value + " for field: " + c.Field)
+	}
+	if w.parameterized {
+		return w.dialect.DateLiteral(w.bind(value), dtype), nil
+	}
+	return w.dialect.DateLiteral("'"+value+"'", dtype), nil
+}
+
+// intValue renders an integer value. Values that do not fit in 64 bits are
+// rejected.
+func (w *sqlWriter) intValue(c *QueryCondition) (string, error) {
+	literal := c.TypedValue()
+	n, err := literal.Int(), error(nil)
+	if literal.Kind() != LiteralInt {
+		n, err = strconv.ParseInt(c.Value, 10, 64)
+	}
+	if errors.Is(err, strconv.ErrRange) {
+		return "", errors.New("value out of range: " + c.Value + " for field: " + c.Field)
+	}
+	if err != nil {
+		return "", errors.New("invalid value: " + c.Value + " for field: " + c.Field)
+	}
+	if w.parameterized {
+		return w.bind(n), nil
+	}
+	return strconv.FormatInt(n, 10), nil
+}
+
+// floatValue renders a decimal value. Values outside the range of a float64,
+// infinities and NaN are rejected.
+func (w *sqlWriter) floatValue(c *QueryCondition) (string, error) {
+	literal := c.TypedValue()
+	f, err := literal.Float(), error(nil)
+	if literal.Kind() != LiteralDecimal && literal.Kind() != LiteralInt {
+		f, err = strconv.ParseFloat(c.Value, 64)
+	}
+	if errors.Is(err, strconv.ErrRange) || math.IsInf(f, 0) {
+		return "", errors.New("value out of range: " + c.Value + " for field: " + c.Field)
+	}
+	if err != nil || math.IsNaN(f) {
+		return "", errors.New("invalid value: " + c.Value + " for field: " + c.Field)
+	}
+	if w.parameterized {
+		return w.bind(f), nil
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64), nil
+}
+
+// boolValue renders a boolean value.
+func (w *sqlWriter) boolValue(c *QueryCondition) (string, error) {
+	literal := c.TypedValue()
+	b, err := literal.Bool(), error(nil)
+	if literal.Kind() != LiteralBool {
+		b, err = strconv.ParseBool(c.Value)
+	}
+	if err != nil {
+		return "", errors.New("invalid value: " + c.Value + " for field: " + c.Field)
+	}
+	if w.parameterized {
+		return w.bind(b), nil
+	}
+	return w.dialect.BoolLiteral(b), nil
+}
+
+// tagSubquery wraps a condition on the tag table in the subquery that matches
+// it against the row's tag.
+func (w *sqlWriter) tagSubquery(cond string) string {
+	return w.ident("tag_id") + " = (SELECT " + w.ident("id") + " FROM " + w.ident("atomic_tags") + " WHERE " + cond + ")"
+}
+
+// escapeLike escapes the LIKE wildcards in s, using backslash as the escape
+// character.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// typedValue renders the condition's value as a value of type dtype.
+func (w *sqlWriter) typedValue(c *QueryCondition, dtype DType) (string, error) {
+	switch dtype {
+	case DTypeDate, DTypeDateTime:
+		return w.dateValue(c)
+	case DTypeInt:
+		return w.intValue(c)
+	case DTypeFloat:
+		return w.floatValue(c)
+	default:
+		return w.stringValue(c)
+	}
+}
+
+// writeTypedCondition renders a comparison between fieldRef and the condition's
+// value, choosing the SQL operators allowed for dtype. Verbs with a SQL
+// template in the schema are rendered with the template instead.
+func writeTypedCondition(w *sqlWriter, c *QueryCondition, fieldRef string, dtype DType) (string, error) {
+	if template, ok := w.schema.verbSQL(c.Operator); ok {
+		value, err := w.typedValue(c, dtype)
+		if err != nil {
+			return "", err
+		}
+		return strings.NewReplacer("{field}", fieldRef, "{value}", value).Replace(template), nil
+	}
+	switch dtype {
+	case DTypeDate, DTypeDateTime, DTypeInt, DTypeFloat:
+		value, err := w.typedValue(c, dtype)
+		if err != nil {
+			return "", err
+		}
+		return writeComparison(c, fieldRef, value)
+	default:
+		switch c.Operator {
+		case OperatorEq, OperatorNeq:
+			value, err := w.stringValue(c)
+			if err != nil {
+				return "", err
+			}
+			return writeComparison(c, fieldRef, value)
+		case OperatorCnt:
+			return w.like(fieldRef, c, "%", "%")
+		case OperatorSW:
+			return w.like(fieldRef, c, "", "%")
+		case OperatorEw:
+			return w.like(fieldRef, c, "%", "")
+		default:
+			return "", errors.New("invalid operator: " + c.Operator.ToStr() + " for field: " + c.Field)
+		}
+	}
+}
+
+// writeComparison renders fieldRef compared to an already rendered value.
+func writeComparison(c *QueryCondition, fieldRef string, value string) (string, error) {
+	switch c.Operator {
+	case OperatorEq:
+		return fieldRef + " = " + value, nil
+	case OperatorNeq:
+		return fieldRef + " != " + value, nil
+	case OperatorGt:
+		return fieldRef + " > " + value, nil
+	case OperatorLT:
+		return fieldRef + " < " + value, nil
+	case OperatorGte:
+		return fieldRef + " >= " + value, nil
+	case OperatorLte:
+		return fieldRef + " <= " + value, nil
+	default:
+		return "", errors.New("invalid operator: " + c.Operator.ToStr() + " for field: " + c.Field)
+	}
+}
@@ -19,6 +19,10 @@ const (
 	TokenRParen
 	TokenAnd
 	TokenOr
+	TokenFloat
+	TokenRelativeDate
+	// TokenInvalid marks a lexeme that was skipped by LexWithRecovery.
+	TokenInvalid
 )
 
 // type TokenType int
@@ -45,8 +49,16 @@ func (t TokenType) String() string {
 		return "String"
 	case TokenInt:
 		return "Number"
+	case TokenFloat:
+		return "Decimal"
 	case TokenDate:
 		return "Date"
+	case TokenDateTime:
+		return "DateTime"
+	case TokenRelativeDate:
+		return "RelativeDate"
+	case TokenInvalid:
+		return "Invalid"
 	case TokenAnd:
 		return "AND"
 	case TokenOr:
@@ -58,13 +70,16 @@ func (t TokenType) String() string {
 // Example: tag.equals(hello OR goodbye)
 // date.before(2024-01-08) AND date.after(2024-01-09)
 type Token struct {
-	Kind     TokenType
-	Literal  string
+	Kind    TokenType
+	Literal string
+	// Position is the byte offset of the first character of the token in the
+	// query, and End the offset just past its last character.
 	Position int
+	End      int
 }
 
-func createToken(literal string, token TokenType, position int) Token {
-	return Token{Kind: token, Literal: literal, Position: position}
+func createToken(literal string, token TokenType, position int, end int) Token {
+	return Token{Kind: token, Literal: literal, Position: position, End: end}
 }
 
 func (t Token) String() string {
@@ -0,0 +1,132 @@
+package ntql
+
+import (
+	"fmt"
+	"slices"
+)
+
+// TypeError reports a value of a type that a subject and verb do not both
+// accept, such as a number given to a verb that only takes strings.
+type TypeError struct {
+	Subject string
+	Verb    string
+	// Accepted lists the types the subject accepts for the verb.
+	Accepted []DType
+	Got      LiteralKind
+	Value    string
+}
+
+func (e *TypeError) Error() string {
+	if len(e.Accepted) == 0 {
+		return fmt.Sprintf("Type mismatch: %s.%s takes none of the subject's types, not %s %s", e.Subject, e.Verb, e.Got, e.Value)
+	}
+	return fmt.Sprintf("Type mismatch: %s.%s takes %s, not %s %s", e.Subject, e.Verb, joinDTypes(e.Accepted), e.Got, e.Value)
+}
+
+// TypeCheck checks that the value of every condition in expr is of a type its
+// subject accepts for its verb, as declared by the subject's validTypes and the
+// schema's verbs. It returns the first *TypeError found, or an error if a
+// condition's subject or operator is not in schema.
+func TypeCheck(schema *Schema, expr QueryExpr) error {
+	return Walk(expr, VisitorFuncs{EnterFunc: func(expr QueryExpr) error {
+		c, ok := expr.(*QueryCondition)
+		if !ok {
+			return nil
+		}
+		subject, err := schema.Subject(c.Field)
+		if err != nil {
+			return fmt.Errorf("unknown subject: %s%s", c.Field, didYouMean(schema.closestSubjects(c.Field)))
+		}
+		verb, _, ok := schema.conditionVerb(subject, c.Operator)
+		if !ok {
+			return fmt.Errorf("subject %s has no verb for operator %s", subject.Name, c.Operator)
+		}
+		_, err = schema.checkValue(subject, verb, c)
+		return err
+	}})
+}
+
+// acceptedTypes returns the types subject accepts for verb: its valid types,
+// narrowed to the verb's declared types. A verb the schema does not declare
+// takes every type of the subject that its operator can compare, and an empty
+// verb every type of the subject.
+func (s *Schema) acceptedTypes(subject *Subject, verb string) []DType {
+	if verb == "" {
+		return subject.ValidTypes
+	}
+	takes := func(DType) bool { return true }
+	if types, ok := s.verbTypes(verb); ok {
+		takes = func(dtype DType) bool { return slices.Contains(types, dtype) }
+	} else if op, err := s.verbOperator(verb); err == nil {
+		takes = func(dtype DType) bool { return operatorTakes(op, dtype) }
+	}
+	var accepted []DType
+	for _, dtype := range subject.ValidTypes {
+		if takes(dtype) {
+			accepted = append(accepted, dtype)
+		}
+	}
+	return accepted
+}
+
+// checkValue returns the type, among those subject accepts for verb, that
+// the value of c is read as, or a *TypeError if there is none. Every item of a
+// list must be accepted; the type of the last one is returned.
+func (s *Schema) checkValue(subject *Subject, verb string, c *QueryCondition) (DType, error) {
+	literal := c.TypedValue()
+	if literal.Kind() == LiteralList {
+		var dtype DType
+		for _, item := range literal.Items() {
+			var err error
+			if dtype, err = s.checkValue(subject, verb, NewTypedCondition(c.Field, c.Operator, item)); err != nil {
+				return 0, err
+			}
+		}
+		return dtype, nil
+	}
+	accepted := s.acceptedTypes(subject, verb)
+	kinds := []LiteralKind{literal.Kind()}
+	if c.Literal.lexed != LiteralInvalid && c.Literal.String() == c.Value {
+		// A value its lexed kind cannot hold is checked as that kind, so that
+		// the SQL writer reports it as out of range.
+		kinds = []LiteralKind{c.Literal.lexed}
+	} else if c.Literal.Kind() == LiteralInvalid || c.Literal.String() != c.Value {
+		// A value without a literal may also be meant as text, as in the
+		// formatter, which quotes it for string subjects.
+		kinds = append(kinds, LiteralString, LiteralTag)
+	}
+	for _, kind := range kinds {
+		for _, dtype := range accepted {
+			if kindHasType(kind, dtype) {
+				return dtype, nil
+			}
+		}
+	}
+	if verb == "" {
+		verb = c.Operator.ToStr()
+	}
+	return 0, &TypeError{Subject: subject.Name, Verb: verb, Accepted: accepted, Got: kinds[0], Value: c.Value}
+}
+
+// kindHasType reports whether a value of the given kind can be compared with a
+// field of type dtype. Ints are accepted as decimals, and relative dates as
+// either dates or date-times. Bools and lists have no schema type.
+func kindHasType(kind LiteralKind, dtype DType) bool {
+	switch kind {
+	case LiteralString:
+		return dtype == DTypeString
+	case LiteralTag:
+		return dtype == DTypeTag
+	case LiteralInt:
+		return dtype == DTypeInt || dtype == DTypeFloat
+	case LiteralDecimal:
+		return dtype == DTypeFloat
+	case LiteralDate:
+		return dtype == DTypeDate
+	case LiteralDateTime:
+		return dtype == DTypeDateTime
+	case LiteralRelativeDate:
+		return dtype == DTypeDate || dtype == DTypeDateTime
+	}
+	return false
+}
@@ -0,0 +1,188 @@
+package ntql
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const typeCheckSchemaYAML = `
+subjects:
+  - name: code
+    aliases: []
+    validVerbs:
+      - name: equals
+        aliases: [eq]
+      - name: contains
+        aliases: []
+    validTypes: [string, int]
+    table: tasks
+    column: code
+  - name: due
+    aliases: []
+    validVerbs:
+      - name: before
+        aliases: []
+    validTypes: [date, dateTime]
+    table: tasks
+    column: due_date
+verbs:
+  - name: equals
+    types: [string, int]
+  - name: contains
+    types: [string]
+  - name: before
+    types: [date, dateTime]
+fieldTypes:
+  dateTypes: [due_date]
+  boolTypes: [completed]
+  numericTypes: [code]
+  stringTypes: [code]
+rootTable: tasks
+tables:
+  - name: tasks
+    primaryKey: id
+`
+
+func loadTypeCheckSchema(t *testing.T) *Schema {
+	t.Helper()
+	schema, err := NewSchemaFromYAML([]byte(typeCheckSchemaYAML))
+	if err != nil {
+		t.Fatalf("failed to load type check schema: %v", err)
+	}
+	return schema
+}
+
+func TestParserTypeCheck(t *testing.T) {
+	schema := loadTypeCheckSchema(t)
+
+	valid := []string{`code.eq(5)`, `code.eq("a")`, `code.contains("a")`, `due.before(today)`}
+	for _, query := range valid {
+		if _, diagnostics := ParseWithRecovery(schema, query); len(diagnostics) != 0 {
+			t.Errorf("expected %s to type check, got %v", query, diagnostics)
+		}
+	}
+
+	query := `code.contains("a" OR 5)`
+	_, diagnostics := ParseWithRecovery(schema, query)
+	if len(diagnostics) != 1 {
+		t.Fatalf("expected one diagnostic, got %v", diagnostics)
+	}
+	d := diagnostics[0]
+	if d.Code != CodeTypeMismatch || query[d.Start:d.End] != "5" {
+		t.Fatalf("expected a type mismatch at 5, got %#v", d)
+	}
+	if expected := "Type mismatch: code.contains takes string, not int 5"; d.Message != expected {
+		t.Fatalf("expected %q, got %q", expected, d.Message)
+	}
+}
+
+func TestTypeCheck(t *testing.T) {
+	schema := loadTypeCheckSchema(t)
+
+	if err := TypeCheck(schema, NewQueryAnd(
+		NewTypedCondition("code", OperatorEq, NewIntLiteral(5)),
+		&QueryCondition{Field: "code", Operator: OperatorCnt, Value: "5"},
+	)); err != nil {
+		t.Fatalf("expected the query to type check, got %v", err)
+	}
+
+	err := TypeCheck(schema, NewQueryNot(NewTypedCondition("code", OperatorCnt, NewIntLiteral(5))))
+	var typeErr *TypeError
+	if !errors.As(err, &typeErr) {
+		t.Fatalf("expected a *TypeError, got %v", err)
+	}
+	if typeErr.Subject != "code" || typeErr.Verb != "contains" || typeErr.Got != LiteralInt || joinDTypes(typeErr.Accepted) != "string" {
+		t.Fatalf("unexpected type error: %#v", typeErr)
+	}
+
+	cases := []struct {
+		expr  QueryExpr
+		error string
+	}{
+		{NewTypedCondition("due", OperatorLT, NewDecimalLiteral(1.5)), "due.before takes date | dateTime, not decimal 1.5"},
+		{NewTypedCondition("code", OperatorEq, NewListLiteral(NewIntLiteral(1), NewBoolLiteral(true))), "code.equals takes string | int, not bool true"},
+		{NewTypedCondition("code", OperatorGt, NewIntLiteral(1)), "subject code has no verb for operator greaterThan"},
+		{NewTypedCondition("cod", OperatorEq, NewIntLiteral(1)), "unknown subject: cod (did you mean code?)"},
+	}
+	for _, tc := range cases {
+		if err := TypeCheck(schema, tc.expr); err == nil || !strings.Contains(err.Error(), tc.error) {
+			t.Errorf("expected an error containing %q for %s, got %v", tc.error, tc.expr, err)
+		}
+	}
+}
+
+func TestJoinQueryUsesValueType(t *testing.T) {
+	schema := loadTypeCheckSchema(t)
+
+	sql, err := BuildSQLJoinQuery(NewTypedCondition("code", OperatorEq, NewIntLiteral(5)), JoinQueryOptions{Schema: schema})
+	if err != nil || !strings.Contains(sql, "t0.code = 5") {
+		t.Fatalf("expected an int comparison, got %s, %v", sql, err)
+	}
+	sql, err = BuildSQLJoinQuery(NewTypedCondition("code", OperatorEq, NewStringLiteral("5")), JoinQueryOptions{Schema: schema})
+	if err != nil || !strings.Contains(sql, "t0.code = '5'") {
+		t.Fatalf("expected a string comparison, got %s, %v", sql, err)
+	}
+	if _, err := BuildSQLJoinQuery(NewTypedCondition("code", OperatorCnt, NewIntLiteral(5)), JoinQueryOptions{Schema: schema}); err == nil {
+		t.Fatalf("expected a type error before any SQL is produced")
+	}
+}
+
+func TestTypeCheckOutOfRangeInt(t *testing.T) {
+	query := `priority.eq(99999999999999999999)`
+	expr, err := parseQuery(query)
+	if err != nil {
+		t.Fatalf("expected %s to type check as an int, got %v", query, err)
+	}
+	expected := "value out of range: 99999999999999999999 for field: priority"
+	if _, err := expr.ToSQL(); err == nil || err.Error() != expected {
+		t.Fatalf("expected %q, got %v", expected, err)
+	}
+	for _, output := range []OutputMode{OutputSelect, OutputWhere} {
+		if _, err := Compile(context.Background(), query, CompileOptions{Output: output}); err == nil || err.Error() != expected {
+			t.Fatalf("expected %q from output mode %d, got %v", expected, output, err)
+		}
+	}
+}
+
+func TestTypeCheckImplicitVerbOperator(t *testing.T) {
+	schema, err := NewSchemaFromYAML([]byte(`
+subjects:
+  - name: priority
+    aliases: []
+    validVerbs:
+      - name: equals
+        aliases: []
+      - name: contains
+        aliases: []
+    validTypes: [int]
+    table: tasks
+    column: priority
+fieldTypes:
+  dateTypes: [due_date]
+  boolTypes: [completed]
+  numericTypes: [priority]
+  stringTypes: [title]
+rootTable: tasks
+tables:
+  - name: tasks
+    primaryKey: id
+`))
+	if err != nil {
+		t.Fatalf("failed to load schema: %v", err)
+	}
+
+	expected := "Type mismatch: priority.contains takes none of the subject's types, not int 3"
+	_, diagnostics := ParseWithRecovery(schema, `priority.contains(3)`)
+	if len(diagnostics) != 1 || diagnostics[0].Code != CodeTypeMismatch || diagnostics[0].Message != expected {
+		t.Fatalf("expected a type mismatch %q, got %v", expected, diagnostics)
+	}
+	err = TypeCheck(schema, NewTypedCondition("priority", OperatorCnt, NewIntLiteral(3)))
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected %q, got %v", expected, err)
+	}
+	if err := TypeCheck(schema, NewTypedCondition("priority", OperatorEq, NewIntLiteral(3))); err != nil {
+		t.Fatalf("expected equals to take an int, got %v", err)
+	}
+}
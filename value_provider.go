@@ -0,0 +1,195 @@
+package ntql
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ValueRequest describes the values a completion engine asks a ValueProvider
+// for.
+type ValueRequest struct {
+	// Subject is the canonical name of the subject the value is for.
+	Subject string
+	// Prefix is the part of the value typed so far, without quotes. Providers
+	// may use it to narrow a lookup; the engine filters the results by it
+	// either way.
+	Prefix string
+	// Limit is the most values the engine will use, or zero for no limit.
+	Limit int
+}
+
+// ValueProvider supplies the values suggested inside a subject's function
+// call, such as the distinct statuses or project names in a database.
+type ValueProvider interface {
+	Values(ctx context.Context, req ValueRequest) ([]string, error)
+}
+
+// ValueProviderFunc adapts a function to a ValueProvider.
+type ValueProviderFunc func(ctx context.Context, req ValueRequest) ([]string, error)
+
+func (f ValueProviderFunc) Values(ctx context.Context, req ValueRequest) ([]string, error) {
+	return f(ctx, req)
+}
+
+// StaticValues returns a ValueProvider that always offers values.
+func StaticValues(values ...string) ValueProvider {
+	return ValueProviderFunc(func(ctx context.Context, req ValueRequest) ([]string, error) {
+		return values, nil
+	})
+}
+
+// CompletionOptions configures a completion engine.
+type CompletionOptions struct {
+	// Tags are suggested for tag values of subjects without a provider.
+	Tags []string
+	// ValueProviders maps subject names or aliases to the provider of their
+	// values.
+	ValueProviders map[string]ValueProvider
+	// MaxValues limits the values suggested from a provider. Zero means no
+	// limit.
+	MaxValues int
+	// CacheTTL is how long provider results are reused for the same subject
+	// and prefix. Results are not cached when it is zero. The cache holds a
+	// bounded number of prefixes, dropping the oldest when it is full.
+	CacheTTL time.Duration
+}
+
+// maxValueCacheEntries bounds the value cache, which would otherwise keep
+// every prefix typed in a long editing session.
+const maxValueCacheEntries = 256
+
+// valueCache remembers provider results by subject and prefix. It holds at
+// most maxValueCacheEntries entries.
+type valueCache struct {
+	ttl     time.Duration
+	now     func() time.Time
+	mu      sync.Mutex
+	entries map[valueCacheKey]valueCacheEntry
+}
+
+type valueCacheKey struct {
+	subject string
+	prefix  string
+}
+
+type valueCacheEntry struct {
+	values  []string
+	expires time.Time
+}
+
+func newValueCache(ttl time.Duration) *valueCache {
+	return &valueCache{ttl: ttl, now: time.Now, entries: map[valueCacheKey]valueCacheEntry{}}
+}
+
+func (c *valueCache) get(key valueCacheKey) ([]string, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !c.now().Before(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.values, true
+}
+
+func (c *valueCache) put(key valueCacheKey, values []string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= maxValueCacheEntries {
+		c.evict(now)
+	}
+	c.entries[key] = valueCacheEntry{values: values, expires: now.Add(c.ttl)}
+}
+
+// evict removes the expired entries, or if there are none, the entry that
+// expires first, which is the oldest.
+func (c *valueCache) evict(now time.Time) {
+	var oldest valueCacheKey
+	var oldestExpires time.Time
+	for key, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, key)
+		} else if oldestExpires.IsZero() || entry.expires.Before(oldestExpires) {
+			oldest, oldestExpires = key, entry.expires
+		}
+	}
+	if len(c.entries) >= maxValueCacheEntries {
+		delete(c.entries, oldest)
+	}
+}
+
+// providedValues asks the subject's provider for values starting with input,
+// and returns them as they are written in a query. It reports false if the
+// subject has no provider.
+func (e *CompletionEngine) providedValues(ctx context.Context, subject Subject, input string) ([]string, bool, error) {
+	provider, ok := e.providers[toLowerCase(subject.Name)]
+	if !ok {
+		return nil, false, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, true, err
+	}
+
+	prefix := unquoteValue(input)
+	values, err := e.fetchValues(ctx, provider, subject, prefix)
+	if err != nil {
+		return nil, true, err
+	}
+
+	suggestions := make([]string, 0, len(values))
+	for _, value := range withPrefix(prefix, values...) {
+		if e.maxValues > 0 && len(suggestions) == e.maxValues {
+			break
+		}
+		suggestions = append(suggestions, formatValue(subject, value))
+	}
+	return suggestions, true, nil
+}
+
+// fetchValues returns the provider's values for prefix, from the cache if
+// they are there.
+func (e *CompletionEngine) fetchValues(ctx context.Context, provider ValueProvider, subject Subject, prefix string) ([]string, error) {
+	key := valueCacheKey{subject: subject.Name, prefix: prefix}
+	if values, ok := e.valueCache.get(key); ok {
+		return values, nil
+	}
+	values, err := provider.Values(ctx, ValueRequest{Subject: subject.Name, Prefix: prefix, Limit: e.maxValues})
+	if err != nil {
+		return nil, err
+	}
+	e.valueCache.put(key, values)
+	return values, nil
+}
+
+// formatValue writes a provided value as it appears in a query: quoted for
+// subjects that take strings, as is otherwise.
+func formatValue(subject Subject, value string) string {
+	if subject.acceptsType(DTypeString) {
+		return quoteString(value)
+	}
+	return value
+}
+
+// unquoteValue strips the quotes from a value typed so far.
+func unquoteValue(s string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(s, `"`), `"`)
+}
+
+// quoteString writes s as an NTQL string literal.
+func quoteString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
@@ -0,0 +1,139 @@
+package ntql
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestValueProviderSuggestions(t *testing.T) {
+	var requests []ValueRequest
+	engine := NewCompletionEngineWithOptions(DefaultSchema(), CompletionOptions{
+		ValueProviders: map[string]ValueProvider{
+			"state": ValueProviderFunc(func(ctx context.Context, req ValueRequest) ([]string, error) {
+				requests = append(requests, req)
+				return []string{"open", "on hold", "closed", `say "hi"`}, nil
+			}),
+			"priority": StaticValues("1", "2", "3"),
+		},
+		MaxValues: 2,
+	})
+
+	cases := []struct {
+		query    string
+		expected []string
+	}{
+		{`status.equals(`, []string{`"open"`, `"on hold"`}},
+		{`status.equals("o`, []string{`"open"`, `"on hold"`}},
+		{`status.equals(c`, []string{`"closed"`}},
+		{`status.equals(sa`, []string{`"say \"hi\""`}},
+		{`priority.eq(`, []string{"1", "2"}},
+	}
+	for _, tc := range cases {
+		suggestions, err := engine.Suggest(tc.query)
+		if err != nil {
+			t.Fatalf("Suggest(%s) failed: %v", tc.query, err)
+		}
+		if !reflect.DeepEqual(suggestions, tc.expected) {
+			t.Fatalf("Suggest(%s) returned %q, expected %q", tc.query, suggestions, tc.expected)
+		}
+	}
+
+	expected := ValueRequest{Subject: "status", Prefix: "o", Limit: 2}
+	if requests[1] != expected {
+		t.Fatalf("expected request %+v, got %+v", expected, requests[1])
+	}
+}
+
+func TestValueProviderCache(t *testing.T) {
+	calls := 0
+	engine := NewCompletionEngineWithOptions(DefaultSchema(), CompletionOptions{
+		ValueProviders: map[string]ValueProvider{
+			"status": ValueProviderFunc(func(ctx context.Context, req ValueRequest) ([]string, error) {
+				calls++
+				return []string{"open"}, nil
+			}),
+		},
+		CacheTTL: time.Minute,
+	})
+	now := time.Date(2026, 1, 14, 10, 0, 0, 0, time.UTC)
+	engine.valueCache.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if _, err := engine.Suggest(`status.equals(`); err != nil {
+			t.Fatalf("Suggest() failed: %v", err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("expected the provider to be called once, got %d", calls)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := engine.Suggest(`status.equals(`); err != nil {
+		t.Fatalf("Suggest() failed: %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected the expired entry to be refreshed, got %d calls", calls)
+	}
+}
+
+func TestValueProviderCacheIsBounded(t *testing.T) {
+	engine := NewCompletionEngineWithOptions(DefaultSchema(), CompletionOptions{
+		ValueProviders: map[string]ValueProvider{"status": StaticValues("open")},
+		CacheTTL:       time.Hour,
+	})
+	now := time.Date(2026, 1, 14, 10, 0, 0, 0, time.UTC)
+	engine.valueCache.now = func() time.Time { return now }
+
+	for i := 0; i < 2*maxValueCacheEntries; i++ {
+		now = now.Add(time.Second)
+		if _, err := engine.Suggest(`status.equals("` + strconv.Itoa(i)); err != nil {
+			t.Fatalf("Suggest() failed: %v", err)
+		}
+	}
+	if n := len(engine.valueCache.entries); n > maxValueCacheEntries {
+		t.Fatalf("expected at most %d cached prefixes, got %d", maxValueCacheEntries, n)
+	}
+	// The most recent prefix is still cached, the first one was evicted.
+	last := valueCacheKey{subject: "status", prefix: strconv.Itoa(2*maxValueCacheEntries - 1)}
+	if _, ok := engine.valueCache.get(last); !ok {
+		t.Fatalf("expected the last prefix to be cached")
+	}
+	if _, ok := engine.valueCache.get(valueCacheKey{subject: "status", prefix: "0"}); ok {
+		t.Fatalf("expected the first prefix to be evicted")
+	}
+}
+
+func TestValueProviderErrors(t *testing.T) {
+	failure := errors.New("database unavailable")
+	engine := NewCompletionEngineWithOptions(DefaultSchema(), CompletionOptions{
+		ValueProviders: map[string]ValueProvider{
+			"status": ValueProviderFunc(func(ctx context.Context, req ValueRequest) ([]string, error) {
+				return nil, failure
+			}),
+			"project": ValueProviderFunc(func(ctx context.Context, req ValueRequest) ([]string, error) {
+				t.Fatalf("expected a cancelled context to skip the provider")
+				return nil, nil
+			}),
+		},
+	})
+
+	if _, err := engine.Suggest(`status.equals(`); !errors.Is(err, failure) {
+		t.Fatalf("expected the provider error, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := engine.SuggestContext(ctx, `project.equals(`); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	// Subjects without a provider keep the built-in suggestions.
+	suggestions, err := engine.Suggest(`due.before(to`)
+	if err != nil || !reflect.DeepEqual(suggestions, []string{"today", "tomorrow"}) {
+		t.Fatalf("unexpected suggestions %q: %v", suggestions, err)
+	}
+}
@@ -0,0 +1,210 @@
+package ntql
+
+import (
+	"errors"
+)
+
+// SkipChildren can be returned by the Enter method of a Visitor or Rewriter
+// to skip the children of the node. Leave is still called for the node.
+var SkipChildren = errors.New("skip children")
+
+// SkipAll can be returned by a Visitor or Rewriter to stop the walk early
+// without an error.
+var SkipAll = errors.New("skip all")
+
+// Visitor is called by Walk for every node of an expression.
+type Visitor interface {
+	// Enter is called before the children of expr are visited.
+	Enter(expr QueryExpr) error
+	// Leave is called after the children of expr are visited.
+	Leave(expr QueryExpr) error
+}
+
+// VisitorFuncs adapts functions to a Visitor. Either may be nil.
+type VisitorFuncs struct {
+	EnterFunc func(expr QueryExpr) error
+	LeaveFunc func(expr QueryExpr) error
+}
+
+func (v VisitorFuncs) Enter(expr QueryExpr) error {
+	if v.EnterFunc == nil {
+		return nil
+	}
+	return v.EnterFunc(expr)
+}
+
+func (v VisitorFuncs) Leave(expr QueryExpr) error {
+	if v.LeaveFunc == nil {
+		return nil
+	}
+	return v.LeaveFunc(expr)
+}
+
+// Walk visits expr and its descendants depth first: the left operand of a
+// QueryBinaryOp before the right, and the operand of a QueryUnaryOp.
+// QueryCondition and QueryError nodes have no children.
+//
+// If Enter returns SkipChildren, the children of the node are skipped. If
+// Enter or Leave returns SkipAll, the walk stops and Walk returns nil. Any
+// other error stops the walk and is returned.
+func Walk(expr QueryExpr, v Visitor) error {
+	if err := walk(expr, v); err != nil && err != SkipAll {
+		return err
+	}
+	return nil
+}
+
+func walk(expr QueryExpr, v Visitor) error {
+	err := v.Enter(expr)
+	if err == SkipChildren {
+		return v.Leave(expr)
+	}
+	if err != nil {
+		return err
+	}
+	switch node := expr.(type) {
+	case *QueryBinaryOp:
+		if err := walk(node.Left, v); err != nil {
+			return err
+		}
+		if err := walk(node.Right, v); err != nil {
+			return err
+		}
+	case *QueryUnaryOp:
+		if err := walk(node.Operand, v); err != nil {
+			return err
+		}
+	}
+	return v.Leave(expr)
+}
+
+// Inspect calls f for expr and its descendants in the order of Walk. If f
+// returns false, the children of the node are skipped.
+func Inspect(expr QueryExpr, f func(expr QueryExpr) bool) {
+	Walk(expr, VisitorFuncs{EnterFunc: func(expr QueryExpr) error {
+		if !f(expr) {
+			return SkipChildren
+		}
+		return nil
+	}})
+}
+
+// Rewriter is called by Rewrite for every node of an expression. Both methods
+// return the node to use in place of expr: expr itself to keep it, another
+// node to replace it, or nil to remove it.
+type Rewriter interface {
+	// Enter is called before the children of expr are rewritten. If it
+	// replaces expr, the children of the replacement are rewritten instead.
+	Enter(expr QueryExpr) (QueryExpr, error)
+	// Leave is called after the children of expr are rewritten, with a node
+	// that has the rewritten children.
+	Leave(expr QueryExpr) (QueryExpr, error)
+}
+
+// RewriterFuncs adapts functions to a Rewriter. Either may be nil.
+type RewriterFuncs struct {
+	EnterFunc func(expr QueryExpr) (QueryExpr, error)
+	LeaveFunc func(expr QueryExpr) (QueryExpr, error)
+}
+
+func (r RewriterFuncs) Enter(expr QueryExpr) (QueryExpr, error) {
+	if r.EnterFunc == nil {
+		return expr, nil
+	}
+	return r.EnterFunc(expr)
+}
+
+func (r RewriterFuncs) Leave(expr QueryExpr) (QueryExpr, error) {
+	if r.LeaveFunc == nil {
+		return expr, nil
+	}
+	return r.LeaveFunc(expr)
+}
+
+// Rewrite returns expr rewritten by r, visiting nodes in the order of Walk.
+//
+// The input is not modified: a QueryBinaryOp or QueryUnaryOp whose children
+// change is copied, and unchanged subtrees are shared with the result. When
+// a node is removed, a QueryBinaryOp is replaced by its other operand and a
+// QueryUnaryOp is removed too; Rewrite returns nil if the root is removed.
+//
+// If Enter returns SkipChildren with a node, that node is used without
+// rewriting its children, and Leave is still called for it. If either method
+// returns SkipAll, the rest of the expression is left as it is and Rewrite
+// returns what has been rewritten so far. Any other error stops the rewrite
+// and is returned.
+func Rewrite(expr QueryExpr, r Rewriter) (QueryExpr, error) {
+	rewritten, err := rewrite(expr, r)
+	if err != nil && err != SkipAll {
+		return nil, err
+	}
+	return rewritten, nil
+}
+
+// rewrite returns the rewritten node. With SkipAll, it returns the node as
+// rewritten so far.
+func rewrite(expr QueryExpr, r Rewriter) (QueryExpr, error) {
+	entered, err := r.Enter(expr)
+	switch {
+	case err == SkipAll:
+		return entered, err
+	case err != nil && err != SkipChildren:
+		return nil, err
+	case entered == nil:
+		return nil, nil
+	case err == nil:
+		var done bool
+		entered, done, err = rewriteChildren(entered, r)
+		if done {
+			return entered, err
+		}
+	}
+	return r.Leave(entered)
+}
+
+// rewriteChildren returns expr with its children rewritten, and reports true
+// if the walk must stop, with the error to stop it.
+func rewriteChildren(expr QueryExpr, r Rewriter) (QueryExpr, bool, error) {
+	switch node := expr.(type) {
+	case *QueryBinaryOp:
+		left, err := rewrite(node.Left, r)
+		if err == SkipAll {
+			return joinOperands(node, left, node.Right), true, err
+		}
+		if err != nil {
+			return nil, true, err
+		}
+		right, err := rewrite(node.Right, r)
+		if err != nil && err != SkipAll {
+			return nil, true, err
+		}
+		return joinOperands(node, left, right), err != nil, err
+	case *QueryUnaryOp:
+		operand, err := rewrite(node.Operand, r)
+		if err != nil && err != SkipAll {
+			return nil, true, err
+		}
+		if operand == nil {
+			return nil, true, err
+		}
+		if operand != node.Operand {
+			expr = &QueryUnaryOp{Operand: operand, Operator: node.Operator}
+		}
+		return expr, err != nil, err
+	}
+	return expr, false, nil
+}
+
+// joinOperands returns node with the given operands, copying it if they
+// changed. A removed operand is replaced by the other one.
+func joinOperands(node *QueryBinaryOp, left, right QueryExpr) QueryExpr {
+	switch {
+	case left == nil:
+		return right
+	case right == nil:
+		return left
+	case left == node.Left && right == node.Right:
+		return node
+	}
+	return &QueryBinaryOp{Left: left, Right: right, Operator: node.Operator}
+}
@@ -0,0 +1,191 @@
+package ntql
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// walkQuery is title.contains("a") AND !(tag.equals(work) OR priority.equals(1)).
+func walkQuery() QueryExpr {
+	return NewQueryAnd(
+		&QueryCondition{Field: "title", Operator: OperatorCnt, Value: "a"},
+		NewQueryNot(NewQueryOr(
+			&QueryCondition{Field: "tag", Operator: OperatorEq, Value: "work"},
+			&QueryCondition{Field: "priority", Operator: OperatorEq, Value: "1"},
+		)),
+	)
+}
+
+// walkLabel names a node for order assertions.
+func walkLabel(expr QueryExpr) string {
+	switch node := expr.(type) {
+	case *QueryCondition:
+		return node.Field
+	case *QueryBinaryOp:
+		return string(node.Operator)
+	case *QueryUnaryOp:
+		return string(node.Operator)
+	}
+	return "?"
+}
+
+func TestWalk(t *testing.T) {
+	var pre, post []string
+	err := Walk(walkQuery(), VisitorFuncs{
+		EnterFunc: func(expr QueryExpr) error {
+			pre = append(pre, walkLabel(expr))
+			return nil
+		},
+		LeaveFunc: func(expr QueryExpr) error {
+			post = append(post, walkLabel(expr))
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Walk() failed: %v", err)
+	}
+	if expected := []string{"AND", "title", "NOT", "OR", "tag", "priority"}; !slices.Equal(pre, expected) {
+		t.Fatalf("expected pre-order %v, got %v", expected, pre)
+	}
+	if expected := []string{"title", "tag", "priority", "OR", "NOT", "AND"}; !slices.Equal(post, expected) {
+		t.Fatalf("expected post-order %v, got %v", expected, post)
+	}
+}
+
+func TestWalkEarlyExit(t *testing.T) {
+	var visited []string
+	Inspect(walkQuery(), func(expr QueryExpr) bool {
+		visited = append(visited, walkLabel(expr))
+		_, ok := expr.(*QueryUnaryOp)
+		return !ok
+	})
+	if expected := []string{"AND", "title", "NOT"}; !slices.Equal(visited, expected) {
+		t.Fatalf("expected %v, got %v", expected, visited)
+	}
+
+	visited = nil
+	err := Walk(walkQuery(), VisitorFuncs{EnterFunc: func(expr QueryExpr) error {
+		visited = append(visited, walkLabel(expr))
+		if walkLabel(expr) == "tag" {
+			return SkipAll
+		}
+		return nil
+	}})
+	if err != nil {
+		t.Fatalf("expected SkipAll to stop without an error, got %v", err)
+	}
+	if expected := []string{"AND", "title", "NOT", "OR", "tag"}; !slices.Equal(visited, expected) {
+		t.Fatalf("expected %v, got %v", expected, visited)
+	}
+
+	stop := errors.New("stop")
+	err = Walk(walkQuery(), VisitorFuncs{LeaveFunc: func(QueryExpr) error {
+		return stop
+	}})
+	if err != stop {
+		t.Fatalf("expected the visitor's error, got %v", err)
+	}
+}
+
+func TestRewriteRedact(t *testing.T) {
+	query := walkQuery()
+	before := query.String()
+	redacted, err := Rewrite(query, RewriterFuncs{LeaveFunc: func(expr QueryExpr) (QueryExpr, error) {
+		if c, ok := expr.(*QueryCondition); ok && c.Field == "title" {
+			return &QueryCondition{Field: c.Field, Operator: c.Operator, Value: "***"}, nil
+		}
+		return expr, nil
+	}})
+	if err != nil {
+		t.Fatalf("Rewrite() failed: %v", err)
+	}
+	formatted, err := Format(redacted)
+	if err != nil {
+		t.Fatalf("Format() failed: %v", err)
+	}
+	if expected := `title.contains("***") AND !(tag.equals(work) OR priority.equals(1))`; formatted != expected {
+		t.Fatalf("expected %s, got %s", expected, formatted)
+	}
+	if query.String() != before {
+		t.Fatalf("expected Rewrite not to modify its input, got %s", query)
+	}
+	// The unchanged NOT subtree is shared.
+	if redacted.(*QueryBinaryOp).Right != query.(*QueryBinaryOp).Right {
+		t.Fatalf("expected unchanged subtrees to be shared")
+	}
+}
+
+func TestRewriteSubjects(t *testing.T) {
+	// Rename subjects, and drop priority conditions, removing the OR and
+	// replacing it with its other operand.
+	rewritten, err := Rewrite(walkQuery(), RewriterFuncs{EnterFunc: func(expr QueryExpr) (QueryExpr, error) {
+		c, ok := expr.(*QueryCondition)
+		switch {
+		case !ok:
+			return expr, nil
+		case c.Field == "priority":
+			return nil, nil
+		case c.Field == "tag":
+			return &QueryCondition{Field: "label", Operator: c.Operator, Value: c.Value}, nil
+		}
+		return expr, nil
+	}})
+	if err != nil {
+		t.Fatalf("Rewrite() failed: %v", err)
+	}
+	if expected := "title contains a AND NOT label equals work"; rewritten.String() != expected {
+		t.Fatalf("expected %s, got %s", expected, rewritten)
+	}
+
+	removed, err := Rewrite(walkQuery(), RewriterFuncs{LeaveFunc: func(QueryExpr) (QueryExpr, error) {
+		return nil, nil
+	}})
+	if err != nil || removed != nil {
+		t.Fatalf("expected removing every node to return nil, got %v: %v", removed, err)
+	}
+}
+
+func TestRewriteEarlyExit(t *testing.T) {
+	// SkipAll keeps the replacement and leaves the rest of the query as it is.
+	var entered []string
+	rewritten, err := Rewrite(walkQuery(), RewriterFuncs{EnterFunc: func(expr QueryExpr) (QueryExpr, error) {
+		entered = append(entered, walkLabel(expr))
+		if c, ok := expr.(*QueryCondition); ok && c.Field == "title" {
+			return &QueryCondition{Field: "title", Operator: OperatorCnt, Value: "b"}, SkipAll
+		}
+		return expr, nil
+	}})
+	if err != nil {
+		t.Fatalf("expected SkipAll to stop without an error, got %v", err)
+	}
+	if expected := []string{"AND", "title"}; !slices.Equal(entered, expected) {
+		t.Fatalf("expected %v, got %v", expected, entered)
+	}
+	formatted, _ := Format(rewritten)
+	if expected := `title.contains("b") AND !(tag.equals(work) OR priority.equals(1))`; formatted != expected {
+		t.Fatalf("expected %s, got %s", expected, formatted)
+	}
+
+	entered = nil
+	_, err = Rewrite(walkQuery(), RewriterFuncs{EnterFunc: func(expr QueryExpr) (QueryExpr, error) {
+		entered = append(entered, walkLabel(expr))
+		if _, ok := expr.(*QueryUnaryOp); ok {
+			return expr, SkipChildren
+		}
+		return expr, nil
+	}})
+	if err != nil {
+		t.Fatalf("Rewrite() failed: %v", err)
+	}
+	if expected := []string{"AND", "title", "NOT"}; !slices.Equal(entered, expected) {
+		t.Fatalf("expected %v, got %v", expected, entered)
+	}
+
+	stop := errors.New("stop")
+	if _, err := Rewrite(walkQuery(), RewriterFuncs{EnterFunc: func(QueryExpr) (QueryExpr, error) {
+		return nil, stop
+	}}); err != stop {
+		t.Fatalf("expected the rewriter's error, got %v", err)
+	}
+}